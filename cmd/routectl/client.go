@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"router-app/router"
+)
+
+// client habla con la API de administración del router
+type client struct {
+	baseURL    string
+	apiKey     string
+	hmacSecret []byte
+	// ifMatch es la revisión que se envía en If-Match en las escrituras, si no está vacía
+	ifMatch string
+	http    *http.Client
+}
+
+func newClient(p profile) *client {
+	c := &client{
+		baseURL: strings.TrimRight(p.URL, "/"),
+		apiKey:  p.APIKey,
+		http:    &http.Client{Timeout: 10 * time.Second},
+	}
+	if p.HMACSecret != "" {
+		c.hmacSecret = []byte(p.HMACSecret)
+	}
+	return c
+}
+
+// apiError es una respuesta de error del servidor
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// do envía la solicitud y decodifica la respuesta JSON en out (si no es nil)
+func (c *client) do(method, path string, in, out any) error {
+	var body []byte
+	contentType := ""
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body, contentType = data, "application/json"
+	}
+	data, err := c.send(method, path, contentType, body)
+	if err != nil || out == nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// send envía la solicitud y devuelve el cuerpo de la respuesta, o un *apiError
+// si el estado no es 2xx
+func (c *client) send(method, path, contentType string, body []byte) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	if c.ifMatch != "" && method != http.MethodGet {
+		req.Header.Set("If-Match", `"`+c.ifMatch+`"`)
+	}
+	if c.hmacSecret != nil {
+		if err := router.SignRequest(req, c.hmacSecret); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error    string   `json:"error"`
+			Problems []string `json:"problems"`
+		}
+		msg := strings.TrimSpace(string(data))
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			msg = e.Error
+			if len(e.Problems) > 0 {
+				msg += ":\n  " + strings.Join(e.Problems, "\n  ")
+			}
+		}
+		return nil, &apiError{Status: resp.StatusCode, Message: msg}
+	}
+	return data, nil
+}
+
+func routePath(tipo, key string) string {
+	return "/admin/routes/" + url.PathEscape(tipo) + "/" + url.PathEscape(key)
+}
+
+func (c *client) listRoutes(tipo string) ([]router.Route, error) {
+	path := "/admin/routes"
+	if tipo != "" {
+		path += "?tipo=" + url.QueryEscape(tipo)
+	}
+	var routes []router.Route
+	err := c.do(http.MethodGet, path, nil, &routes)
+	return routes, err
+}
+
+func (c *client) getRoute(tipo, key string) (*router.Route, error) {
+	var route router.Route
+	if err := c.do(http.MethodGet, routePath(tipo, key), nil, &route); err != nil {
+		return nil, err
+	}
+	return &route, nil
+}
+
+func (c *client) addDestino(tipo, key, destino string) error {
+	return c.do(http.MethodPost, routePath(tipo, key)+"/destinos", map[string]string{"destino": destino}, nil)
+}
+
+func (c *client) removeDestino(tipo, key, destino string) error {
+	return c.do(http.MethodDelete, routePath(tipo, key)+"/destinos?destino="+url.QueryEscape(destino), nil, nil)
+}
+
+func (c *client) deleteRoute(tipo, key string) error {
+	return c.do(http.MethodDelete, routePath(tipo, key), nil, nil)
+}
+
+func (c *client) replaceRoute(route *router.Route) (*router.Route, error) {
+	body := router.Route{Destinos: route.Destinos, Weights: route.Weights, Drained: route.Drained}
+	var out router.Route
+	if err := c.do(http.MethodPut, routePath(route.Tipo, route.Key), body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *client) setWeights(tipo, key string, weights map[string]int) error {
+	return c.do(http.MethodPut, routePath(tipo, key)+"/weights", map[string]any{"weights": weights}, nil)
+}
+
+func (c *client) setDrained(tipo, key, destino string, drained bool) error {
+	return c.do(http.MethodPost, routePath(tipo, key)+"/drain", map[string]any{"destino": destino, "drained": drained}, nil)
+}
+
+func (c *client) exportRoutes(tipo, format string) ([]byte, error) {
+	q := url.Values{"format": {format}}
+	if tipo != "" {
+		q.Set("tipo", tipo)
+	}
+	return c.send(http.MethodGet, "/admin/routes/export?"+q.Encode(), "", nil)
+}
+
+func (c *client) importRoutes(data []byte, format string, mode router.ImportMode, dryRun bool) (*router.ImportResult, error) {
+	q := url.Values{"format": {format}, "mode": {string(mode)}}
+	if dryRun {
+		q.Set("dry_run", "true")
+	}
+	body, err := c.send(http.MethodPost, "/admin/routes/import?"+q.Encode(), router.ContentType(format), data)
+	if err != nil {
+		return nil, err
+	}
+	var result router.ImportResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *client) audit(q url.Values) ([]router.AuditEntry, error) {
+	var entries []router.AuditEntry
+	err := c.do(http.MethodGet, "/admin/audit?"+q.Encode(), nil, &entries)
+	return entries, err
+}
+
+func (c *client) listVersions(tipo, key string) ([]router.RouteVersion, error) {
+	var versions []router.RouteVersion
+	err := c.do(http.MethodGet, routePath(tipo, key)+"/versions", nil, &versions)
+	return versions, err
+}
+
+func (c *client) diffVersions(tipo, key string, from, to int64) (*router.VersionDiff, error) {
+	var diff router.VersionDiff
+	path := fmt.Sprintf("%s/versions/diff?from=%d&to=%d", routePath(tipo, key), from, to)
+	if err := c.do(http.MethodGet, path, nil, &diff); err != nil {
+		return nil, err
+	}
+	return &diff, nil
+}
+
+func (c *client) rollback(tipo, key string, revision int64) (*router.Route, error) {
+	var route router.Route
+	if err := c.do(http.MethodPost, routePath(tipo, key)+"/rollback", map[string]int64{"revision": revision}, &route); err != nil {
+		return nil, err
+	}
+	return &route, nil
+}
+
+func (c *client) health() (*router.Health, error) {
+	var h router.Health
+	err := c.do(http.MethodGet, "/admin/health", nil, &h)
+	// Con Mongo caído el servidor responde 503 pero el cuerpo sigue siendo el estado
+	if e, ok := err.(*apiError); ok && e.Status == http.StatusServiceUnavailable {
+		if json.Unmarshal([]byte(e.Message), &h) == nil && h.Status != "" {
+			return &h, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
@@ -0,0 +1,547 @@
+// routectl administra las rutas de un router a través de su API de administración.
+//
+//	routectl [--profile nombre] [--url URL] [-o table|json] <comando> [args]
+//
+// La conexión sale del perfil (--profile o "current" del fichero de perfiles),
+// de ROUTECTL_URL, ROUTECTL_API_KEY y ROUTECTL_HMAC_SECRET, o de --url.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"router-app/router"
+)
+
+const usage = `Uso: routectl [flags] <comando> [args]
+
+Comandos:
+  list [--tipo T]                     lista rutas
+  get TIPO KEY                        muestra una ruta
+  add TIPO KEY DESTINO                añade un destino (crea la ruta si no existe)
+  remove TIPO KEY DESTINO             quita un destino
+  delete TIPO KEY                     borra la ruta
+  replace TIPO KEY DESTINO...         sustituye los destinos; conserva pesos y drenado de los que siguen
+  weights TIPO KEY DESTINO=PESO...    fija los pesos (el resto vuelve a 1)
+  drain [--undo] TIPO KEY DESTINO     deja de enviar tráfico nuevo a un destino
+  export [--tipo T] [--format F] [FICHERO]
+                                      exporta las rutas (jsonl o yaml) a FICHERO o a la salida
+  import [--mode merge|replace] [--dry-run] [--format F] FICHERO
+                                      importa rutas; --dry-run solo muestra el diff
+  audit [--tipo T] [--key K] [--actor A] [--since S] [--until U] [--limit N]
+                                      historial de cambios; S y U son RFC 3339 o una duración (24h = hace 24 horas)
+  history TIPO KEY                    revisiones de la ruta
+  diff TIPO KEY DESDE HASTA           cambios entre dos revisiones
+  rollback TIPO KEY REVISION          deja la ruta como estaba en REVISION
+  health                              estado del router
+
+Flags:
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run ejecuta routectl y devuelve el código de salida
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("routectl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	profilePath := fs.String("config", defaultProfilePath(), "fichero de perfiles")
+	profileName := fs.String("profile", os.Getenv("ROUTECTL_PROFILE"), "perfil a usar (env ROUTECTL_PROFILE)")
+	urlFlag := fs.String("url", "", "URL base del router")
+	output := fs.String("o", "table", "formato de salida: table o json")
+	ifMatch := fs.String("if-match", "", "revisión esperada de la ruta; la escritura falla si ha cambiado (delete y replace usan la actual si no se indica)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(stderr, "formato de salida desconocido: %s\n", *output)
+		return 2
+	}
+	p, err := resolveProfile(*profilePath, *profileName, *urlFlag)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return 1
+	}
+	cl := newClient(p)
+	cl.ifMatch = *ifMatch
+	c := &cli{client: cl, out: stdout, json: *output == "json"}
+	if err := c.dispatch(fs.Arg(0), fs.Args()[1:]); err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		if errors.Is(err, errUsage) {
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+var errUsage = errors.New("uso incorrecto, consulta routectl -h")
+
+type cli struct {
+	client *client
+	out    io.Writer
+	json   bool
+}
+
+func (c *cli) dispatch(cmd string, args []string) error {
+	switch cmd {
+	case "list":
+		fs := flag.NewFlagSet("list", flag.ContinueOnError)
+		tipo := fs.String("tipo", "", "solo las rutas de este tipo")
+		if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+			return errUsage
+		}
+		routes, err := c.client.listRoutes(*tipo)
+		if err != nil {
+			return err
+		}
+		return c.printRoutes(routes)
+	case "get":
+		if len(args) != 2 {
+			return errUsage
+		}
+		route, err := c.client.getRoute(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		return c.printRoute(route)
+	case "add":
+		if len(args) != 3 {
+			return errUsage
+		}
+		return c.done(c.client.addDestino(args[0], args[1], args[2]), "destino añadido")
+	case "remove":
+		if len(args) != 3 {
+			return errUsage
+		}
+		return c.done(c.client.removeDestino(args[0], args[1], args[2]), "destino quitado")
+	case "delete":
+		if len(args) != 2 {
+			return errUsage
+		}
+		if c.client.ifMatch == "" {
+			route, err := c.client.getRoute(args[0], args[1])
+			if err != nil {
+				return err
+			}
+			c.client.ifMatch = strconv.FormatInt(route.Revision, 10)
+		}
+		return c.done(c.client.deleteRoute(args[0], args[1]), "ruta borrada")
+	case "replace":
+		if len(args) < 3 {
+			return errUsage
+		}
+		route, err := c.client.getRoute(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		if c.client.ifMatch == "" {
+			c.client.ifMatch = strconv.FormatInt(route.Revision, 10)
+		}
+		next := &router.Route{Key: route.Key, Tipo: route.Tipo, Destinos: args[2:]}
+		for _, w := range route.Weights {
+			if contains(next.Destinos, w.Destino) {
+				next.Weights = append(next.Weights, w)
+			}
+		}
+		for _, d := range route.Drained {
+			if contains(next.Destinos, d) {
+				next.Drained = append(next.Drained, d)
+			}
+		}
+		updated, err := c.client.replaceRoute(next)
+		if err != nil {
+			return err
+		}
+		return c.printRoute(updated)
+	case "weights":
+		if len(args) < 3 {
+			return errUsage
+		}
+		weights := make(map[string]int, len(args)-2)
+		for _, arg := range args[2:] {
+			i := strings.LastIndex(arg, "=")
+			if i <= 0 {
+				return fmt.Errorf("'%s' no es DESTINO=PESO", arg)
+			}
+			w, err := strconv.Atoi(arg[i+1:])
+			if err != nil {
+				return fmt.Errorf("'%s' no es DESTINO=PESO", arg)
+			}
+			weights[arg[:i]] = w
+		}
+		return c.done(c.client.setWeights(args[0], args[1], weights), "pesos actualizados")
+	case "drain":
+		fs := flag.NewFlagSet("drain", flag.ContinueOnError)
+		undo := fs.Bool("undo", false, "vuelve a enviar tráfico al destino")
+		if err := fs.Parse(args); err != nil || fs.NArg() != 3 {
+			return errUsage
+		}
+		msg := "destino drenado"
+		if *undo {
+			msg = "destino reactivado"
+		}
+		return c.done(c.client.setDrained(fs.Arg(0), fs.Arg(1), fs.Arg(2), !*undo), msg)
+	case "export":
+		fs := flag.NewFlagSet("export", flag.ContinueOnError)
+		tipo := fs.String("tipo", "", "solo las rutas de este tipo")
+		format := fs.String("format", "", "jsonl o yaml (por defecto según la extensión del fichero, o jsonl)")
+		if err := fs.Parse(args); err != nil || fs.NArg() > 1 {
+			return errUsage
+		}
+		f, err := routesFormat(*format, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		data, err := c.client.exportRoutes(*tipo, f)
+		if err != nil {
+			return err
+		}
+		if fs.NArg() == 0 || fs.Arg(0) == "-" {
+			_, err = c.out.Write(data)
+			return err
+		}
+		return os.WriteFile(fs.Arg(0), data, 0o644)
+	case "import":
+		fs := flag.NewFlagSet("import", flag.ContinueOnError)
+		mode := fs.String("mode", string(router.ImportMerge), "merge conserva las rutas que no están en el fichero, replace las borra")
+		dryRun := fs.Bool("dry-run", false, "muestra los cambios sin aplicarlos")
+		format := fs.String("format", "", "jsonl o yaml (por defecto según la extensión del fichero, o jsonl)")
+		if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+			return errUsage
+		}
+		f, err := routesFormat(*format, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		var data []byte
+		if fs.Arg(0) == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(fs.Arg(0))
+		}
+		if err != nil {
+			return err
+		}
+		result, err := c.client.importRoutes(data, f, router.ImportMode(*mode), *dryRun)
+		if err != nil {
+			return err
+		}
+		return c.printImport(result)
+	case "audit":
+		fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+		q := url.Values{}
+		for _, name := range []string{"tipo", "key", "actor", "since", "until", "limit"} {
+			name := name
+			fs.Func(name, "filtra por "+name, func(v string) error {
+				if name == "since" || name == "until" {
+					t, err := parseTime(v)
+					if err != nil {
+						return err
+					}
+					v = t.Format(time.RFC3339)
+				}
+				q.Set(name, v)
+				return nil
+			})
+		}
+		if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+			return errUsage
+		}
+		entries, err := c.client.audit(q)
+		if err != nil {
+			return err
+		}
+		return c.printAudit(entries)
+	case "history":
+		if len(args) != 2 {
+			return errUsage
+		}
+		versions, err := c.client.listVersions(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		return c.printVersions(versions)
+	case "diff":
+		if len(args) != 4 {
+			return errUsage
+		}
+		from, err1 := strconv.ParseInt(args[2], 10, 64)
+		to, err2 := strconv.ParseInt(args[3], 10, 64)
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("las revisiones deben ser números: %w", errUsage)
+		}
+		diff, err := c.client.diffVersions(args[0], args[1], from, to)
+		if err != nil {
+			return err
+		}
+		if c.json {
+			return c.printJSON(diff)
+		}
+		if len(diff.Changes) == 0 {
+			fmt.Fprintf(c.out, "Las revisiones %d y %d son iguales\n", from, to)
+			return nil
+		}
+		return c.printChanges(diff.Changes)
+	case "rollback":
+		if len(args) != 3 {
+			return errUsage
+		}
+		rev, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("la revisión debe ser un número: %w", errUsage)
+		}
+		route, err := c.client.rollback(args[0], args[1], rev)
+		if err != nil {
+			return err
+		}
+		if !c.json {
+			fmt.Fprintf(c.out, "Ruta restaurada a la revisión %d (nueva revisión %d)\n\n", rev, route.Revision)
+		}
+		return c.printRoute(route)
+	case "health":
+		if len(args) != 0 {
+			return errUsage
+		}
+		h, err := c.client.health()
+		if err != nil {
+			return err
+		}
+		if err := c.printHealth(h); err != nil {
+			return err
+		}
+		if h.Status != "ok" {
+			return fmt.Errorf("el router está %s", h.Status)
+		}
+		return nil
+	default:
+		return fmt.Errorf("comando desconocido '%s': %w", cmd, errUsage)
+	}
+}
+
+// routesFormat elige el formato de export/import: el de --format o el que
+// indique la extensión del fichero, jsonl si no hay ninguno
+func routesFormat(flagValue, file string) (string, error) {
+	if flagValue != "" {
+		if f := router.FormatFromName(flagValue); f != "" {
+			return f, nil
+		}
+		return "", fmt.Errorf("formato desconocido '%s', usa jsonl o yaml", flagValue)
+	}
+	if f := router.FormatFromName(file); f != "" {
+		return f, nil
+	}
+	return router.FormatJSONL, nil
+}
+
+// parseTime acepta un instante RFC 3339 o una duración hacia atrás desde ahora
+func parseTime(v string) (time.Time, error) {
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("'%s' no es un instante RFC 3339 ni una duración", v)
+	}
+	return t, nil
+}
+
+// done informa del resultado de una operación sin datos de respuesta
+func (c *cli) done(err error, msg string) error {
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(map[string]string{"status": "ok", "message": msg})
+	}
+	fmt.Fprintln(c.out, msg)
+	return nil
+}
+
+func (c *cli) printJSON(v any) error {
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (c *cli) printRoutes(routes []router.Route) error {
+	if c.json {
+		return c.printJSON(routes)
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIPO\tKEY\tDESTINOS\tACTIVOS")
+	for _, r := range routes {
+		active := 0
+		for _, d := range r.Destinos {
+			if !r.IsDrained(d) && r.Weight(d) > 0 {
+				active++
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\n", r.Tipo, r.Key, len(r.Destinos), active)
+	}
+	return tw.Flush()
+}
+
+func (c *cli) printRoute(r *router.Route) error {
+	if c.json {
+		return c.printJSON(r)
+	}
+	fmt.Fprintf(c.out, "Tipo:     %s\nKey:      %s\nRevisión: %d\n\n", r.Tipo, r.Key, r.Revision)
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DESTINO\tPESO\tESTADO")
+	destinos := append([]string(nil), r.Destinos...)
+	sort.Strings(destinos)
+	for _, d := range destinos {
+		state := "activo"
+		if r.IsDrained(d) {
+			state = "drenado"
+		} else if r.Weight(d) == 0 {
+			state = "peso 0"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", d, r.Weight(d), state)
+	}
+	return tw.Flush()
+}
+
+func (c *cli) printHealth(h *router.Health) error {
+	if c.json {
+		return c.printJSON(h)
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Estado:\t%s\n", h.Status)
+	fmt.Fprintf(tw, "MongoDB:\t%s\n", h.Mongo)
+	fmt.Fprintf(tw, "Rutas en caché:\t%d\n", h.CachedRoutes)
+	last := "nunca"
+	if !h.LastRefresh.IsZero() {
+		last = h.LastRefresh.Local().Format(time.RFC3339) + " (hace " + time.Since(h.LastRefresh).Round(time.Second).String() + ")"
+	}
+	fmt.Fprintf(tw, "Último refresco:\t%s\n", last)
+	return tw.Flush()
+}
+
+func (c *cli) printImport(res *router.ImportResult) error {
+	if c.json {
+		return c.printJSON(res)
+	}
+	if len(res.Changes) > 0 {
+		if err := c.printChanges(res.Changes); err != nil {
+			return err
+		}
+		fmt.Fprintln(c.out)
+	}
+	verb := "Aplicado"
+	if res.DryRun {
+		verb = "Simulación (no se ha aplicado nada)"
+	}
+	fmt.Fprintf(c.out, "%s, modo %s: %d añadidas, %d cambiadas, %d borradas, %d sin cambios\n",
+		verb, res.Mode, res.Added, res.Changed, res.Removed, res.Unchanged)
+	return nil
+}
+
+// printChanges muestra una tabla de cambios con los destinos añadidos (+) y quitados (-)
+func (c *cli) printChanges(changes []router.RouteChange) error {
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACCION\tTIPO\tKEY\tDETALLE")
+	for _, ch := range changes {
+		var detail []string
+		for _, d := range ch.AddedDestinos {
+			detail = append(detail, "+"+d)
+		}
+		for _, d := range ch.RemovedDestinos {
+			detail = append(detail, "-"+d)
+		}
+		if ch.WeightsChanged {
+			detail = append(detail, "pesos")
+		}
+		if ch.DrainedChanged {
+			detail = append(detail, "drenado")
+		}
+		if ch.OwnerChanged {
+			detail = append(detail, "propietario")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", ch.Action, ch.Tipo, ch.Key, strings.Join(detail, " "))
+	}
+	return tw.Flush()
+}
+
+func (c *cli) printVersions(versions []router.RouteVersion) error {
+	if c.json {
+		return c.printJSON(versions)
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "REVISION\tFECHA\tAUTOR\tOPERACION\tDESTINOS")
+	for _, v := range versions {
+		destinos := strings.Join(v.Destinos, " ")
+		if v.Deleted {
+			destinos = "(borrada)"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", v.Revision, v.Timestamp.Local().Format(time.RFC3339),
+			v.Author, v.Operation, destinos)
+	}
+	return tw.Flush()
+}
+
+func (c *cli) printAudit(entries []router.AuditEntry) error {
+	if c.json {
+		return c.printJSON(entries)
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FECHA\tACTOR\tIP\tOPERACION\tTIPO\tKEY\tDESTINOS")
+	for _, e := range entries {
+		var before, after []string
+		if e.Before != nil {
+			before = e.Before.Destinos
+		}
+		if e.After != nil {
+			after = e.After.Destinos
+		}
+		var detail []string
+		for _, d := range after {
+			if !contains(before, d) {
+				detail = append(detail, "+"+d)
+			}
+		}
+		for _, d := range before {
+			if !contains(after, d) {
+				detail = append(detail, "-"+d)
+			}
+		}
+		if len(detail) == 0 {
+			detail = append(detail, "=")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Timestamp.Local().Format(time.RFC3339),
+			e.Actor, e.ClientIP, e.Operation, e.Tipo, e.Key, strings.Join(detail, " "))
+	}
+	return tw.Flush()
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"router-app/config"
+	"router-app/router"
+)
+
+// memRepo es un router.Repository en memoria con la misma semántica de
+// revisiones que el de Mongo: cada escritura incrementa la revisión, que se
+// conserva tras un borrado, y WithExpectedRevision condiciona la escritura
+type memRepo struct {
+	mu        sync.Mutex
+	routes    map[string]router.Route
+	revisions map[string]int64
+	versions  map[string][]router.RouteVersion
+}
+
+func newMemRepo(routes ...router.Route) *memRepo {
+	m := &memRepo{
+		routes:    make(map[string]router.Route),
+		revisions: make(map[string]int64),
+		versions:  make(map[string][]router.RouteVersion),
+	}
+	for _, r := range routes {
+		m.write(context.Background(), r.Key, r.Tipo, "seed", true, func(cur *router.Route) {
+			cur.Destinos, cur.Weights, cur.Drained = r.Destinos, r.Weights, r.Drained
+		})
+	}
+	return m
+}
+
+func memID(key, tipo string) string {
+	return tipo + "/" + key
+}
+
+// write aplica change a la ruta, creándola si create y no existe, y devuelve
+// la ruta resultante
+func (m *memRepo) write(ctx context.Context, key, tipo, op string, create bool, change func(*router.Route)) (*router.Route, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := memID(key, tipo)
+	cur, exists := m.routes[id]
+	if revs, ok := router.ExpectedRevision(ctx); ok {
+		if !exists {
+			return nil, router.ErrRouteNotFound
+		}
+		if !slices.Contains(revs, cur.Revision) {
+			return nil, router.ErrRevisionMismatch
+		}
+	}
+	if !exists {
+		if !create {
+			return nil, router.ErrRouteNotFound
+		}
+		cur = router.Route{Key: key, Tipo: tipo}
+	}
+	change(&cur)
+	m.revisions[id]++
+	cur.Revision = m.revisions[id]
+	m.routes[id] = cur
+	m.versions[id] = append(m.versions[id], router.RouteVersion{Route: cur, Timestamp: time.Now(), Operation: op})
+	return &cur, nil
+}
+
+func (m *memRepo) GetRoute(_ context.Context, key, tipo string) (*router.Route, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.routes[memID(key, tipo)]
+	if !ok {
+		return nil, router.ErrRouteNotFound
+	}
+	return &r, nil
+}
+
+func (m *memRepo) SaveRoute(ctx context.Context, key, tipo, destino string) error {
+	_, err := m.write(ctx, key, tipo, "save", true, func(r *router.Route) {
+		if !slices.Contains(r.Destinos, destino) {
+			r.Destinos = append(r.Destinos, destino)
+		}
+	})
+	return err
+}
+
+func (m *memRepo) GetAllRoutes(ctx context.Context) ([]router.Route, error) {
+	return m.FindRoutes(ctx, "")
+}
+
+func (m *memRepo) FindRoutes(_ context.Context, tipo string) ([]router.Route, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []router.Route
+	for _, r := range m.routes {
+		if tipo == "" || r.Tipo == tipo {
+			out = append(out, r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return memID(out[i].Key, out[i].Tipo) < memID(out[j].Key, out[j].Tipo) })
+	return out, nil
+}
+
+func (m *memRepo) RemoveDestino(ctx context.Context, key, tipo, destino string) error {
+	_, err := m.write(ctx, key, tipo, "remove", false, func(r *router.Route) {
+		r.Destinos = slices.DeleteFunc(r.Destinos, func(d string) bool { return d == destino })
+	})
+	return err
+}
+
+func (m *memRepo) DeleteRoute(ctx context.Context, key, tipo string) error {
+	_, err := m.write(ctx, key, tipo, "delete", false, func(r *router.Route) {
+		r.Destinos, r.Weights, r.Drained, r.ManagedBy = nil, nil, nil, ""
+	})
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := memID(key, tipo)
+	delete(m.routes, id)
+	m.versions[id][len(m.versions[id])-1].Deleted = true
+	return nil
+}
+
+func (m *memRepo) SetWeights(ctx context.Context, key, tipo string, weights []router.DestinoWeight) error {
+	_, err := m.write(ctx, key, tipo, "weights", false, func(r *router.Route) { r.Weights = weights })
+	return err
+}
+
+func (m *memRepo) SetDrained(ctx context.Context, key, tipo, destino string, drained bool) error {
+	_, err := m.write(ctx, key, tipo, "drain", false, func(r *router.Route) {
+		r.Drained = slices.DeleteFunc(r.Drained, func(d string) bool { return d == destino })
+		if drained {
+			r.Drained = append(r.Drained, destino)
+		}
+	})
+	return err
+}
+
+func (m *memRepo) ReplaceRoute(ctx context.Context, route router.Route) (*router.Route, error) {
+	return m.write(ctx, route.Key, route.Tipo, "replace", true, func(r *router.Route) {
+		r.Destinos, r.Weights, r.Drained, r.ManagedBy = route.Destinos, route.Weights, route.Drained, route.ManagedBy
+	})
+}
+
+func (m *memRepo) ApplyRoutes(ctx context.Context, save, remove []router.Route) error {
+	for _, r := range save {
+		if _, err := m.ReplaceRoute(ctx, r); err != nil {
+			return err
+		}
+	}
+	for _, r := range remove {
+		if err := m.DeleteRoute(ctx, r.Key, r.Tipo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memRepo) ListVersions(_ context.Context, key, tipo string) ([]router.RouteVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	versions := slices.Clone(m.versions[memID(key, tipo)])
+	slices.Reverse(versions)
+	return versions, nil
+}
+
+func (m *memRepo) GetVersion(_ context.Context, key, tipo string, revision int64) (*router.RouteVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, v := range m.versions[memID(key, tipo)] {
+		if v.Revision == revision {
+			return &v, nil
+		}
+	}
+	return nil, router.ErrVersionNotFound
+}
+
+func (m *memRepo) Ping(context.Context) error {
+	return nil
+}
+
+const (
+	testAdminKey  = "admin-key"
+	testReaderKey = "reader-key"
+)
+
+// newTestServer levanta el handler real de administración, con autenticación
+// por API key, sobre repo
+func newTestServer(t *testing.T, repo router.Repository) *httptest.Server {
+	t.Helper()
+	cfg := config.Default()
+	h := router.NewHandler(router.NewService(repo, nil, cfg), cfg)
+	mux := http.NewServeMux()
+	h.RegisterAdminRoutes(mux)
+	keys := router.NewStaticKeyStore(map[string]*router.APIKeyRecord{
+		testAdminKey:  {Name: "ops", Scopes: []string{router.ScopeAdmin}},
+		testReaderKey: {Name: "viewer", Scopes: []string{router.ScopeRead}},
+	})
+	srv := httptest.NewServer(router.AuthMiddleware(router.NewAPIKeyAuthenticator(keys), mux))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// routectl ejecuta run contra srv con la API key indicada y sin fichero de perfiles
+func routectl(t *testing.T, srv *httptest.Server, apiKey string, args ...string) (code int, stdout, stderr string) {
+	t.Helper()
+	t.Setenv("ROUTECTL_URL", "")
+	t.Setenv("ROUTECTL_PROFILE", "")
+	t.Setenv("ROUTECTL_HMAC_SECRET", "")
+	t.Setenv("ROUTECTL_API_KEY", apiKey)
+	var out, errOut bytes.Buffer
+	base := []string{"--config", filepath.Join(t.TempDir(), "none.yaml"), "--url", srv.URL}
+	code = run(append(base, args...), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func seedRoutes() []router.Route {
+	return []router.Route{
+		{Key: "cliente-1", Tipo: "pagos", Destinos: []string{"http://a", "http://b"},
+			Weights: []router.DestinoWeight{{Destino: "http://a", Weight: 3}}, Drained: []string{"http://b"}},
+		{Key: "cliente-2", Tipo: "pagos", Destinos: []string{"http://c"}},
+		{Key: "cliente-1", Tipo: "envios", Destinos: []string{"http://d"}},
+	}
+}
+
+func TestList(t *testing.T) {
+	srv := newTestServer(t, newMemRepo(seedRoutes()...))
+
+	code, out, errOut := routectl(t, srv, testReaderKey, "list")
+	if code != 0 {
+		t.Fatalf("list terminó con %d: %s", code, errOut)
+	}
+	want := "TIPO    KEY        DESTINOS  ACTIVOS\n" +
+		"envios  cliente-1  1         1\n" +
+		"pagos   cliente-1  2         1\n" +
+		"pagos   cliente-2  1         1\n"
+	if out != want {
+		t.Errorf("list:\n%s\nse esperaba:\n%s", out, want)
+	}
+
+	code, out, errOut = routectl(t, srv, testReaderKey, "-o", "json", "list", "--tipo", "pagos")
+	if code != 0 {
+		t.Fatalf("list --tipo terminó con %d: %s", code, errOut)
+	}
+	var routes []router.Route
+	if err := json.Unmarshal([]byte(out), &routes); err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 || routes[0].Key != "cliente-1" || routes[1].Key != "cliente-2" {
+		t.Errorf("list --tipo pagos = %+v", routes)
+	}
+}
+
+func TestGet(t *testing.T) {
+	srv := newTestServer(t, newMemRepo(seedRoutes()...))
+
+	code, out, errOut := routectl(t, srv, testReaderKey, "get", "pagos", "cliente-1")
+	if code != 0 {
+		t.Fatalf("get terminó con %d: %s", code, errOut)
+	}
+	want := "Tipo:     pagos\nKey:      cliente-1\nRevisión: 1\n\n" +
+		"DESTINO   PESO  ESTADO\n" +
+		"http://a  3     activo\n" +
+		"http://b  1     drenado\n"
+	if out != want {
+		t.Errorf("get:\n%s\nse esperaba:\n%s", out, want)
+	}
+
+	code, _, errOut = routectl(t, srv, testReaderKey, "get", "pagos", "no-existe")
+	if code != 1 || !strings.Contains(errOut, "404") {
+		t.Errorf("get de una ruta inexistente: código %d, stderr %q", code, errOut)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	repo := newMemRepo(seedRoutes()...)
+	srv := newTestServer(t, repo)
+
+	// Sin --if-match usa la revisión actual y conserva peso y drenado de los destinos que siguen
+	code, out, errOut := routectl(t, srv, testAdminKey, "-o", "json", "replace", "pagos", "cliente-1", "http://a", "http://e")
+	if code != 0 {
+		t.Fatalf("replace terminó con %d: %s", code, errOut)
+	}
+	var written router.Route
+	if err := json.Unmarshal([]byte(out), &written); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := repo.GetRoute(context.Background(), "cliente-1", "pagos")
+	if written.Revision != 2 || stored.Revision != 2 {
+		t.Errorf("revisión devuelta %d y guardada %d, se esperaba 2", written.Revision, stored.Revision)
+	}
+	if !slices.Equal(stored.Destinos, []string{"http://a", "http://e"}) || stored.Weight("http://a") != 3 || len(stored.Drained) != 0 {
+		t.Errorf("ruta guardada = %+v", stored)
+	}
+
+	// Con una revisión que ya no es la actual el servidor responde 412 y no se escribe nada
+	code, _, errOut = routectl(t, srv, testAdminKey, "--if-match", "1", "replace", "pagos", "cliente-1", "http://z")
+	if code != 1 || !strings.Contains(errOut, "412") {
+		t.Errorf("replace con If-Match obsoleto: código %d, stderr %q", code, errOut)
+	}
+	if stored, _ := repo.GetRoute(context.Background(), "cliente-1", "pagos"); stored.Revision != 2 {
+		t.Errorf("replace con If-Match obsoleto escribió la revisión %d", stored.Revision)
+	}
+
+	code, _, _ = routectl(t, srv, testAdminKey, "--if-match", "2", "replace", "pagos", "cliente-1", "http://z")
+	if code != 0 {
+		t.Errorf("replace con If-Match actual terminó con %d", code)
+	}
+
+	// La key de solo lectura no puede sustituir rutas
+	code, _, errOut = routectl(t, srv, testReaderKey, "replace", "pagos", "cliente-1", "http://a")
+	if code != 1 || !strings.Contains(errOut, "403") {
+		t.Errorf("replace con scope read: código %d, stderr %q", code, errOut)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	repo := newMemRepo(seedRoutes()...)
+	srv := newTestServer(t, repo)
+	if code, _, errOut := routectl(t, srv, testAdminKey, "add", "pagos", "cliente-2", "http://f"); code != 0 {
+		t.Fatalf("add terminó con %d: %s", code, errOut)
+	}
+
+	code, _, errOut := routectl(t, srv, testAdminKey, "--if-match", "1", "delete", "pagos", "cliente-2")
+	if code != 1 || !strings.Contains(errOut, "412") {
+		t.Errorf("delete con If-Match obsoleto: código %d, stderr %q", code, errOut)
+	}
+	if _, err := repo.GetRoute(context.Background(), "cliente-2", "pagos"); err != nil {
+		t.Fatalf("delete con If-Match obsoleto borró la ruta: %v", err)
+	}
+
+	code, out, errOut := routectl(t, srv, testAdminKey, "delete", "pagos", "cliente-2")
+	if code != 0 || out != "ruta borrada\n" {
+		t.Fatalf("delete: código %d, stdout %q, stderr %q", code, out, errOut)
+	}
+	if _, err := repo.GetRoute(context.Background(), "cliente-2", "pagos"); err != router.ErrRouteNotFound {
+		t.Errorf("la ruta sigue existiendo tras delete: %v", err)
+	}
+
+	// Si se vuelve a crear, la revisión sigue creciendo: el ETag de antes del borrado no vale
+	if code, _, _ := routectl(t, srv, testAdminKey, "add", "pagos", "cliente-2", "http://g"); code != 0 {
+		t.Fatal("no se pudo recrear la ruta")
+	}
+	code, _, errOut = routectl(t, srv, testAdminKey, "--if-match", "2", "delete", "pagos", "cliente-2")
+	if code != 1 || !strings.Contains(errOut, "412") {
+		t.Errorf("delete con el ETag de antes del borrado: código %d, stderr %q", code, errOut)
+	}
+
+	code, _, errOut = routectl(t, srv, testAdminKey, "delete", "pagos", "no-existe")
+	if code != 1 || !strings.Contains(errOut, "404") {
+		t.Errorf("delete de una ruta inexistente: código %d, stderr %q", code, errOut)
+	}
+}
+
+func TestHistoryAndRollback(t *testing.T) {
+	repo := newMemRepo(seedRoutes()...)
+	srv := newTestServer(t, repo)
+	if code, _, errOut := routectl(t, srv, testAdminKey, "replace", "pagos", "cliente-2", "http://x"); code != 0 {
+		t.Fatalf("replace terminó con %d: %s", code, errOut)
+	}
+
+	code, out, errOut := routectl(t, srv, testAdminKey, "-o", "json", "rollback", "pagos", "cliente-2", "1")
+	if code != 0 {
+		t.Fatalf("rollback terminó con %d: %s", code, errOut)
+	}
+	var route router.Route
+	if err := json.Unmarshal([]byte(out), &route); err != nil {
+		t.Fatal(err)
+	}
+	if route.Revision != 3 || !slices.Equal(route.Destinos, []string{"http://c"}) {
+		t.Errorf("rollback = %+v, se esperaba la revisión 3 con los destinos de la 1", route)
+	}
+
+	code, out, _ = routectl(t, srv, testReaderKey, "-o", "json", "history", "pagos", "cliente-2")
+	var versions []router.RouteVersion
+	if code != 0 || json.Unmarshal([]byte(out), &versions) != nil {
+		t.Fatalf("history: código %d, salida %q", code, out)
+	}
+	var revs []int64
+	for _, v := range versions {
+		revs = append(revs, v.Revision)
+	}
+	if !slices.Equal(revs, []int64{3, 2, 1}) {
+		t.Errorf("history devuelve las revisiones %v, se esperaba [3 2 1]", revs)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	srv := newTestServer(t, newMemRepo(seedRoutes()...))
+	code, _, errOut := routectl(t, srv, "otra-key", "list")
+	if code != 1 || !strings.Contains(errOut, "401") {
+		t.Errorf("list con API key inválida: código %d, stderr %q", code, errOut)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// profile son los datos de conexión con un router
+type profile struct {
+	URL        string `yaml:"url"`
+	APIKey     string `yaml:"api_key"`
+	HMACSecret string `yaml:"hmac_secret"`
+}
+
+// profileFile es el fichero de perfiles, por defecto ~/.config/routectl/config.yaml:
+//
+//	current: prod
+//	profiles:
+//	  prod:
+//	    url: https://router.example.com
+//	    api_key: ...
+type profileFile struct {
+	Current  string             `yaml:"current"`
+	Profiles map[string]profile `yaml:"profiles"`
+}
+
+func defaultProfilePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "routectl", "config.yaml")
+}
+
+// resolveProfile combina, de menor a mayor prioridad, el perfil del fichero,
+// las variables ROUTECTL_* y los flags
+func resolveProfile(path, name, urlFlag string) (profile, error) {
+	var p profile
+	if path != "" {
+		data, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist) && name == "":
+			// Sin fichero de perfiles basta con las variables de entorno
+		case err != nil:
+			return p, err
+		default:
+			var f profileFile
+			if err := yaml.Unmarshal(data, &f); err != nil {
+				return p, fmt.Errorf("%s: %w", path, err)
+			}
+			if name == "" {
+				name = f.Current
+			}
+			if name != "" {
+				found, ok := f.Profiles[name]
+				if !ok {
+					return p, fmt.Errorf("el perfil '%s' no existe en %s", name, path)
+				}
+				p = found
+			}
+		}
+	}
+	if v := os.Getenv("ROUTECTL_URL"); v != "" {
+		p.URL = v
+	}
+	if v := os.Getenv("ROUTECTL_API_KEY"); v != "" {
+		p.APIKey = v
+	}
+	if v := os.Getenv("ROUTECTL_HMAC_SECRET"); v != "" {
+		p.HMACSecret = v
+	}
+	if urlFlag != "" {
+		p.URL = urlFlag
+	}
+	if p.URL == "" {
+		p.URL = "http://localhost:8080"
+	}
+	return p, nil
+}
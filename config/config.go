@@ -2,7 +2,6 @@ package config
 
 import (
 	"context"
-	"os"
 	"strconv"
 	"time"
 
@@ -10,72 +9,13 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var (
-	// Validaciones de parámetros
-	MaxKeyLength     = getEnvInt("MAX_KEY_LENGTH", 64)
-	MaxTipoLength    = getEnvInt("MAX_TIPO_LENGTH", 32)
-	MaxDestinoLength = getEnvInt("MAX_DESTINO_LENGTH", 256)
-	MaxBodySize      = getEnvInt("MAX_BODY_SIZE", 1024) // bytes
-
-	// Rate Limiting
-	RateLimitRequests = getEnvInt("RATE_LIMIT_REQUESTS", 100)
-	RateLimitWindow   = getEnvDuration("RATE_LIMIT_WINDOW", time.Minute)
-
-	// Circuit Breaker
-	CircuitBreakerMaxFailures = getEnvInt("CB_MAX_FAILURES", 5)
-	CircuitBreakerOpenSeconds = getEnvInt("CB_OPEN_SECONDS", 30)
-
-	// MongoDB
-	MongoURI                    = getEnvStr("MONGO_URI", "mongodb://localhost:27017")
-	MongoMaxPoolSize            = uint64(getEnvInt("MONGO_MAX_POOL_SIZE", 20))
-	MongoConnectTimeout         = getEnvDuration("MONGO_CONNECT_TIMEOUT", 5*time.Second)
-	MongoServerSelectionTimeout = getEnvDuration("MONGO_SERVER_SELECTION_TIMEOUT", 5*time.Second)
-
-	// Servidor HTTP
-	ServerPort         = getEnvStr("PORT", "8080")
-	ServerReadTimeout  = getEnvDuration("SERVER_READ_TIMEOUT", 5*time.Second)
-	ServerWriteTimeout = getEnvDuration("SERVER_WRITE_TIMEOUT", 10*time.Second)
-	ServerIdleTimeout  = getEnvDuration("SERVER_IDLE_TIMEOUT", 30*time.Second)
-
-	// Refresco de rutas
-	RoutesRefreshSeconds = getEnvInt("ROUTES_REFRESH_SECONDS", 30)
-
-	// Seguridad
-	APIKey = getEnvStr("API_KEY", "MIApi1MIAMIApi12345pi123452MIApi12345345")
-)
-
-func getEnvInt(key string, def int) int {
-	if v := os.Getenv(key); v != "" {
-		if n, err := strconv.Atoi(v); err == nil {
-			return n
-		}
-	}
-	return def
-}
-
-func getEnvStr(key, def string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
-	}
-	return def
-}
-
-func getEnvDuration(key string, def time.Duration) time.Duration {
-	if v := os.Getenv(key); v != "" {
-		if n, err := strconv.Atoi(v); err == nil {
-			return time.Duration(n) * time.Second
-		}
-	}
-	return def
-}
-
 // ConnectMongo establece la conexión con la base de datos MongoDB
-func ConnectMongo() (*mongo.Client, error) {
+func (c *Config) ConnectMongo() (*mongo.Client, error) {
 	clientOpts := options.Client().
-		ApplyURI(MongoURI).
-		SetMaxPoolSize(MongoMaxPoolSize).
-		SetConnectTimeout(MongoConnectTimeout).
-		SetServerSelectionTimeout(MongoServerSelectionTimeout)
+		ApplyURI(c.MongoURI).
+		SetMaxPoolSize(c.MongoMaxPoolSize).
+		SetConnectTimeout(c.MongoConnectTimeout).
+		SetServerSelectionTimeout(c.MongoServerSelectionTimeout)
 
 	client, err := mongo.Connect(context.TODO(), clientOpts)
 	if err != nil {
@@ -85,66 +25,66 @@ func ConnectMongo() (*mongo.Client, error) {
 	return client, nil
 }
 
-func DisconnectMongo(client *mongo.Client) error {
+func (c *Config) DisconnectMongo(client *mongo.Client) error {
 	if client == nil {
 		return nil
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), MongoConnectTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), c.MongoConnectTimeout)
 	defer cancel()
 	return client.Disconnect(ctx)
 }
 
 // IsValidKey verifica si la clave cumple con las restricciones de longitud
-func IsValidKey(key string) bool {
-	if len(key) == 0 || len(key) > MaxKeyLength {
+func (c *Config) IsValidKey(key string) bool {
+	if len(key) == 0 || len(key) > c.MaxKeyLength {
 		return false
 	}
 	return true
 }
 
 // IsValidTipo verifica si el tipo cumple con las restricciones de longitud
-func IsValidTipo(tipo string) bool {
-	if len(tipo) == 0 || len(tipo) > MaxTipoLength {
+func (c *Config) IsValidTipo(tipo string) bool {
+	if len(tipo) == 0 || len(tipo) > c.MaxTipoLength {
 		return false
 	}
 	return true
 }
 
 // IsValidDestino verifica si el destino cumple con las restricciones de longitud
-func IsValidDestino(destino string) bool {
-	if len(destino) == 0 || len(destino) > MaxDestinoLength {
+func (c *Config) IsValidDestino(destino string) bool {
+	if len(destino) == 0 || len(destino) > c.MaxDestinoLength {
 		return false
 	}
 	return true
 }
 
 // IsValidBodySize verifica si el tamaño del cuerpo de la solicitud es válido
-func IsValidBodySize(size int64) bool {
-	if size <= 0 || size > int64(MaxBodySize) {
+func (c *Config) IsValidBodySize(size int64) bool {
+	if size <= 0 || size > int64(c.MaxBodySize) {
 		return false
 	}
 	return true
 }
 
 // IsValidAPIKey verifica si la clave API es válida
-func IsValidAPIKey(apiKey string) bool {
+func (c *Config) IsValidAPIKey(apiKey string) bool {
 	if len(apiKey) == 0 {
 		return false
 	}
-	return apiKey == APIKey
+	return apiKey == c.APIKey
 }
 
 // IsValidRateLimit verifica si la solicitud cumple con las restricciones de rate limiting
-func IsValidRateLimit(requests int) bool {
-	if requests <= 0 || requests > RateLimitRequests {
+func (c *Config) IsValidRateLimit(requests int) bool {
+	if requests <= 0 || requests > c.RateLimitRequests {
 		return false
 	}
 	return true
 }
 
 // IsValidCircuitBreaker verifica si la solicitud cumple con las restricciones del circuito
-func IsValidCircuitBreaker(failures int) bool {
-	if failures < 0 || failures > CircuitBreakerMaxFailures {
+func (c *Config) IsValidCircuitBreaker(failures int) bool {
+	if failures < 0 || failures > c.CircuitBreakerMaxFailures {
 		return false
 	}
 	return true
@@ -0,0 +1,634 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"router-app/logging"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config reúne toda la configuración del servicio ya interpretada y validada.
+// Cada campo declara su clave en el fichero de configuración (key), su variable
+// de entorno (env), si es un secreto que no debe mostrarse (secret), si puede
+// cambiarse sin reiniciar (reload) y si es un tamaño en bytes (unit). Los flags
+// se llaman como la clave con guiones: rate_limit_requests → --rate-limit-requests.
+// Los secretos pueden leerse de un fichero con <ENV>_FILE, <key>_file o --<flag>-file.
+type Config struct {
+	// Validaciones de parámetros
+	MaxKeyLength     int `key:"max_key_length" env:"MAX_KEY_LENGTH" reload:"true"`
+	MaxTipoLength    int `key:"max_tipo_length" env:"MAX_TIPO_LENGTH" reload:"true"`
+	MaxDestinoLength int `key:"max_destino_length" env:"MAX_DESTINO_LENGTH" reload:"true"`
+	MaxBodySize      int `key:"max_body_size" env:"MAX_BODY_SIZE" reload:"true" unit:"bytes"` // 1024, 1KiB, 2MB...
+	// Tamaño máximo de un fichero de rutas en POST /admin/routes/import
+	MaxImportSize int `key:"max_import_size" env:"MAX_IMPORT_SIZE" reload:"true" unit:"bytes"`
+
+	// Rate Limiting
+	RateLimitRequests int           `key:"rate_limit_requests" env:"RATE_LIMIT_REQUESTS" reload:"true"`
+	RateLimitWindow   time.Duration `key:"rate_limit_window" env:"RATE_LIMIT_WINDOW" reload:"true"`
+	RateLimitBurst    int           `key:"rate_limit_burst" env:"RATE_LIMIT_BURST" reload:"true"` // 0 = igual a RATE_LIMIT_REQUESTS
+	// Límite por IP que se aplica antes de autenticar, también a las solicitudes
+	// con credenciales inválidas: "limit/window[:burst]", vacío para usar el de
+	// RATE_LIMIT_REQUESTS u "off" para desactivarlo
+	RateLimitPreAuth string `key:"rate_limit_preauth" env:"RATE_LIMIT_PREAUTH" reload:"true"`
+	// Políticas adicionales, p. ej. "por-key=apikey:1000/1m;por-tipo=tipo:100/1s"
+	RateLimitPolicies string `key:"rate_limit_policies" env:"RATE_LIMIT_POLICIES" reload:"true"`
+	// Backend: "memory" (por réplica), "mongo" (compartido) o "hybrid" (local sincronizado)
+	RateLimitBackend      string        `key:"rate_limit_backend" env:"RATE_LIMIT_BACKEND"`
+	RateLimitSyncInterval time.Duration `key:"rate_limit_sync_interval" env:"RATE_LIMIT_SYNC_INTERVAL"`
+	RateLimitMaxVisitors  int           `key:"rate_limit_max_visitors" env:"RATE_LIMIT_MAX_VISITORS"`
+
+	// Circuit Breaker
+	CircuitBreakerMaxFailures int `key:"cb_max_failures" env:"CB_MAX_FAILURES" reload:"true"`
+	CircuitBreakerOpenSeconds int `key:"cb_open_seconds" env:"CB_OPEN_SECONDS" reload:"true"`
+
+	// MongoDB
+	MongoURI                    string        `key:"mongo_uri" env:"MONGO_URI" secret:"userinfo"`
+	MongoMaxPoolSize            uint64        `key:"mongo_max_pool_size" env:"MONGO_MAX_POOL_SIZE"`
+	MongoConnectTimeout         time.Duration `key:"mongo_connect_timeout" env:"MONGO_CONNECT_TIMEOUT"`
+	MongoServerSelectionTimeout time.Duration `key:"mongo_server_selection_timeout" env:"MONGO_SERVER_SELECTION_TIMEOUT"`
+
+	// Servidor HTTP
+	MetricsPath        string        `key:"metrics_path" env:"METRICS_PATH"`       // vacío desactiva el endpoint
+	TrustedProxies     string        `key:"trusted_proxies" env:"TRUSTED_PROXIES"` // CIDRs separados por comas
+	ServerPort         string        `key:"port" env:"PORT"`
+	ServerReadTimeout  time.Duration `key:"server_read_timeout" env:"SERVER_READ_TIMEOUT"`
+	ServerWriteTimeout time.Duration `key:"server_write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	ServerIdleTimeout  time.Duration `key:"server_idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+
+	// Logging
+	LogLevel  string `key:"log_level" env:"LOG_LEVEL" reload:"true"`
+	LogFormat string `key:"log_format" env:"LOG_FORMAT"`               // text o json
+	LogLevels string `key:"log_levels" env:"LOG_LEVELS" reload:"true"` // por componente, p. ej. "repository=debug,auth=warn"
+	// Access log: "json", "combined" u "off"
+	AccessLogFormat string `key:"access_log_format" env:"ACCESS_LOG_FORMAT"`
+
+	// Refresco de rutas
+	RoutesRefreshSeconds int `key:"routes_refresh_seconds" env:"ROUTES_REFRESH_SECONDS" reload:"true"`
+	// Fichero de rutas declarativo (YAML o JSON Lines) que se reconcilia con
+	// MongoDB; vacío lo desactiva. Las rutas que no aparecen en él solo se borran
+	// si las creó el propio fichero, salvo con ROUTES_FILE_PRUNE_UNMANAGED.
+	RoutesFile               string        `key:"routes_file" env:"ROUTES_FILE"`
+	RoutesFileInterval       time.Duration `key:"routes_file_interval" env:"ROUTES_FILE_INTERVAL" reload:"true"`
+	RoutesFilePruneUnmanaged bool          `key:"routes_file_prune_unmanaged" env:"ROUTES_FILE_PRUNE_UNMANAGED" reload:"true"`
+
+	// Auditoría de cambios de rutas: tiempo que se conservan las entradas (0 = siempre)
+	AuditRetention time.Duration `key:"audit_retention" env:"AUDIT_RETENTION"`
+
+	// Seguridad
+	// API key con acceso completo; sin valor por defecto, debe indicarse con API_KEY o API_KEY_FILE
+	APIKey           string        `key:"api_key" env:"API_KEY" secret:"true" reload:"true"`
+	KeyStoreCacheTTL time.Duration `key:"keystore_cache_ttl" env:"KEYSTORE_CACHE_TTL"`
+
+	// JWT (opcional, se activa al indicar el fichero JWKS)
+	JWTJWKSFile   string        `key:"jwt_jwks_file" env:"JWT_JWKS_FILE"`
+	JWTIssuer     string        `key:"jwt_issuer" env:"JWT_ISSUER"`
+	JWTAudience   string        `key:"jwt_audience" env:"JWT_AUDIENCE"`
+	JWTLeeway     time.Duration `key:"jwt_leeway" env:"JWT_LEEWAY"`
+	JWTReadScope  string        `key:"jwt_read_scope" env:"JWT_READ_SCOPE"`
+	JWTAdminScope string        `key:"jwt_admin_scope" env:"JWT_ADMIN_SCOPE"`
+	// Claim con los tipos permitidos; sin él el token no accede a ningún tipo.
+	// "off" no restringe por tipo.
+	JWTTiposClaim string `key:"jwt_tipos_claim" env:"JWT_TIPOS_CLAIM"`
+
+	// Firma HMAC de las mutaciones (opcional, se activa al indicar el secreto)
+	HMACSecret  string        `key:"hmac_secret" env:"HMAC_SECRET" secret:"true" reload:"true"`
+	HMACMaxSkew time.Duration `key:"hmac_max_skew" env:"HMAC_MAX_SKEW"`
+
+	// TLS (opcional, se activa al indicar certificado y clave)
+	TLSCertFile           string `key:"tls_cert_file" env:"TLS_CERT_FILE"`
+	TLSKeyFile            string `key:"tls_key_file" env:"TLS_KEY_FILE"`
+	TLSClientCAFile       string `key:"tls_client_ca_file" env:"TLS_CLIENT_CA_FILE"`
+	TLSClientAuth         string `key:"tls_client_auth" env:"TLS_CLIENT_AUTH"`
+	TLSClientScopes       string `key:"tls_client_scopes" env:"TLS_CLIENT_SCOPES"`
+	TLSClientDefaultScope string `key:"tls_client_default_scope" env:"TLS_CLIENT_DEFAULT_SCOPE"`
+
+	// Trazas OpenTelemetry; el exportador otlp usa las variables OTEL_EXPORTER_OTLP_*
+	TracingExporter    string  `key:"tracing_exporter" env:"TRACING_EXPORTER"` // none, otlp, stdout o file
+	TracingFile        string  `key:"tracing_file" env:"TRACING_FILE"`
+	TracingSampleRatio float64 `key:"tracing_sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+	TracingServiceName string  `key:"otel_service_name" env:"OTEL_SERVICE_NAME"`
+
+	// File es el fichero de configuración leído (--config o CONFIG_FILE), vacío si no hay
+	File string
+	// PrintConfig indica que se pidió --print-config
+	PrintConfig bool
+	// Check indica que se pidió --check: comparar ROUTES_FILE con MongoDB y terminar
+	Check bool
+}
+
+// Default devuelve la configuración por defecto, la primera capa de Load
+func Default() *Config {
+	return &Config{
+		MaxKeyLength:     64,
+		MaxTipoLength:    32,
+		MaxDestinoLength: 256,
+		MaxBodySize:      1024,
+		MaxImportSize:    16 << 20,
+
+		RateLimitRequests:     100,
+		RateLimitWindow:       time.Minute,
+		RateLimitBackend:      "memory",
+		RateLimitSyncInterval: time.Second,
+		RateLimitMaxVisitors:  100000,
+
+		CircuitBreakerMaxFailures: 5,
+		CircuitBreakerOpenSeconds: 30,
+
+		MongoURI:                    "mongodb://localhost:27017",
+		MongoMaxPoolSize:            20,
+		MongoConnectTimeout:         5 * time.Second,
+		MongoServerSelectionTimeout: 5 * time.Second,
+
+		MetricsPath:        "/metrics",
+		ServerPort:         "8080",
+		ServerReadTimeout:  5 * time.Second,
+		ServerWriteTimeout: 10 * time.Second,
+		ServerIdleTimeout:  30 * time.Second,
+
+		LogLevel:        "info",
+		LogFormat:       "text",
+		AccessLogFormat: "json",
+
+		RoutesRefreshSeconds: 30,
+		RoutesFileInterval:   10 * time.Second,
+
+		AuditRetention: 90 * 24 * time.Hour,
+
+		KeyStoreCacheTTL: 30 * time.Second,
+
+		JWTLeeway:     30 * time.Second,
+		JWTReadScope:  "routes:read",
+		JWTAdminScope: "routes:admin",
+		JWTTiposClaim: "tipos",
+
+		HMACMaxSkew: 5 * time.Minute,
+
+		TLSClientAuth:         "require",
+		TLSClientDefaultScope: "read",
+
+		TracingExporter:    "none",
+		TracingFile:        "traces.json",
+		TracingSampleRatio: 1.0,
+		TracingServiceName: "router-app",
+	}
+}
+
+// ValidationError lista todos los problemas encontrados en la configuración,
+// cada uno con la variable de entorno, clave o flag que lo provoca
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "configuración inválida:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// problems acumula errores en lugar de detenerse en el primero
+type problems []string
+
+func (p *problems) add(env, format string, args ...any) {
+	*p = append(*p, env+": "+fmt.Sprintf(format, args...))
+}
+
+func (p *problems) check(ok bool, env, format string, args ...any) {
+	if !ok {
+		p.add(env, format, args...)
+	}
+}
+
+// setting describe un campo de Config a partir de sus etiquetas
+type setting struct {
+	index  int
+	key    string
+	env    string
+	flag   string
+	secret string
+	reload bool
+	bytes  bool
+}
+
+// settings recorre los campos etiquetados de Config en orden de declaración
+func settings() []setting {
+	t := reflect.TypeOf(Config{})
+	var out []setting
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := f.Tag.Get("key")
+		if key == "" {
+			continue
+		}
+		out = append(out, setting{
+			index:  i,
+			key:    key,
+			env:    f.Tag.Get("env"),
+			flag:   strings.ReplaceAll(key, "_", "-"),
+			secret: f.Tag.Get("secret"),
+			reload: f.Tag.Get("reload") == "true",
+			bytes:  f.Tag.Get("unit") == "bytes",
+		})
+	}
+	return out
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// ParseDuration acepta duraciones de Go ("500ms", "1m30s") y, por compatibilidad
+// con versiones anteriores, enteros que se interpretan como segundos
+func ParseDuration(v string) (time.Duration, error) {
+	if n, err := strconv.Atoi(v); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' no es una duración válida (p. ej. 30, 500ms, 1m)", v)
+	}
+	return d, nil
+}
+
+// byteUnits admite unidades decimales (KB = 1000) y binarias (KiB = 1024)
+var byteUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+}
+
+// ParseByteSize interpreta tamaños como "1024", "1KiB", "2MB" o "1.5MiB"
+func ParseByteSize(v string) (int64, error) {
+	s := strings.TrimSpace(v)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	mult, ok := byteUnits[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if err != nil || !ok || n < 0 {
+		return 0, fmt.Errorf("'%s' no es un tamaño válido (p. ej. 1024, 1KiB, 2MB)", v)
+	}
+	return int64(n * mult), nil
+}
+
+// set interpreta v según el tipo del campo
+func (c *Config) set(s setting, v string) error {
+	field := reflect.ValueOf(c).Elem().Field(s.index)
+	switch {
+	case field.Type() == durationType:
+		d, err := ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case s.bytes:
+		n, err := ParseByteSize(v)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case field.Kind() == reflect.Int:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("'%s' no es un número entero", v)
+		}
+		field.SetInt(int64(n))
+	case field.Kind() == reflect.Uint64:
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("'%s' no es un número entero positivo", v)
+		}
+		field.SetUint(n)
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("'%s' no es true ni false", v)
+		}
+		field.SetBool(b)
+	case field.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("'%s' no es un número", v)
+		}
+		field.SetFloat(f)
+	default:
+		field.SetString(v)
+	}
+	return nil
+}
+
+// get devuelve el valor del campo en un formato que acepta set
+func (c *Config) get(s setting) string {
+	return fmt.Sprint(reflect.ValueOf(c).Elem().Field(s.index).Interface())
+}
+
+// Load construye la configuración por capas: valores por defecto, fichero de
+// configuración (--config o CONFIG_FILE; YAML, TOML o JSON según la extensión),
+// variables de entorno y por último los flags de args. Si hay problemas en
+// cualquiera de las capas o en la validación devuelve un *ValidationError con
+// todos ellos.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	all := settings()
+
+	fs := flag.NewFlagSet("router-app", flag.ContinueOnError)
+	fs.StringVar(&cfg.File, "config", os.Getenv("CONFIG_FILE"), "fichero de configuración YAML, TOML o JSON (env CONFIG_FILE)")
+	fs.BoolVar(&cfg.PrintConfig, "print-config", false, "muestra la configuración efectiva sin secretos y termina")
+	fs.BoolVar(&cfg.Check, "check", false, "compara ROUTES_FILE con MongoDB y termina con código 1 si difieren")
+	flagValues := make(map[string]*string, len(all))
+	for _, s := range all {
+		flagValues[s.flag] = fs.String(s.flag, "", fmt.Sprintf("sobrescribe %s (env %s)", s.key, s.env))
+		if s.secret != "" {
+			flagValues[s.flag+"-file"] = fs.String(s.flag+"-file", "", fmt.Sprintf("lee %s de un fichero (env %s_FILE)", s.key, s.env))
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	var p problems
+	if cfg.File != "" {
+		values, err := readFile(cfg.File)
+		if err != nil {
+			p.add(cfg.File, "%v", err)
+		}
+		for _, s := range all {
+			v, ok := values[s.key]
+			path, fromFile := values[s.key+"_file"]
+			if s.secret != "" && fromFile {
+				delete(values, s.key+"_file")
+				if ok {
+					p.add(cfg.File, "%s y %s_file son excluyentes", s.key, s.key)
+				}
+				v, err = readSecretFile(path)
+				if err != nil {
+					p.add(cfg.File, "%s_file: %v", s.key, err)
+					continue
+				}
+				ok = true
+			}
+			if ok {
+				if err := cfg.set(s, v); err != nil {
+					p.add(cfg.File, "%s: %v", s.key, err)
+				}
+				delete(values, s.key)
+			}
+		}
+		unknown := make([]string, 0, len(values))
+		for key := range values {
+			unknown = append(unknown, key)
+		}
+		sort.Strings(unknown)
+		for _, key := range unknown {
+			p.add(cfg.File, "clave desconocida '%s'", key)
+		}
+	}
+	for _, s := range all {
+		v := os.Getenv(s.env)
+		source := s.env
+		if path := os.Getenv(s.env + "_FILE"); s.secret != "" && path != "" {
+			if v != "" {
+				p.add(s.env, "%s y %s_FILE son excluyentes", s.env, s.env)
+			}
+			source = s.env + "_FILE"
+			secret, err := readSecretFile(path)
+			if err != nil {
+				p.add(source, "%v", err)
+				continue
+			}
+			v = secret
+		}
+		if v != "" {
+			if err := cfg.set(s, v); err != nil {
+				p.add(source, "%v", err)
+			}
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range all {
+			switch f.Name {
+			case s.flag:
+				if err := cfg.set(s, *flagValues[s.flag]); err != nil {
+					p.add("--"+s.flag, "%v", err)
+				}
+			case s.flag + "-file":
+				secret, err := readSecretFile(f.Value.String())
+				if err == nil {
+					err = cfg.set(s, secret)
+				}
+				if err != nil {
+					p.add("--"+f.Name, "%v", err)
+				}
+			}
+		}
+	})
+
+	cfg.validate(&p)
+	if len(p) > 0 {
+		return nil, &ValidationError{Problems: p}
+	}
+	return cfg, nil
+}
+
+// readSecretFile lee un secreto montado como fichero (Docker/Kubernetes secrets),
+// sin el salto de línea final. Se vuelve a leer en cada recarga.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("no se pudo leer el secreto: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// readFile lee un fichero de configuración y lo aplana a clave → valor. Las
+// secciones anidadas se unen con "_", así que rate_limit: {requests: 10}
+// equivale a rate_limit_requests: 10.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&raw)
+	default:
+		return nil, fmt.Errorf("extensión no soportada, usa .yaml, .yml, .toml o .json")
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string)
+	if err := flatten("", raw, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func flatten(prefix string, in map[string]any, out map[string]string) error {
+	for k, v := range in {
+		key := strings.ToLower(k)
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+		switch val := v.(type) {
+		case map[string]any:
+			if err := flatten(key, val, out); err != nil {
+				return err
+			}
+		case nil:
+			out[key] = ""
+		case string, json.Number, int, int64, uint64, float64, bool:
+			out[key] = fmt.Sprint(val)
+		default:
+			return fmt.Errorf("%s: tipo de valor no soportado %T", key, v)
+		}
+	}
+	return nil
+}
+
+// Print escribe la configuración efectiva en YAML, reutilizable como fichero de
+// configuración, con los secretos sustituidos por [REDACTED]
+func (c *Config) Print(w io.Writer) error {
+	for _, s := range settings() {
+		v := c.get(s)
+		switch s.secret {
+		case "true":
+			if v != "" {
+				v = "[REDACTED]"
+			}
+		case "userinfo":
+			v = logging.RedactCredentials(v)
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\n", s.key, yamlValue(reflect.ValueOf(c).Elem().Field(s.index).Type(), v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// yamlValue entrecomilla textos y duraciones; una cadena JSON es un escalar YAML válido
+func yamlValue(t reflect.Type, v string) string {
+	if t.Kind() == reflect.String || t == durationType {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+// Changes compara dos configuraciones y separa las claves modificadas que se
+// pueden aplicar en caliente de las que requieren reiniciar el proceso
+func Changes(old, next *Config) (reloadable, immutable []string) {
+	for _, s := range settings() {
+		if old.get(s) == next.get(s) {
+			continue
+		}
+		if s.reload {
+			reloadable = append(reloadable, s.key)
+		} else {
+			immutable = append(immutable, s.key+" ("+s.env+")")
+		}
+	}
+	return reloadable, immutable
+}
+
+// IsHelp indica si Load terminó porque se pidió la ayuda de los flags
+func IsHelp(err error) bool {
+	return errors.Is(err, flag.ErrHelp)
+}
+
+// oneOf comprueba que v sea uno de los valores admitidos
+func oneOf(v string, allowed ...string) bool {
+	for _, a := range allowed {
+		if v == a {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) validate(p *problems) {
+	p.check(c.MaxKeyLength > 0, "MAX_KEY_LENGTH", "debe ser mayor que 0")
+	p.check(c.MaxTipoLength > 0, "MAX_TIPO_LENGTH", "debe ser mayor que 0")
+	p.check(c.MaxDestinoLength > 0, "MAX_DESTINO_LENGTH", "debe ser mayor que 0")
+	p.check(c.MaxBodySize > 0, "MAX_BODY_SIZE", "debe ser mayor que 0")
+	p.check(c.MaxImportSize > 0, "MAX_IMPORT_SIZE", "debe ser mayor que 0")
+
+	p.check(c.RateLimitRequests > 0, "RATE_LIMIT_REQUESTS", "debe ser mayor que 0")
+	p.check(c.RateLimitWindow > 0, "RATE_LIMIT_WINDOW", "debe ser mayor que 0")
+	p.check(c.RateLimitBurst >= 0, "RATE_LIMIT_BURST", "no puede ser negativo")
+	p.check(oneOf(c.RateLimitBackend, "memory", "mongo", "hybrid"), "RATE_LIMIT_BACKEND",
+		"'%s' no es memory, mongo ni hybrid", c.RateLimitBackend)
+	p.check(c.RateLimitSyncInterval > 0, "RATE_LIMIT_SYNC_INTERVAL", "debe ser mayor que 0")
+	p.check(c.RateLimitMaxVisitors > 0, "RATE_LIMIT_MAX_VISITORS", "debe ser mayor que 0")
+
+	p.check(c.CircuitBreakerMaxFailures > 0, "CB_MAX_FAILURES", "debe ser mayor que 0")
+	p.check(c.CircuitBreakerOpenSeconds > 0, "CB_OPEN_SECONDS", "debe ser mayor que 0")
+
+	p.check(IsValidMongoURI(c.MongoURI), "MONGO_URI", "no puede estar vacía")
+	p.check(c.MongoURI == "" || strings.HasPrefix(c.MongoURI, "mongodb://") || strings.HasPrefix(c.MongoURI, "mongodb+srv://"),
+		"MONGO_URI", "debe empezar por mongodb:// o mongodb+srv://")
+	p.check(IsValidMongoMaxPoolSize(c.MongoMaxPoolSize), "MONGO_MAX_POOL_SIZE", "debe estar entre 1 y 1000")
+	p.check(IsValidMongoConnectTimeout(c.MongoConnectTimeout), "MONGO_CONNECT_TIMEOUT", "debe estar entre 1 y 30 segundos")
+	p.check(IsValidMongoServerSelectionTimeout(c.MongoServerSelectionTimeout), "MONGO_SERVER_SELECTION_TIMEOUT",
+		"debe estar entre 1 y 30 segundos")
+
+	if IsValidServerPort(c.ServerPort) {
+		port, _ := strconv.Atoi(c.ServerPort)
+		p.check(port > 0 && port <= 65535, "PORT", "'%s' está fuera del rango 1-65535", c.ServerPort)
+	} else {
+		p.add("PORT", "'%s' no es un puerto numérico", c.ServerPort)
+	}
+	p.check(IsValidServerReadTimeout(c.ServerReadTimeout), "SERVER_READ_TIMEOUT", "debe estar entre 1 y 30 segundos")
+	p.check(IsValidServerWriteTimeout(c.ServerWriteTimeout), "SERVER_WRITE_TIMEOUT", "debe estar entre 1 y 30 segundos")
+	p.check(IsValidServerIdleTimeout(c.ServerIdleTimeout), "SERVER_IDLE_TIMEOUT", "debe estar entre 1 y 30 segundos")
+	p.check(c.MetricsPath == "" || strings.HasPrefix(c.MetricsPath, "/"), "METRICS_PATH", "debe empezar por /")
+
+	var level slog.Level
+	p.check(level.UnmarshalText([]byte(c.LogLevel)) == nil, "LOG_LEVEL", "'%s' no es debug, info, warn ni error", c.LogLevel)
+	p.check(oneOf(c.LogFormat, "text", "json"), "LOG_FORMAT", "'%s' no es text ni json", c.LogFormat)
+	p.check(oneOf(c.AccessLogFormat, "json", "combined", "off"), "ACCESS_LOG_FORMAT",
+		"'%s' no es json, combined ni off", c.AccessLogFormat)
+
+	p.check(IsValidRoutesRefreshSeconds(c.RoutesRefreshSeconds), "ROUTES_REFRESH_SECONDS", "debe estar entre 1 y 3600")
+	p.check(c.RoutesFileInterval > 0, "ROUTES_FILE_INTERVAL", "debe ser mayor que 0")
+	p.check(!c.Check || c.RoutesFile != "", "ROUTES_FILE", "--check necesita un fichero de rutas")
+	p.check(c.AuditRetention == 0 || c.AuditRetention >= time.Second, "AUDIT_RETENTION", "debe ser 0 (sin caducidad) o al menos 1s")
+
+	// --check no sirve tráfico, así que no necesita API key
+	p.check(c.APIKey != "" || c.Check, "API_KEY", "es obligatoria (o API_KEY_FILE)")
+	p.check(c.KeyStoreCacheTTL >= 0, "KEYSTORE_CACHE_TTL", "no puede ser negativo")
+	p.check(c.JWTLeeway >= 0, "JWT_LEEWAY", "no puede ser negativo")
+	p.check(c.HMACMaxSkew > 0, "HMAC_MAX_SKEW", "debe ser mayor que 0")
+
+	p.check((c.TLSCertFile == "") == (c.TLSKeyFile == ""), "TLS_CERT_FILE/TLS_KEY_FILE", "deben indicarse juntos")
+	p.check(c.TLSClientCAFile == "" || c.TLSCertFile != "", "TLS_CLIENT_CA_FILE", "requiere TLS_CERT_FILE y TLS_KEY_FILE")
+	p.check(oneOf(c.TLSClientAuth, "require", "request", "none"), "TLS_CLIENT_AUTH",
+		"'%s' no es require, request ni none", c.TLSClientAuth)
+
+	p.check(oneOf(c.TracingExporter, "none", "otlp", "stdout", "file"), "TRACING_EXPORTER",
+		"'%s' no es none, otlp, stdout ni file", c.TracingExporter)
+	p.check(c.TracingExporter != "file" || c.TracingFile != "", "TRACING_FILE", "es obligatorio con TRACING_EXPORTER=file")
+	p.check(c.TracingSampleRatio >= 0 && c.TracingSampleRatio <= 1, "TRACING_SAMPLE_RATIO", "debe estar entre 0 y 1")
+}
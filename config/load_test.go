@@ -0,0 +1,255 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig es la configuración por defecto más lo único obligatorio
+func validConfig() *Config {
+	cfg := Default()
+	cfg.APIKey = "test-key"
+	return cfg
+}
+
+func TestValidateDefaults(t *testing.T) {
+	var p problems
+	validConfig().validate(&p)
+	if len(p) > 0 {
+		t.Fatalf("la configuración por defecto con API_KEY debería ser válida: %v", p)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(c *Config)
+		want   string // problema esperado, el único
+	}{
+		{"max key length", func(c *Config) { c.MaxKeyLength = 0 }, "MAX_KEY_LENGTH: debe ser mayor que 0"},
+		{"max tipo length", func(c *Config) { c.MaxTipoLength = -1 }, "MAX_TIPO_LENGTH: debe ser mayor que 0"},
+		{"max destino length", func(c *Config) { c.MaxDestinoLength = 0 }, "MAX_DESTINO_LENGTH: debe ser mayor que 0"},
+		{"max body size", func(c *Config) { c.MaxBodySize = 0 }, "MAX_BODY_SIZE: debe ser mayor que 0"},
+		{"max import size", func(c *Config) { c.MaxImportSize = 0 }, "MAX_IMPORT_SIZE: debe ser mayor que 0"},
+
+		{"rate limit requests", func(c *Config) { c.RateLimitRequests = 0 }, "RATE_LIMIT_REQUESTS: debe ser mayor que 0"},
+		{"rate limit window", func(c *Config) { c.RateLimitWindow = 0 }, "RATE_LIMIT_WINDOW: debe ser mayor que 0"},
+		{"rate limit burst", func(c *Config) { c.RateLimitBurst = -1 }, "RATE_LIMIT_BURST: no puede ser negativo"},
+		{"rate limit backend", func(c *Config) { c.RateLimitBackend = "redis" }, "RATE_LIMIT_BACKEND: 'redis' no es memory, mongo ni hybrid"},
+		{"rate limit sync interval", func(c *Config) { c.RateLimitSyncInterval = 0 }, "RATE_LIMIT_SYNC_INTERVAL: debe ser mayor que 0"},
+		{"rate limit max visitors", func(c *Config) { c.RateLimitMaxVisitors = 0 }, "RATE_LIMIT_MAX_VISITORS: debe ser mayor que 0"},
+
+		{"cb max failures", func(c *Config) { c.CircuitBreakerMaxFailures = 0 }, "CB_MAX_FAILURES: debe ser mayor que 0"},
+		{"cb open seconds", func(c *Config) { c.CircuitBreakerOpenSeconds = 0 }, "CB_OPEN_SECONDS: debe ser mayor que 0"},
+
+		{"mongo uri vacía", func(c *Config) { c.MongoURI = "" }, "MONGO_URI: no puede estar vacía"},
+		{"mongo uri sin esquema", func(c *Config) { c.MongoURI = "localhost:27017" }, "MONGO_URI: debe empezar por mongodb:// o mongodb+srv://"},
+		{"mongo max pool size", func(c *Config) { c.MongoMaxPoolSize = 1001 }, "MONGO_MAX_POOL_SIZE: debe estar entre 1 y 1000"},
+		{"mongo connect timeout", func(c *Config) { c.MongoConnectTimeout = time.Minute }, "MONGO_CONNECT_TIMEOUT: debe estar entre 1 y 30 segundos"},
+		{"mongo server selection timeout", func(c *Config) { c.MongoServerSelectionTimeout = 0 }, "MONGO_SERVER_SELECTION_TIMEOUT: debe estar entre 1 y 30 segundos"},
+
+		{"puerto fuera de rango", func(c *Config) { c.ServerPort = "70000" }, "PORT: '70000' está fuera del rango 1-65535"},
+		{"puerto cero", func(c *Config) { c.ServerPort = "0" }, "PORT: '0' está fuera del rango 1-65535"},
+		{"puerto no numérico", func(c *Config) { c.ServerPort = "http" }, "PORT: 'http' no es un puerto numérico"},
+		{"server read timeout", func(c *Config) { c.ServerReadTimeout = 0 }, "SERVER_READ_TIMEOUT: debe estar entre 1 y 30 segundos"},
+		{"server write timeout", func(c *Config) { c.ServerWriteTimeout = 31 * time.Second }, "SERVER_WRITE_TIMEOUT: debe estar entre 1 y 30 segundos"},
+		{"server idle timeout", func(c *Config) { c.ServerIdleTimeout = -time.Second }, "SERVER_IDLE_TIMEOUT: debe estar entre 1 y 30 segundos"},
+		{"metrics path", func(c *Config) { c.MetricsPath = "metrics" }, "METRICS_PATH: debe empezar por /"},
+
+		{"log level", func(c *Config) { c.LogLevel = "verbose" }, "LOG_LEVEL: 'verbose' no es debug, info, warn ni error"},
+		{"log format", func(c *Config) { c.LogFormat = "xml" }, "LOG_FORMAT: 'xml' no es text ni json"},
+		{"access log format", func(c *Config) { c.AccessLogFormat = "common" }, "ACCESS_LOG_FORMAT: 'common' no es json, combined ni off"},
+
+		{"routes refresh seconds", func(c *Config) { c.RoutesRefreshSeconds = 3601 }, "ROUTES_REFRESH_SECONDS: debe estar entre 1 y 3600"},
+		{"routes file interval", func(c *Config) { c.RoutesFileInterval = 0 }, "ROUTES_FILE_INTERVAL: debe ser mayor que 0"},
+		{"check sin routes file", func(c *Config) { c.Check = true }, "ROUTES_FILE: --check necesita un fichero de rutas"},
+		{"audit retention", func(c *Config) { c.AuditRetention = time.Millisecond }, "AUDIT_RETENTION: debe ser 0 (sin caducidad) o al menos 1s"},
+
+		{"api key", func(c *Config) { c.APIKey = "" }, "API_KEY: es obligatoria (o API_KEY_FILE)"},
+		{"keystore cache ttl", func(c *Config) { c.KeyStoreCacheTTL = -time.Second }, "KEYSTORE_CACHE_TTL: no puede ser negativo"},
+		{"jwt leeway", func(c *Config) { c.JWTLeeway = -time.Second }, "JWT_LEEWAY: no puede ser negativo"},
+		{"hmac max skew", func(c *Config) { c.HMACMaxSkew = 0 }, "HMAC_MAX_SKEW: debe ser mayor que 0"},
+
+		{"tls cert sin key", func(c *Config) { c.TLSCertFile = "cert.pem" }, "TLS_CERT_FILE/TLS_KEY_FILE: deben indicarse juntos"},
+		{"tls key sin cert", func(c *Config) { c.TLSKeyFile = "key.pem" }, "TLS_CERT_FILE/TLS_KEY_FILE: deben indicarse juntos"},
+		{"tls client ca sin cert", func(c *Config) { c.TLSClientCAFile = "ca.pem" }, "TLS_CLIENT_CA_FILE: requiere TLS_CERT_FILE y TLS_KEY_FILE"},
+		{"tls client auth", func(c *Config) { c.TLSClientAuth = "optional" }, "TLS_CLIENT_AUTH: 'optional' no es require, request ni none"},
+
+		{"tracing exporter", func(c *Config) { c.TracingExporter = "jaeger" }, "TRACING_EXPORTER: 'jaeger' no es none, otlp, stdout ni file"},
+		{"tracing file", func(c *Config) { c.TracingExporter, c.TracingFile = "file", "" }, "TRACING_FILE: es obligatorio con TRACING_EXPORTER=file"},
+		{"tracing sample ratio", func(c *Config) { c.TracingSampleRatio = 1.5 }, "TRACING_SAMPLE_RATIO: debe estar entre 0 y 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.mutate(cfg)
+			var p problems
+			cfg.validate(&p)
+			if len(p) != 1 || p[0] != tt.want {
+				t.Errorf("problemas = %q, se esperaba [%q]", p, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateAcceptsEdgeValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(c *Config)
+	}{
+		{"mongo+srv", func(c *Config) { c.MongoURI = "mongodb+srv://cluster.example.com" }},
+		{"métricas desactivadas", func(c *Config) { c.MetricsPath = "" }},
+		{"auditoría sin caducidad", func(c *Config) { c.AuditRetention = 0 }},
+		{"--check sin api key", func(c *Config) { c.APIKey, c.Check, c.RoutesFile = "", true, "routes.yaml" }},
+		{"tls con cliente", func(c *Config) { c.TLSCertFile, c.TLSKeyFile, c.TLSClientCAFile = "c.pem", "k.pem", "ca.pem" }},
+		{"límites de rango", func(c *Config) {
+			c.ServerPort = "65535"
+			c.MongoMaxPoolSize = 1000
+			c.RoutesRefreshSeconds = 3600
+			c.TracingSampleRatio = 0
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.mutate(cfg)
+			var p problems
+			cfg.validate(&p)
+			if len(p) > 0 {
+				t.Errorf("problemas inesperados: %q", p)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "30", want: 30 * time.Second},
+		{in: "0", want: 0},
+		{in: "500ms", want: 500 * time.Millisecond},
+		{in: "1m30s", want: 90 * time.Second},
+		{in: "", wantErr: true},
+		{in: "abc", wantErr: true},
+		{in: "10 s", wantErr: true},
+		{in: "1.5", wantErr: true},
+		{in: "5d", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseDuration(%q) = %v, se esperaba error", tt.in, got)
+			} else if !strings.Contains(err.Error(), "'"+tt.in+"' no es una duración válida") {
+				t.Errorf("ParseDuration(%q): mensaje inesperado %q", tt.in, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseDuration(%q) = %v, %v; se esperaba %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "1024", want: 1024},
+		{in: "1KiB", want: 1024},
+		{in: "2MB", want: 2000000},
+		{in: "1.5MiB", want: 3 << 19},
+		{in: " 1 GiB ", want: 1 << 30},
+		{in: "10b", want: 10},
+		{in: "1kb", want: 1000},
+		{in: "", wantErr: true},
+		{in: "MB", wantErr: true},
+		{in: "1TB", wantErr: true},
+		{in: "1.2.3KB", wantErr: true},
+		{in: "-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseByteSize(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseByteSize(%q) = %d, se esperaba error", tt.in, got)
+			} else if !strings.Contains(err.Error(), "no es un tamaño válido") {
+				t.Errorf("ParseByteSize(%q): mensaje inesperado %q", tt.in, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseByteSize(%q) = %d, %v; se esperaba %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+// TestLoadReportsAllProblems comprueba que Load no se detiene en el primer
+// error y que cada problema lleva la variable, clave o flag que lo provoca
+func TestLoadReportsAllProblems(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "router.yaml")
+	if err := os.WriteFile(file, []byte("max_body_size: mucho\nunknown_key: 1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", file)
+	t.Setenv("API_KEY", "test-key")
+	t.Setenv("RATE_LIMIT_WINDOW", "un rato")
+	t.Setenv("LOG_FORMAT", "xml")
+
+	_, err := Load([]string{"--cb-max-failures", "cinco"})
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Load() error = %v, se esperaba *ValidationError", err)
+	}
+	want := []string{
+		file + ": max_body_size: 'mucho' no es un tamaño válido (p. ej. 1024, 1KiB, 2MB)",
+		file + ": clave desconocida 'unknown_key'",
+		"RATE_LIMIT_WINDOW: 'un rato' no es una duración válida (p. ej. 30, 500ms, 1m)",
+		"--cb-max-failures: 'cinco' no es un número entero",
+		"LOG_FORMAT: 'xml' no es text ni json",
+	}
+	if strings.Join(verr.Problems, "\n") != strings.Join(want, "\n") {
+		t.Errorf("problemas:\n%s\nse esperaba:\n%s", strings.Join(verr.Problems, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "router.json")
+	if err := os.WriteFile(file, []byte(`{"rate_limit_requests": 10, "rate_limit_window": "30s", "max_body_size": "2KiB"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(dir, "api_key")
+	if err := os.WriteFile(secret, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("API_KEY_FILE", secret)
+	t.Setenv("RATE_LIMIT_REQUESTS", "20")
+
+	cfg, err := Load([]string{"--config", file, "--rate-limit-requests", "30"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RateLimitRequests != 30 {
+		t.Errorf("RateLimitRequests = %d, el flag debería ganar a env y fichero", cfg.RateLimitRequests)
+	}
+	if cfg.RateLimitWindow != 30*time.Second {
+		t.Errorf("RateLimitWindow = %v, se esperaba el valor del fichero", cfg.RateLimitWindow)
+	}
+	if cfg.MaxBodySize != 2048 {
+		t.Errorf("MaxBodySize = %d, se esperaban 2048", cfg.MaxBodySize)
+	}
+	if cfg.APIKey != "from-file" {
+		t.Errorf("APIKey = %q, se esperaba el contenido de API_KEY_FILE sin salto de línea", cfg.APIKey)
+	}
+}
@@ -1,63 +1,280 @@
 package main
 
 import (
-	"log"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
 	"router-app/config"
+	"router-app/logging"
+	"router-app/metrics"
 	"router-app/router"
+	"router-app/tracing"
 	"time"
 )
 
 func main() {
-	port := config.ServerPort
+	// Hasta logging.Setup se usa la salida por defecto, ya con redacción de secretos
+	slog.SetDefault(logging.For("main"))
+	// El código de salida se decide aquí, después de que run cierre Mongo, las
+	// trazas y los limitadores con sus defer
+	if err := run(); err != nil {
+		slog.Error("El servidor terminó con error", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run arranca el servidor y devuelve el error que lo detiene
+func run() error {
+	// Toda la configuración se valida antes de conectar a nada
+	cfg, err := config.Load(os.Args[1:])
+	if config.IsHelp(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error en la configuración: %w", err)
+	}
+	if cfg.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			return fmt.Errorf("Error al mostrar la configuración: %w", err)
+		}
+		return nil
+	}
+	port := cfg.ServerPort
 
-	log.Printf("Intervalo de refresco de rutas: %d segundos", config.RoutesRefreshSeconds)
+	level, err := logging.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return fmt.Errorf("Error en LOG_LEVEL: %w", err)
+	}
+	componentLevels, err := logging.ParseComponentLevels(cfg.LogLevels)
+	if err != nil {
+		return fmt.Errorf("Error en LOG_LEVELS: %w", err)
+	}
+	logging.Setup(os.Stderr, cfg.LogFormat, level, componentLevels)
 
-	db, err := config.ConnectMongo()
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    cfg.TracingExporter,
+		ServiceName: cfg.TracingServiceName,
+		File:        cfg.TracingFile,
+		SampleRatio: cfg.TracingSampleRatio,
+	})
 	if err != nil {
-		log.Fatalf("Error al conectar a MongoDB: %v", err)
+		return fmt.Errorf("Error al configurar trazas: %w", err)
 	}
 	defer func() {
-		if cerr := config.DisconnectMongo(db); cerr != nil {
-			log.Printf("Error al desconectar MongoDB: %v", cerr)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Error al cerrar el exportador de trazas", "error", err)
+		}
+	}()
+
+	slog.Info("Intervalo de refresco de rutas", "segundos", cfg.RoutesRefreshSeconds)
+
+	db, err := cfg.ConnectMongo()
+	if err != nil {
+		return fmt.Errorf("Error al conectar a MongoDB: %w", err)
+	}
+	defer func() {
+		if cerr := cfg.DisconnectMongo(db); cerr != nil {
+			slog.Error("Error al desconectar MongoDB", "error", cerr)
 		}
 	}()
 
 	database := db.Database("routingdb")
 	repo := router.NewRepository(database)
-	svc := router.NewService(repo)
-	h := router.NewHandler(svc)
-
-	// Refrescar rutas periódicamente en background usando el valor de config
-	go func() {
-		ticker := time.NewTicker(time.Duration(config.RoutesRefreshSeconds) * time.Second)
-		defer ticker.Stop()
-		for range ticker.C {
-			svc.RefreshRoutes()
+	audit := router.NewMongoAuditLog(database, cfg.AuditRetention)
+	svc := router.NewService(repo, audit, cfg)
+
+	// Con ROUTES_FILE las rutas del fichero mandan sobre la base de datos
+	var reconciler *router.RoutesFileReconciler
+	if cfg.RoutesFile != "" {
+		reconciler = router.NewRoutesFileReconciler(svc, cfg)
+	}
+	if cfg.Check {
+		_, changes, err := reconciler.Plan(context.Background())
+		if err != nil {
+			return fmt.Errorf("Error al comprobar el fichero de rutas %s: %w", cfg.RoutesFile, err)
 		}
-	}()
+		enc := json.NewEncoder(os.Stdout)
+		for _, c := range changes {
+			enc.Encode(c)
+		}
+		if len(changes) > 0 {
+			return fmt.Errorf("MongoDB no coincide con el fichero de rutas %s: %d cambios", cfg.RoutesFile, len(changes))
+		}
+		slog.Info("MongoDB coincide con el fichero de rutas", "file", cfg.RoutesFile)
+		return nil
+	}
+
+	h := router.NewHandler(svc, cfg)
+
+	// Refrescar rutas periódicamente en background
+	go svc.Run(context.Background())
+	if reconciler != nil {
+		go reconciler.Run(context.Background())
+	}
+
+	// Inicializa el rate limiter: la política por IP de la configuración más las adicionales
+	policies, err := router.PoliciesFromConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("Error en RATE_LIMIT_POLICIES: %w", err)
+	}
+	// El backend compartido cae al limitador local mientras su circuit breaker esté abierto
+	limiterCtx, stopLimiters := context.WithCancel(context.Background())
+	defer stopLimiters()
+	local := router.NewMemoryLimiterBackend(limiterCtx, cfg.RateLimitMaxVisitors)
+	var backend router.LimiterBackend
+	var limiterCB *router.CircuitBreaker
+	switch cfg.RateLimitBackend {
+	case "memory":
+		backend = local
+	case "mongo", "hybrid":
+		limiterCB = router.NewCircuitBreaker(
+			"rate-limit-store",
+			cfg.CircuitBreakerMaxFailures,
+			time.Duration(cfg.CircuitBreakerOpenSeconds)*time.Second,
+		)
+		if cfg.RateLimitBackend == "mongo" {
+			backend = router.NewMongoLimiterBackend(database, local, limiterCB)
+		} else {
+			backend = router.NewHybridLimiterBackend(limiterCtx, database, local, limiterCB, cfg.RateLimitSyncInterval, cfg.RateLimitMaxVisitors)
+		}
+	default:
+		return fmt.Errorf("RATE_LIMIT_BACKEND desconocido: %q", cfg.RateLimitBackend)
+	}
+	rl := router.NewPolicyLimiter(policies, backend)
+
+	// Autenticación: API key siempre, JWT si hay un JWKS configurado. La API_KEY
+	// de configuración tiene acceso completo; el resto se definen en Mongo.
+	configKeys := router.NewConfigKeyStore(cfg.APIKey)
+	keysCtx, stopKeys := context.WithCancel(context.Background())
+	defer stopKeys()
+	keyStore := router.NewChainKeyStore(configKeys, router.NewMongoKeyStore(keysCtx, database, cfg.KeyStoreCacheTTL))
+	authenticators := []router.Authenticator{router.NewAPIKeyAuthenticator(keyStore)}
+	if cfg.JWTJWKSFile != "" {
+		jwtAuth, err := router.NewJWTAuthenticator(router.JWTOptions{
+			JWKSFile:   cfg.JWTJWKSFile,
+			Issuer:     cfg.JWTIssuer,
+			Audience:   cfg.JWTAudience,
+			Leeway:     cfg.JWTLeeway,
+			ReadScope:  cfg.JWTReadScope,
+			AdminScope: cfg.JWTAdminScope,
+			TiposClaim: cfg.JWTTiposClaim,
+		})
+		if err != nil {
+			return fmt.Errorf("Error al configurar JWT: %w", err)
+		}
+		authenticators = append(authenticators, jwtAuth)
+	}
+	if cfg.TLSClientCAFile != "" {
+		certScopes, err := router.ParseCertScopes(cfg.TLSClientScopes)
+		if err != nil {
+			return fmt.Errorf("Error al configurar mTLS: %w", err)
+		}
+		authenticators = append(authenticators, router.NewCertAuthenticator(certScopes, cfg.TLSClientDefaultScope))
+	}
+	authn := router.NewChainAuthenticator(authenticators...)
 
-	// Inicializa el rate limiter usando los parámetros de config.go
-	rl := router.NewRateLimiter(config.RateLimitRequests, config.RateLimitWindow)
+	var verifier *router.SignatureVerifier
+	if cfg.HMACSecret != "" {
+		verifier = router.NewSignatureVerifier([]byte(cfg.HMACSecret), cfg.HMACMaxSkew, router.SignatureBodyLimit(cfg))
+	}
+
+	// Recarga en caliente con SIGHUP o POST /admin/reload; los ajustes que solo
+	// se leen al arrancar (puerto, Mongo, TLS...) se rechazan. Los secretos
+	// leídos de ficheros *_FILE se vuelven a leer.
+	steps := []router.ReloadStep{
+		router.LogLevelsReloadStep, rl.ReloadStep, h.ReloadStep, svc.ReloadStep, configKeys.ReloadStep,
+	}
+	if reconciler != nil {
+		steps = append(steps, reconciler.ReloadStep)
+	}
+	if verifier != nil {
+		steps = append(steps, verifier.ReloadStep)
+	} else {
+		steps = append(steps, func(next *config.Config) (func(), error) {
+			if next.HMACSecret != "" {
+				return nil, errors.New("HMAC_SECRET: la firma solo puede activarse al reiniciar")
+			}
+			return func() {}, nil
+		})
+	}
+	if limiterCB != nil {
+		steps = append(steps, func(next *config.Config) (func(), error) {
+			return func() {
+				limiterCB.SetLimits(next.CircuitBreakerMaxFailures, time.Duration(next.CircuitBreakerOpenSeconds)*time.Second)
+			}, nil
+		})
+	}
+	reloader := router.NewReloader(cfg, func() (*config.Config, error) { return config.Load(os.Args[1:]) }, steps...)
+	go reloader.WatchSignals(context.Background())
 
 	mux := http.NewServeMux()
 	h.RegisterRoutes(mux)
+	h.RegisterAdminRoutes(mux)
+	mux.Handle("/admin/reload", router.InstrumentHandler("admin-reload", reloader))
 
-	// Aplica el middleware de rate limiting
-	handler := router.RateLimitMiddleware(rl, mux)
+	// Firma HMAC obligatoria en las mutaciones si hay secreto configurado
+	var api http.Handler = mux
+	if verifier != nil {
+		api = router.SignatureMiddleware(verifier, api)
+	}
+
+	// La IP real del cliente solo se toma de las cabeceras de reenvío de proxies de confianza
+	trusted, err := router.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("Error en TRUSTED_PROXIES: %w", err)
+	}
+
+	// El límite por IP se aplica antes de autenticar, para frenar los intentos con
+	// credenciales inválidas; las políticas por identidad, después
+	var handler http.Handler = router.PreAuthRateLimitMiddleware(rl,
+		router.AuthMiddleware(authn, router.RateLimitMiddleware(rl, api)))
+	if cfg.AccessLogFormat != "off" {
+		handler = router.AccessLogMiddleware(os.Stdout, cfg.AccessLogFormat, handler)
+	}
+	// El span de servidor envuelve todo lo anterior y ya conoce el request ID y la IP real
+	handler = router.ClientIPMiddleware(router.NewClientIPResolver(trusted), router.RequestIDMiddleware(router.TracingMiddleware(handler)))
+
+	// El endpoint de métricas queda fuera de autenticación y rate limiting para el scraper
+	if cfg.MetricsPath != "" {
+		root := http.NewServeMux()
+		root.Handle(cfg.MetricsPath, metrics.Handler())
+		root.Handle("/", handler)
+		handler = root
+	}
 
 	server := &http.Server{
 		Addr:         ":" + port,
 		Handler:      handler,
-		ReadTimeout:  config.ServerReadTimeout,
-		WriteTimeout: config.ServerWriteTimeout,
-		IdleTimeout:  config.ServerIdleTimeout,
+		ReadTimeout:  cfg.ServerReadTimeout,
+		WriteTimeout: cfg.ServerWriteTimeout,
+		IdleTimeout:  cfg.ServerIdleTimeout,
 	}
 
-	log.Printf("Servidor corriendo en :%s", port)
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatalf("Error al iniciar el servidor: %v", err)
+	if cfg.TLSCertFile != "" {
+		tlsCfg, err := router.NewServerTLSConfig(router.TLSOptions{
+			CertFile:     cfg.TLSCertFile,
+			KeyFile:      cfg.TLSKeyFile,
+			ClientCAFile: cfg.TLSClientCAFile,
+			ClientAuth:   cfg.TLSClientAuth,
+		})
+		if err != nil {
+			return fmt.Errorf("Error al configurar TLS: %w", err)
+		}
+		server.TLSConfig = tlsCfg
+		slog.Info("Servidor TLS corriendo", "port", port)
+		// Certificado y clave los sirve GetCertificate, que los recarga al rotar
+		return fmt.Errorf("Error al iniciar el servidor: %w", server.ListenAndServeTLS("", ""))
 	}
+
+	slog.Info("Servidor corriendo", "port", port)
+	// ListenAndServe solo vuelve con error
+	return fmt.Errorf("Error al iniciar el servidor: %w", server.ListenAndServe())
 }
 
 // DatabaseName is the name of the MongoDB database to use
@@ -0,0 +1,243 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// collector es cualquier métrica capaz de escribirse en formato de texto de Prometheus
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry agrupa las métricas expuestas por un endpoint
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Default es el registro en el que se dan de alta las métricas creadas con New*
+var Default = NewRegistry()
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mu.Unlock()
+}
+
+// Write escribe todas las métricas del registro en formato de texto de Prometheus
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Handler sirve las métricas del registro por defecto
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Default.Write(w)
+	})
+}
+
+// vec guarda una serie por combinación de valores de etiquetas
+type vec struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// Solo histogramas
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+func newVec(name, help, typ string, labels []string) *vec {
+	return &vec{name: name, help: help, typ: typ, labels: labels, series: make(map[string]*series)}
+}
+
+// get devuelve la serie de los valores indicados; debe llamarse con mu tomado
+func (v *vec) get(labelValues []string) *series {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("métrica %s: se esperaban %d etiquetas, llegaron %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+// sorted devuelve las series ordenadas para una salida estable; debe llamarse con mu tomado
+func (v *vec) sorted() []*series {
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]*series, len(keys))
+	for i, k := range keys {
+		out[i] = v.series[k]
+	}
+	return out
+}
+
+func (v *vec) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, helpEscaper.Replace(v.help), v.name, v.typ)
+}
+
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(names)+len(extra)/2)
+	for i, n := range names {
+		parts = append(parts, n+`="`+escapeLabel(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// helpEscaper escapa el texto de HELP, donde las comillas no llevan escape
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// CounterVec es un contador monotónico con etiquetas
+type CounterVec struct {
+	v *vec
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{v: newVec(name, help, "counter", labels)}
+	Default.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.v.mu.Lock()
+	c.v.get(labelValues).value += delta
+	c.v.mu.Unlock()
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.v.mu.Lock()
+	defer c.v.mu.Unlock()
+	c.v.writeHeader(w)
+	for _, s := range c.v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", c.v.name, formatLabels(c.v.labels, s.labelValues), formatFloat(s.value))
+	}
+}
+
+// GaugeVec es un valor que puede subir y bajar
+type GaugeVec struct {
+	v *vec
+}
+
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{v: newVec(name, help, "gauge", labels)}
+	Default.register(g)
+	return g
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.v.mu.Lock()
+	g.v.get(labelValues).value = value
+	g.v.mu.Unlock()
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.v.mu.Lock()
+	defer g.v.mu.Unlock()
+	g.v.writeHeader(w)
+	for _, s := range g.v.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", g.v.name, formatLabels(g.v.labels, s.labelValues), formatFloat(s.value))
+	}
+}
+
+// DefBuckets son los límites por defecto de los histogramas de latencia, en segundos
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// HistogramVec acumula observaciones en buckets acumulativos
+type HistogramVec struct {
+	v       *vec
+	buckets []float64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{v: newVec(name, help, "histogram", labels), buckets: buckets}
+	Default.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.v.mu.Lock()
+	defer h.v.mu.Unlock()
+	s := h.v.get(labelValues)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.buckets))
+	}
+	for i, b := range h.buckets {
+		if value <= b {
+			s.buckets[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.v.mu.Lock()
+	defer h.v.mu.Unlock()
+	h.v.writeHeader(w)
+	for _, s := range h.v.sorted() {
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.v.name, formatLabels(h.v.labels, s.labelValues, "le", formatFloat(b)), s.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.v.name, formatLabels(h.v.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.v.name, formatLabels(h.v.labels, s.labelValues), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.v.name, formatLabels(h.v.labels, s.labelValues), s.count)
+	}
+}
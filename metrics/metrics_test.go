@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"flag"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "reescribe los ficheros golden de testdata")
+
+// TestHandlerGolden compara la salida completa del endpoint con
+// testdata/handler.golden; go test -update la regenera
+func TestHandlerGolden(t *testing.T) {
+	prev := Default
+	Default = NewRegistry()
+	t.Cleanup(func() { Default = prev })
+
+	requests := NewCounterVec("test_requests_total", "Solicitudes atendidas", "method", "path")
+	requests.Inc("GET", "/route/payments")
+	requests.Add(2.5, "POST", `/add-destino/"quoted"`)
+	requests.Inc("GET", "C:\\tmp\nnueva línea")
+	requests.Inc("GET", "/route/payments")
+
+	routes := NewGaugeVec("test_cached_routes", "Rutas en memoria.\nEscapa \\ en HELP")
+	routes.Set(42)
+
+	inf := NewGaugeVec("test_extremes", "Valores especiales", "kind")
+	inf.Set(math.Inf(1), "pos")
+	inf.Set(math.Inf(-1), "neg")
+	inf.Set(1e-7, "small")
+
+	latency := NewHistogramVec("test_duration_seconds", "Duración de las solicitudes", []float64{.05, .1, 1}, "tipo")
+	for _, v := range []float64{.01, .05, .07, .5, 3} {
+		latency.Observe(v, "payments")
+	}
+	latency.Observe(.2, "search")
+	NewHistogramVec("test_empty_seconds", "Histograma sin observaciones", DefBuckets, "tipo")
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	got, _ := io.ReadAll(w.Body)
+
+	golden := filepath.Join("testdata", "handler.golden")
+	if *update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("la salida no coincide con %s:\n--- obtenido\n%s\n--- esperado\n%s", golden, got, want)
+	}
+}
+
+func TestWrongLabelCountPanics(t *testing.T) {
+	c := &CounterVec{v: newVec("test_labels_total", "", "counter", []string{"a", "b"})}
+	defer func() {
+		if recover() == nil {
+			t.Error("se esperaba un panic con un número de etiquetas distinto")
+		}
+	}()
+	c.Inc("solo-una")
+}
@@ -0,0 +1,96 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// accessInfo lo crea AccessLogMiddleware y lo completan los middlewares internos,
+// cuyo contexto no es visible desde fuera
+type accessInfo struct {
+	identity *Identity
+}
+
+type accessInfoKey struct{}
+
+// recordIdentity anota la identidad autenticada para la línea de access log
+func recordIdentity(ctx context.Context, id *Identity) {
+	if info, ok := ctx.Value(accessInfoKey{}).(*accessInfo); ok {
+		info.identity = id
+	}
+}
+
+type accessEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	ClientIP  string    `json:"client_ip"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Proto     string    `json:"proto"`
+	Status    int       `json:"status"`
+	Bytes     int       `json:"bytes"`
+	Duration  float64   `json:"duration_ms"`
+	Identity  string    `json:"identity,omitempty"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+// combined formatea la entrada en Combined Log Format, con request ID y duración al final
+func (e *accessEntry) combined() string {
+	ident := e.Identity
+	if ident == "" {
+		ident = "-"
+	}
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = strconv.Itoa(e.Bytes)
+	}
+	return fmt.Sprintf("%s - %s [%s] %q %d %s %q %q %s %.3f\n",
+		e.ClientIP, ident, e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method+" "+e.Path+" "+e.Proto, e.Status, bytes,
+		e.Referer, e.UserAgent, e.RequestID, e.Duration)
+}
+
+// AccessLogMiddleware escribe una línea por solicitud en out, en formato "json" o "combined"
+func AccessLogMiddleware(out io.Writer, format string, next http.Handler) http.Handler {
+	var mu sync.Mutex
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &accessInfo{}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessInfoKey{}, info)))
+
+		e := &accessEntry{
+			Time:      start,
+			RequestID: RequestIDFromContext(r.Context()),
+			ClientIP:  ClientIP(r),
+			Method:    r.Method,
+			Path:      r.URL.RequestURI(),
+			Proto:     r.Proto,
+			Status:    rec.Status(),
+			Bytes:     rec.bytes,
+			Duration:  float64(time.Since(start).Microseconds()) / 1000,
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+		}
+		if info.identity != nil {
+			e.Identity = info.identity.Scheme + ":" + info.identity.Subject
+		}
+		var line []byte
+		if format == "combined" {
+			line = []byte(e.combined())
+		} else {
+			line, _ = json.Marshal(e)
+			line = append(line, '\n')
+		}
+		mu.Lock()
+		out.Write(line)
+		mu.Unlock()
+	})
+}
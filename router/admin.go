@@ -0,0 +1,605 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"router-app/logging"
+)
+
+// RegisterAdminRoutes registra la API de administración que usa routectl:
+//
+//	GET    /admin/routes[?tipo=]                   lista rutas
+//	GET    /admin/routes/{tipo}/{key}              detalle de una ruta, con ETag
+//	PUT    /admin/routes/{tipo}/{key}              sustituye la ruta (If-Match obligatorio)
+//	DELETE /admin/routes/{tipo}/{key}              borra la ruta (If-Match obligatorio)
+//	POST   /admin/routes/{tipo}/{key}/destinos     añade un destino {"destino"}
+//	DELETE /admin/routes/{tipo}/{key}/destinos?destino=
+//	PUT    /admin/routes/{tipo}/{key}/weights      {"weights": {"destino": peso}}
+//	POST   /admin/routes/{tipo}/{key}/drain        {"destino", "drained"}
+//	GET    /admin/routes/{tipo}/{key}/versions     historial de revisiones
+//	GET    /admin/routes/{tipo}/{key}/versions/{revision}
+//	GET    /admin/routes/{tipo}/{key}/versions/diff?from=&to=
+//	POST   /admin/routes/{tipo}/{key}/rollback     {"revision"}
+//
+// El ETag de una ruta es su revisión. Las escrituras sobre una ruta aceptan
+// If-Match con ese valor y fallan con 412 si la ruta ha cambiado desde entonces;
+// sustituir y borrar lo exigen (428 si falta).
+//
+//	GET    /admin/routes/export[?format=jsonl|yaml&tipo=]
+//	POST   /admin/routes/import[?format=&mode=merge|replace&dry_run=true]
+//	GET    /admin/audit[?tipo=&key=&actor=&since=&until=&limit=]
+//	GET    /admin/health                           estado de Mongo y de la caché
+func (h *Handler) RegisterAdminRoutes(mux *http.ServeMux) {
+	mux.Handle("/admin/routes", InstrumentHandler("admin-routes", http.HandlerFunc(h.AdminListRoutes)))
+	mux.Handle("/admin/routes/export", InstrumentHandler("admin-export", http.HandlerFunc(h.AdminExportRoutes)))
+	mux.Handle("/admin/routes/import", InstrumentHandler("admin-import", http.HandlerFunc(h.AdminImportRoutes)))
+	mux.Handle("/admin/routes/", InstrumentHandler("admin-route", http.HandlerFunc(h.AdminRoute)))
+	mux.Handle("/admin/audit", InstrumentHandler("admin-audit", http.HandlerFunc(h.AdminAudit)))
+	mux.Handle("/admin/health", InstrumentHandler("admin-health", http.HandlerFunc(h.AdminHealth)))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeServiceError traduce los errores del servicio a códigos HTTP
+func writeServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrRouteNotFound):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvalidRoute):
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrVersionNotFound):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrRevisionMismatch):
+		writeJSONError(w, http.StatusPreconditionFailed, err.Error())
+	default:
+		handlerLog.ErrorContext(r.Context(), "Error en operación de administración", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "error interno")
+	}
+}
+
+func (h *Handler) AdminListRoutes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	routes, ok := h.readableRoutes(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, routes)
+}
+
+// readableRoutes lista las rutas del tipo pedido en ?tipo= (o todas) que la
+// identidad de la solicitud puede leer; si falla ya ha respondido
+func (h *Handler) readableRoutes(w http.ResponseWriter, r *http.Request) ([]Route, bool) {
+	tipo := r.URL.Query().Get("tipo")
+	if tipo != "" {
+		if !validateParam(tipo, h.limits.Load().maxTipoLength, validTipo) {
+			writeJSONError(w, http.StatusBadRequest, "Parámetro 'tipo' inválido")
+			return nil, false
+		}
+		if !authorize(w, r, ScopeRead, tipo) {
+			return nil, false
+		}
+	}
+	routes, err := h.svc.ListRoutes(r.Context(), tipo)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return nil, false
+	}
+	// Sin filtro de tipo solo se muestran los tipos que la identidad puede leer
+	visible := make([]Route, 0, len(routes))
+	id := IdentityFromContext(r.Context())
+	for _, route := range routes {
+		if id == nil || (id.HasScope(ScopeRead) && id.CanRead(route.Tipo)) {
+			visible = append(visible, route)
+		}
+	}
+	return visible, true
+}
+
+// AdminExportRoutes descarga la tabla de rutas en JSON Lines (por defecto) o YAML
+func (h *Handler) AdminExportRoutes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	format := FormatJSONL
+	if f := r.URL.Query().Get("format"); f != "" {
+		if format = FormatFromName(f); format == "" {
+			writeJSONError(w, http.StatusBadRequest, "Formato desconocido: "+f)
+			return
+		}
+	}
+	routes, ok := h.readableRoutes(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", ContentType(format))
+	w.Header().Set("Content-Disposition", `attachment; filename="routes.`+format+`"`)
+	if err := WriteRoutes(w, routes, format); err != nil {
+		handlerLog.ErrorContext(r.Context(), "Error exportando rutas", "error", err)
+		return
+	}
+	handlerLog.InfoContext(r.Context(), "Rutas exportadas", "rutas", len(routes), "format", format)
+}
+
+// AdminImportRoutes importa una tabla de rutas. Con mode=merge se sustituyen o
+// crean las rutas del fichero y el resto queda igual; con mode=replace además se
+// borran las que no aparecen. Con dry_run=true solo se devuelve el diff.
+func (h *Handler) AdminImportRoutes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	ctx := r.Context()
+	q := r.URL.Query()
+	mode := ImportMode(q.Get("mode"))
+	if mode == "" {
+		mode = ImportMerge
+	}
+	if mode != ImportMerge && mode != ImportReplace {
+		writeJSONError(w, http.StatusBadRequest, "Modo desconocido: "+string(mode)+", usa merge o replace")
+		return
+	}
+	dryRun := q.Get("dry_run") == "true" || q.Get("dry_run") == "1"
+	format := FormatFromName(q.Get("format"))
+	if format == "" {
+		format = FormatFromName(r.Header.Get("Content-Type"))
+	}
+	if format == "" {
+		format = FormatJSONL
+	}
+
+	limits := h.limits.Load()
+	r.Body = http.MaxBytesReader(w, r.Body, int64(limits.maxImportSize))
+	routes, err := ReadRoutes(r.Body, format)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Fichero de rutas inválido: "+err.Error())
+		return
+	}
+	if problems := validateRoutes(routes, limits); len(problems) > 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Fichero de rutas inválido", "problems": problems})
+		return
+	}
+
+	changes, err := h.svc.PlanImport(ctx, routes, mode)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	if id := IdentityFromContext(ctx); id != nil {
+		var denied []string
+		for _, c := range changes {
+			if !id.CanWrite(c.Tipo) && !containsString(denied, c.Tipo) {
+				denied = append(denied, c.Tipo)
+			}
+		}
+		if len(denied) > 0 {
+			authLog.WarnContext(ctx, "Importación denegada por tipo", "subject", id.Subject, "tipos", denied)
+			writeJSONError(w, http.StatusForbidden, fmt.Sprintf("Forbidden: la identidad '%s' no puede modificar los tipos %s",
+				id.Subject, strings.Join(denied, ", ")))
+			return
+		}
+	}
+
+	result := ImportResult{Mode: mode, DryRun: dryRun, Changes: changes}
+	for _, c := range changes {
+		switch c.Action {
+		case ChangeAdded:
+			result.Added++
+		case ChangeRemoved:
+			result.Removed++
+		default:
+			result.Changed++
+		}
+	}
+	result.Unchanged = len(routes) - result.Added - result.Changed
+	if result.Changes == nil {
+		result.Changes = []RouteChange{}
+	}
+	if !dryRun {
+		if err := h.svc.ApplyImport(ctx, routes, changes); err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+	}
+	handlerLog.InfoContext(ctx, "Importación de rutas", "mode", mode, "dry_run", dryRun,
+		"added", result.Added, "removed", result.Removed, "changed", result.Changed)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// etag es el ETag de una revisión de ruta
+func etag(rev int64) string {
+	return `"` + strconv.FormatInt(rev, 10) + `"`
+}
+
+// ifNoneMatch indica si If-None-Match incluye el ETag de la revisión. La
+// cabecera es una lista de ETags o *, y se compara de forma débil (RFC 9110):
+// W/"3" coincide con "3".
+func ifNoneMatch(r *http.Request, rev int64) bool {
+	current := etag(rev)
+	for _, v := range r.Header.Values("If-None-Match") {
+		for _, tag := range strings.Split(v, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == current {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// requireIfMatch responde 428 si la solicitud no indica la revisión en If-Match
+func requireIfMatch(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := ExpectedRevision(r.Context()); ok {
+		return true
+	}
+	writeJSONError(w, http.StatusPreconditionRequired, "Falta If-Match con la revisión de la ruta (el ETag de GET)")
+	return false
+}
+
+// ifMatch lee las revisiones de If-Match, una lista de ETags ("3" o 3); sin
+// cabecera o con * no hay condición. La comparación es fuerte (RFC 9110): un
+// ETag débil (W/"3") u otro ETag que no es una revisión nunca coincide, así que
+// la escritura falla con 412.
+func ifMatch(r *http.Request) (revs []int64, ok bool, err error) {
+	for _, v := range r.Header.Values("If-Match") {
+		for _, tag := range strings.Split(v, ",") {
+			tag = strings.TrimSpace(tag)
+			switch {
+			case tag == "":
+				continue
+			case tag == "*":
+				return nil, false, nil
+			}
+			ok = true
+			if strings.HasPrefix(tag, "W/") {
+				continue
+			}
+			quoted := len(tag) >= 2 && strings.HasPrefix(tag, `"`) && strings.HasSuffix(tag, `"`)
+			rev, err := strconv.ParseInt(strings.Trim(tag, `"`), 10, 64)
+			if err != nil || rev < 0 {
+				if quoted {
+					continue
+				}
+				return nil, false, errors.New("If-Match debe ser la revisión de la ruta")
+			}
+			revs = append(revs, rev)
+		}
+	}
+	return revs, ok, nil
+}
+
+// AdminRoute atiende /admin/routes/{tipo}/{key}[/destinos|/weights|/drain|/rollback|/versions...]
+func (h *Handler) AdminRoute(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/admin/routes/"), "/")
+	versions := len(parts) >= 3 && parts[2] == "versions"
+	if len(parts) < 2 || len(parts) > 4 || (len(parts) == 4 && !versions) {
+		writeJSONError(w, http.StatusNotFound, "Usa /admin/routes/{tipo}/{key}[/destinos|/weights|/drain|/rollback|/versions]")
+		return
+	}
+	tipo, key := parts[0], parts[1]
+	limits := h.limits.Load()
+	if !validateParam(tipo, limits.maxTipoLength, validTipo) || !validateParam(key, limits.maxKeyLength, validKey) {
+		writeJSONError(w, http.StatusBadRequest, "Parámetros inválidos")
+		return
+	}
+	ctx := logging.WithAttrs(r.Context(), "tipo", tipo, "key", key)
+	if r.Method != http.MethodGet {
+		revs, ok, err := ifMatch(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if ok {
+			ctx = WithExpectedRevision(ctx, revs...)
+		}
+	}
+	r = r.WithContext(ctx)
+	if versions {
+		h.adminVersions(w, r, tipo, key, parts[3:])
+		return
+	}
+
+	action := ""
+	if len(parts) == 3 {
+		action = parts[2]
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		if !authorize(w, r, ScopeRead, tipo) {
+			return
+		}
+		route, err := h.svc.GetRoute(ctx, key, tipo)
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		w.Header().Set("ETag", etag(route.Revision))
+		if ifNoneMatch(r, route.Revision) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeJSON(w, http.StatusOK, route)
+	case action == "" && r.Method == http.MethodPut:
+		if !authorize(w, r, ScopeAdmin, tipo) || !requireIfMatch(w, r) {
+			return
+		}
+		var route Route
+		if !h.decodeBody(w, r, &route) {
+			return
+		}
+		route.Key, route.Tipo, route.Revision = key, tipo, 0
+		if problems := validateRoutes([]Route{route}, limits); len(problems) > 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Ruta inválida", "problems": problems})
+			return
+		}
+		written, err := h.svc.ReplaceRoute(ctx, route)
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		writeRoute(w, written)
+		handlerLog.InfoContext(ctx, "Ruta sustituida", "destinos", len(route.Destinos))
+	case action == "" && r.Method == http.MethodDelete:
+		if !authorize(w, r, ScopeAdmin, tipo) || !requireIfMatch(w, r) {
+			return
+		}
+		if err := h.svc.DeleteRoute(ctx, key, tipo); err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		handlerLog.InfoContext(ctx, "Ruta borrada")
+		writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+	case action == "destinos" && r.Method == http.MethodPost:
+		if !authorize(w, r, ScopeAdmin, tipo) {
+			return
+		}
+		var req struct {
+			Destino string `json:"destino"`
+		}
+		if !h.decodeBody(w, r, &req) {
+			return
+		}
+		if !validateParam(req.Destino, limits.maxDestinoLength, validURL) {
+			writeJSONError(w, http.StatusBadRequest, "Destino inválido")
+			return
+		}
+		if err := h.svc.AddDestino(ctx, key, tipo, req.Destino); err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		handlerLog.InfoContext(ctx, "Destino agregado", "destino", req.Destino)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "added"})
+	case action == "destinos" && r.Method == http.MethodDelete:
+		if !authorize(w, r, ScopeAdmin, tipo) {
+			return
+		}
+		destino := r.URL.Query().Get("destino")
+		if destino == "" {
+			writeJSONError(w, http.StatusBadRequest, "Falta el parámetro 'destino'")
+			return
+		}
+		if err := h.svc.RemoveDestino(ctx, key, tipo, destino); err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		handlerLog.InfoContext(ctx, "Destino quitado", "destino", destino)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "removed"})
+	case action == "weights" && r.Method == http.MethodPut:
+		if !authorize(w, r, ScopeAdmin, tipo) {
+			return
+		}
+		var req struct {
+			Weights map[string]int `json:"weights"`
+		}
+		if !h.decodeBody(w, r, &req) {
+			return
+		}
+		if err := h.svc.SetWeights(ctx, key, tipo, req.Weights); err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		handlerLog.InfoContext(ctx, "Pesos actualizados", "pesos", len(req.Weights))
+		writeJSON(w, http.StatusOK, map[string]string{"status": "updated"})
+	case action == "drain" && r.Method == http.MethodPost:
+		if !authorize(w, r, ScopeAdmin, tipo) {
+			return
+		}
+		req := struct {
+			Destino string `json:"destino"`
+			Drained *bool  `json:"drained"`
+		}{}
+		if !h.decodeBody(w, r, &req) {
+			return
+		}
+		drained := req.Drained == nil || *req.Drained
+		if err := h.svc.SetDrained(ctx, key, tipo, req.Destino, drained); err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		handlerLog.InfoContext(ctx, "Drenado actualizado", "destino", req.Destino, "drained", drained)
+		writeJSON(w, http.StatusOK, map[string]any{"status": "updated", "drained": drained})
+	case action == "rollback" && r.Method == http.MethodPost:
+		if !authorize(w, r, ScopeAdmin, tipo) {
+			return
+		}
+		var req struct {
+			Revision int64 `json:"revision"`
+		}
+		if !h.decodeBody(w, r, &req) {
+			return
+		}
+		if req.Revision <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "Falta la revisión a la que volver")
+			return
+		}
+		written, err := h.svc.Rollback(ctx, key, tipo, req.Revision)
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		writeRoute(w, written)
+		handlerLog.InfoContext(ctx, "Ruta restaurada", "revision", req.Revision)
+	case action == "" || action == "destinos" || action == "weights" || action == "drain" || action == "rollback":
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	default:
+		writeJSONError(w, http.StatusNotFound, "Acción desconocida: "+action)
+	}
+}
+
+// writeRoute responde con la ruta que dejó una escritura y su ETag. Es la
+// devuelta por la propia escritura, no una lectura posterior que podría ver ya
+// la de otro cliente.
+func writeRoute(w http.ResponseWriter, route *Route) {
+	w.Header().Set("ETag", etag(route.Revision))
+	writeJSON(w, http.StatusOK, route)
+}
+
+// adminVersions atiende /admin/routes/{tipo}/{key}/versions[/{revision}|/diff]
+func (h *Handler) adminVersions(w http.ResponseWriter, r *http.Request, tipo, key string, rest []string) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !authorize(w, r, ScopeRead, tipo) {
+		return
+	}
+	ctx := r.Context()
+	switch {
+	case len(rest) == 0:
+		versions, err := h.svc.ListVersions(ctx, key, tipo)
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, versions)
+	case rest[0] == "diff":
+		from, errFrom := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+		to, errTo := strconv.ParseInt(r.URL.Query().Get("to"), 10, 64)
+		if errFrom != nil || errTo != nil {
+			writeJSONError(w, http.StatusBadRequest, "Indica las revisiones con ?from=N&to=M")
+			return
+		}
+		diff, err := h.svc.DiffVersions(ctx, key, tipo, from, to)
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, diff)
+	default:
+		rev, err := strconv.ParseInt(rest[0], 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusNotFound, "Revisión inválida: "+rest[0])
+			return
+		}
+		v, err := h.svc.GetVersion(ctx, key, tipo, rev)
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+// decodeBody lee un cuerpo JSON limitado a MaxBodySize
+func (h *Handler) decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(h.limits.Load().maxBodySize))
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Payload inválido")
+		return false
+	}
+	return true
+}
+
+// AdminAudit consulta la auditoría de cambios, las entradas más recientes
+// primero. since y until son instantes RFC 3339; limit vale como mucho
+// MaxAuditQueryLimit. Solo se devuelven entradas de tipos que la identidad puede leer.
+func (h *Handler) AdminAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	query := r.URL.Query()
+	limits := h.limits.Load()
+	q := AuditQuery{Tipo: query.Get("tipo"), Key: query.Get("key"), Actor: query.Get("actor")}
+	if q.Tipo != "" && !validateParam(q.Tipo, limits.maxTipoLength, validTipo) {
+		writeJSONError(w, http.StatusBadRequest, "Parámetro 'tipo' inválido")
+		return
+	}
+	if q.Key != "" && !validateParam(q.Key, limits.maxKeyLength, validKey) {
+		writeJSONError(w, http.StatusBadRequest, "Parámetro 'key' inválido")
+		return
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &q.Since}, {"until", &q.Until}} {
+		v := query.Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Parámetro '%s' inválido, usa RFC 3339", p.name))
+			return
+		}
+		*p.dst = t
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > MaxAuditQueryLimit {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Parámetro 'limit' inválido, debe estar entre 1 y %d", MaxAuditQueryLimit))
+			return
+		}
+		q.Limit = n
+	}
+	if q.Tipo != "" && !authorize(w, r, ScopeRead, q.Tipo) {
+		return
+	}
+
+	entries, err := h.svc.QueryAudit(r.Context(), q)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	visible := make([]AuditEntry, 0, len(entries))
+	id := IdentityFromContext(r.Context())
+	for _, e := range entries {
+		if id == nil || id.CanRead(e.Tipo) {
+			visible = append(visible, e)
+		}
+	}
+	writeJSON(w, http.StatusOK, visible)
+}
+
+// AdminHealth devuelve 200 si Mongo responde y 503 si no
+func (h *Handler) AdminHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	health := h.svc.Health(r.Context())
+	status := http.StatusOK
+	if health.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, health)
+}
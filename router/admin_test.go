@@ -0,0 +1,163 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"router-app/config"
+)
+
+// newAdminTestServer sirve las rutas de administración sin autenticación
+// sobre un repositorio en memoria
+func newAdminTestServer(t *testing.T, routes ...Route) (*httptest.Server, *memRepo) {
+	t.Helper()
+	cfg := config.Default()
+	repo := newMemRepo(routes...)
+	h := NewHandler(NewService(repo, nil, cfg), cfg)
+	mux := http.NewServeMux()
+	h.RegisterAdminRoutes(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, repo
+}
+
+// adminDo envía la solicitud con las cabeceras indicadas y devuelve el código y el ETag
+func adminDo(t *testing.T, method, url, body string, headers ...string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Add(headers[i], headers[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode, resp.Header.Get("ETag")
+}
+
+func TestAdminConditionalRequests(t *testing.T) {
+	srv, repo := newAdminTestServer(t, Route{Key: "c1", Tipo: "payments", Destinos: []string{"http://a"}})
+	url := srv.URL + "/admin/routes/payments/c1"
+	put := `{"destinos": ["http://b"]}`
+
+	code, tag := adminDo(t, http.MethodGet, url, "")
+	if code != http.StatusOK || tag != `"1"` {
+		t.Fatalf("GET = %d con ETag %s, se esperaba 200 con \"1\"", code, tag)
+	}
+	if code, _ := adminDo(t, http.MethodGet, url, "", "If-None-Match", `"0", W/"1"`); code != http.StatusNotModified {
+		t.Errorf("GET con If-None-Match vigente = %d, se esperaba 304", code)
+	}
+
+	tests := []struct {
+		name    string
+		ifMatch []string
+		status  int
+	}{
+		{"sin If-Match", nil, http.StatusPreconditionRequired},
+		{"revisión antigua", []string{`"0"`}, http.StatusPreconditionFailed},
+		{"ETag débil", []string{`W/"1"`}, http.StatusPreconditionFailed},
+		{"ETag que no es una revisión", []string{`"abc"`}, http.StatusPreconditionFailed},
+		{"valor mal formado", []string{"abc"}, http.StatusBadRequest},
+		{"lista sin la revisión actual", []string{`"0", W/"1"`}, http.StatusPreconditionFailed},
+		{"lista con la revisión actual", []string{`"0", "1"`}, http.StatusOK},
+		{"varias cabeceras", []string{`"1"`, `"2"`}, http.StatusOK},
+		{"revisión sin comillas", []string{"3"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, _ := repo.GetRoute(context.Background(), "c1", "payments")
+			var headers []string
+			for _, v := range tt.ifMatch {
+				headers = append(headers, "If-Match", v)
+			}
+			code, tag := adminDo(t, http.MethodPut, url, put, headers...)
+			if code != tt.status {
+				t.Fatalf("PUT = %d, se esperaba %d", code, tt.status)
+			}
+			after, _ := repo.GetRoute(context.Background(), "c1", "payments")
+			if tt.status != http.StatusOK {
+				if after.Revision != before.Revision {
+					t.Errorf("un PUT rechazado cambió la revisión de %d a %d", before.Revision, after.Revision)
+				}
+				return
+			}
+			if want := etag(before.Revision + 1); tag != want {
+				t.Errorf("ETag = %s, se esperaba %s", tag, want)
+			}
+		})
+	}
+
+	// El borrado también exige If-Match y el ETag anterior deja de valer
+	if code, _ := adminDo(t, http.MethodDelete, url, ""); code != http.StatusPreconditionRequired {
+		t.Errorf("DELETE sin If-Match = %d, se esperaba 428", code)
+	}
+	if code, _ := adminDo(t, http.MethodDelete, url, "", "If-Match", `"1"`); code != http.StatusPreconditionFailed {
+		t.Errorf("DELETE con ETag antiguo = %d, se esperaba 412", code)
+	}
+	if code, _ := adminDo(t, http.MethodDelete, url, "", "If-Match", `"4"`); code != http.StatusOK {
+		t.Errorf("DELETE con el ETag actual = %d, se esperaba 200", code)
+	}
+	if code, _ := adminDo(t, http.MethodPut, url, put, "If-Match", `"4"`); code != http.StatusNotFound {
+		t.Errorf("PUT sobre una ruta borrada = %d, se esperaba 404", code)
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		header  []string
+		revs    []int64
+		ok      bool
+		wantErr bool
+	}{
+		{header: nil},
+		{header: []string{"*"}},
+		{header: []string{`"1", *`}},
+		{header: []string{" "}},
+		{header: []string{`"3"`}, revs: []int64{3}, ok: true},
+		{header: []string{`"1" , "2",`}, revs: []int64{1, 2}, ok: true},
+		{header: []string{`W/"3"`}, ok: true},
+		{header: []string{`"-1"`}, ok: true},
+		{header: []string{"tres"}, wantErr: true},
+		{header: []string{"-1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPut, "/", nil)
+		for _, v := range tt.header {
+			r.Header.Add("If-Match", v)
+		}
+		revs, ok, err := ifMatch(r)
+		if (err != nil) != tt.wantErr || ok != tt.ok || len(revs) != len(tt.revs) {
+			t.Errorf("ifMatch(%q) = %v, %v, %v", tt.header, revs, ok, err)
+			continue
+		}
+		for i := range revs {
+			if revs[i] != tt.revs[i] {
+				t.Errorf("ifMatch(%q) = %v, se esperaba %v", tt.header, revs, tt.revs)
+			}
+		}
+	}
+}
+
+func TestAdminGetReturnsRoute(t *testing.T) {
+	srv, _ := newAdminTestServer(t, Route{Key: "c1", Tipo: "payments", Destinos: []string{"http://a"}})
+	resp, err := http.Get(srv.URL + "/admin/routes/payments/c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var route Route
+	if err := json.NewDecoder(resp.Body).Decode(&route); err != nil {
+		t.Fatal(err)
+	}
+	if route.Revision != 1 || len(route.Destinos) != 1 || resp.Header.Get("ETag") != etag(route.Revision) {
+		t.Errorf("GET = %+v con ETag %s", route, resp.Header.Get("ETag"))
+	}
+}
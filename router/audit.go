@@ -0,0 +1,177 @@
+package router
+
+import (
+	"context"
+	"time"
+
+	"router-app/logging"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var auditLog = logging.For("audit")
+
+// Operaciones registradas en la auditoría
+const (
+	AuditAddDestino    = "add_destino"
+	AuditRemoveDestino = "remove_destino"
+	AuditDeleteRoute   = "delete_route"
+	AuditReplaceRoute  = "replace_route"
+	AuditSetWeights    = "set_weights"
+	AuditSetDrained    = "set_drained"
+	AuditImport        = "import"
+	AuditRollback      = "rollback"
+)
+
+// MaxAuditQueryLimit acota las entradas que devuelve una consulta
+const MaxAuditQueryLimit = 1000
+
+// AuditEntry es un cambio sobre una ruta. Before es nil si la ruta no existía y
+// After si se borró.
+type AuditEntry struct {
+	Timestamp  time.Time `bson:"timestamp" json:"timestamp"`
+	Actor      string    `bson:"actor,omitempty" json:"actor,omitempty"`
+	AuthScheme string    `bson:"auth_scheme,omitempty" json:"auth_scheme,omitempty"`
+	ClientIP   string    `bson:"client_ip,omitempty" json:"client_ip,omitempty"`
+	RequestID  string    `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	Operation  string    `bson:"operation" json:"operation"`
+	Tipo       string    `bson:"tipo" json:"tipo"`
+	Key        string    `bson:"key" json:"key"`
+	Before     *Route    `bson:"before,omitempty" json:"before,omitempty"`
+	After      *Route    `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// AuditQuery filtra la auditoría; los campos vacíos no filtran
+type AuditQuery struct {
+	Tipo  string
+	Key   string
+	Actor string
+	Since time.Time
+	Until time.Time
+	Limit int
+}
+
+// AuditLog es un registro de solo añadir: no hay forma de modificar ni borrar
+// entradas, solo caducan por retención
+type AuditLog interface {
+	Record(ctx context.Context, entry AuditEntry) error
+	// Query devuelve las entradas más recientes primero
+	Query(ctx context.Context, q AuditQuery) ([]AuditEntry, error)
+}
+
+// newAuditEntry rellena quién, desde dónde y en qué solicitud a partir del contexto
+func newAuditEntry(ctx context.Context, operation, key, tipo string, before, after *Route) AuditEntry {
+	entry := AuditEntry{
+		Timestamp: time.Now().UTC(),
+		ClientIP:  ClientIPFromContext(ctx),
+		RequestID: RequestIDFromContext(ctx),
+		Operation: operation,
+		Tipo:      tipo,
+		Key:       key,
+		Before:    before,
+		After:     after,
+	}
+	if id := IdentityFromContext(ctx); id != nil {
+		entry.Actor = id.Subject
+		entry.AuthScheme = id.Scheme
+	}
+	return entry
+}
+
+type mongoAuditLog struct {
+	col *mongo.Collection
+}
+
+// NewMongoAuditLog guarda la auditoría en la colección "route_audit". Con
+// retention > 0 un índice TTL borra las entradas más antiguas; el índice se
+// ajusta si la retención cambia entre arranques.
+func NewMongoAuditLog(db *mongo.Database, retention time.Duration) AuditLog {
+	col := db.Collection("route_audit")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tipo", Value: 1}, {Key: "key", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "timestamp", Value: -1}}},
+	})
+	if err != nil {
+		auditLog.Warn("No se pudieron crear los índices de route_audit", "error", err)
+	}
+	if retention > 0 {
+		ensureAuditTTL(ctx, col, int32(retention/time.Second))
+	}
+	return &mongoAuditLog{col: col}
+}
+
+// ensureAuditTTL crea el índice TTL sobre timestamp o, si ya existe con otra
+// retención, la cambia con collMod
+func ensureAuditTTL(ctx context.Context, col *mongo.Collection, seconds int32) {
+	keys := bson.D{{Key: "timestamp", Value: 1}}
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    keys,
+		Options: options.Index().SetExpireAfterSeconds(seconds),
+	})
+	if err == nil {
+		return
+	}
+	err = col.Database().RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: col.Name()},
+		{Key: "index", Value: bson.D{{Key: "keyPattern", Value: keys}, {Key: "expireAfterSeconds", Value: seconds}}},
+	}).Err()
+	if err != nil {
+		auditLog.Warn("No se pudo configurar la retención de route_audit", "error", err)
+	}
+}
+
+func (a *mongoAuditLog) Record(ctx context.Context, entry AuditEntry) error {
+	ctx, span := startMongoSpan(ctx, "insert_one", a.col.Name())
+	start := time.Now()
+	_, err := a.col.InsertOne(ctx, entry)
+	observeMongo("audit_record", start, err)
+	endSpan(span, err)
+	return err
+}
+
+func (a *mongoAuditLog) Query(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	filter := bson.M{}
+	if q.Tipo != "" {
+		filter["tipo"] = q.Tipo
+	}
+	if q.Key != "" {
+		filter["key"] = q.Key
+	}
+	if q.Actor != "" {
+		filter["actor"] = q.Actor
+	}
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		ts := bson.M{}
+		if !q.Since.IsZero() {
+			ts["$gte"] = q.Since
+		}
+		if !q.Until.IsZero() {
+			ts["$lt"] = q.Until
+		}
+		filter["timestamp"] = ts
+	}
+	limit := q.Limit
+	if limit <= 0 || limit > MaxAuditQueryLimit {
+		limit = MaxAuditQueryLimit
+	}
+	ctx, span := startMongoSpan(ctx, "find", a.col.Name())
+	start := time.Now()
+	cursor, err := a.col.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: -1}}).
+		SetLimit(int64(limit)).
+		SetProjection(bson.M{"_id": 0}))
+	defer func() {
+		observeMongo("audit_query", start, err)
+		endSpan(span, err)
+	}()
+	if err != nil {
+		return nil, err
+	}
+	entries := []AuditEntry{}
+	err = cursor.All(ctx, &entries)
+	return entries, err
+}
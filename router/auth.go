@@ -1,20 +1,184 @@
 package router
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
-	"router-app/config"
+	"path"
+
+	"router-app/logging"
+)
+
+var authLog = logging.For("auth")
+
+// Scopes reconocidos por la capa de autorización. ScopeAdmin implica ScopeRead.
+const (
+	ScopeRead  = "read"
+	ScopeAdmin = "admin"
 )
 
-func AuthMiddleware(next http.Handler) http.Handler {
-	requiredKey := config.APIKey // ...en vez de leer API_KEY directamente...
+// ErrNoCredentials indica que la solicitud no trae credenciales para el esquema consultado
+var ErrNoCredentials = errors.New("sin credenciales")
+
+// Identity describe al llamador autenticado, independientemente del esquema usado
+type Identity struct {
+	Subject string
+	Scheme  string
+	Scopes  []string
+	// Patrones de tipo (sintaxis path.Match) permitidos; vacío significa sin restricción
+	ReadTipos  []string
+	WriteTipos []string
+	// NoTipos niega todos los tipos aunque las listas estén vacías, p. ej. a un
+	// JWT sin claim de tipos
+	NoTipos bool
+	// Límites propios por nombre de política de rate limiting
+	RateLimits map[string]RateLimit
+}
+
+// HasScope indica si la identidad tiene el scope pedido
+func (id *Identity) HasScope(scope string) bool {
+	for _, s := range id.Scopes {
+		if s == scope || (s == ScopeAdmin && scope == ScopeRead) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanRead indica si la identidad puede consultar rutas del tipo indicado
+func (id *Identity) CanRead(tipo string) bool {
+	return !id.NoTipos && matchesTipo(id.ReadTipos, tipo)
+}
+
+// CanWrite indica si la identidad puede modificar rutas del tipo indicado
+func (id *Identity) CanWrite(tipo string) bool {
+	return !id.NoTipos && matchesTipo(id.WriteTipos, tipo)
+}
+
+func matchesTipo(patterns []string, tipo string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, err := path.Match(p, tipo); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator resuelve la identidad de una solicitud. Devuelve ErrNoCredentials
+// cuando la solicitud no trae credenciales de su esquema.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+type identityKey struct{}
+
+// IdentityFromContext devuelve la identidad guardada por AuthMiddleware, si existe
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// WithIdentity devuelve un contexto que transporta la identidad indicada
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+type apiKeyAuthenticator struct {
+	store KeyStore
+}
+
+// NewAPIKeyAuthenticator autentica solicitudes con la cabecera X-API-Key contra el almacén de claves
+func NewAPIKeyAuthenticator(store KeyStore) Authenticator {
+	return &apiKeyAuthenticator{store: store}
+}
+
+func (a *apiKeyAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		return nil, ErrNoCredentials
+	}
+	rec, err := a.store.Lookup(apiKey)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, errors.New("API key inválida")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rec.Identity(), nil
+}
+
+type chainAuthenticator struct {
+	authenticators []Authenticator
+}
+
+// NewChainAuthenticator prueba cada esquema en orden y usa el primero que encuentre credenciales
+func NewChainAuthenticator(authenticators ...Authenticator) Authenticator {
+	return &chainAuthenticator{authenticators: authenticators}
+}
+
+func (c *chainAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	for _, a := range c.authenticators {
+		id, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return id, err
+	}
+	return nil, ErrNoCredentials
+}
+
+func AuthMiddleware(auth Authenticator, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		apiKey := r.Header.Get("X-API-Key")
-		if apiKey == "" || apiKey != requiredKey {
-			log.Printf("Intento fallido de autenticación desde IP %s, User-Agent: %s", r.RemoteAddr, r.UserAgent())
+		id, err := auth.Authenticate(r)
+		if err != nil {
+			authLog.WarnContext(r.Context(), "Intento fallido de autenticación", "ip", ClientIP(r), "user_agent", r.UserAgent(), "error", err)
+			countRejected("auth", http.StatusUnauthorized)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		next.ServeHTTP(w, r)
+		recordIdentity(r.Context(), id)
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
 	})
 }
+
+// authorize comprueba scope y tipo de la identidad de la solicitud; ScopeAdmin
+// implica escritura. Sin identidad en el contexto (AuthMiddleware no instalado)
+// la solicitud se permite.
+func authorize(w http.ResponseWriter, r *http.Request, scope, tipo string) bool {
+	id := IdentityFromContext(r.Context())
+	if id == nil {
+		return true
+	}
+	if !id.HasScope(scope) {
+		authLog.WarnContext(r.Context(), "Acceso denegado: falta scope", "subject", id.Subject, "scope", scope)
+		http.Error(w, fmt.Sprintf("Forbidden: la identidad '%s' no tiene el scope '%s'", id.Subject, scope), http.StatusForbidden)
+		return false
+	}
+	write := scope == ScopeAdmin
+	if (write && !id.CanWrite(tipo)) || (!write && !id.CanRead(tipo)) {
+		action := "leer"
+		if write {
+			action = "modificar"
+		}
+		authLog.WarnContext(r.Context(), "Acceso denegado al tipo", "subject", id.Subject, "accion", action)
+		http.Error(w, fmt.Sprintf("Forbidden: la identidad '%s' no puede %s el tipo '%s'", id.Subject, action, tipo), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// authorizeAdmin comprueba el scope admin en operaciones que no afectan a un tipo
+// concreto, como recargar la configuración
+func authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	id := IdentityFromContext(r.Context())
+	if id == nil || id.HasScope(ScopeAdmin) {
+		return true
+	}
+	authLog.WarnContext(r.Context(), "Acceso denegado: falta scope", "subject", id.Subject, "scope", ScopeAdmin)
+	http.Error(w, fmt.Sprintf("Forbidden: la identidad '%s' no tiene el scope '%s'", id.Subject, ScopeAdmin), http.StatusForbidden)
+	return false
+}
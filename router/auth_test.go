@@ -0,0 +1,219 @@
+package router
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"router-app/config"
+)
+
+// newAuthTestServer sirve las rutas públicas y de administración tras
+// AuthMiddleware, con la API key de configuración y las de keys
+func newAuthTestServer(t *testing.T, keys map[string]*APIKeyRecord) *httptest.Server {
+	t.Helper()
+	cfg := config.Default()
+	repo := newMemRepo(
+		Route{Key: "cliente-1", Tipo: "payments", Destinos: []string{"http://payments-a"}},
+		Route{Key: "cliente-1", Tipo: "search", Destinos: []string{"http://search-a"}},
+	)
+	h := NewHandler(NewService(repo, nil, cfg), cfg)
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	h.RegisterAdminRoutes(mux)
+	store := NewChainKeyStore(NewConfigKeyStore("root-key"), NewStaticKeyStore(keys))
+	srv := httptest.NewServer(AuthMiddleware(NewAPIKeyAuthenticator(store), mux))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestAuthorizeByTipo(t *testing.T) {
+	srv := newAuthTestServer(t, map[string]*APIKeyRecord{
+		"payments-key": {Name: "payments-team", Scopes: []string{ScopeAdmin},
+			ReadTipos: []string{"payments"}, WriteTipos: []string{"payments"}},
+		"reader-key": {Name: "auditor", Scopes: []string{ScopeRead}},
+		"wild-key": {Name: "ops", Scopes: []string{ScopeAdmin},
+			ReadTipos: []string{"pay*"}, WriteTipos: []string{"pay*", "search-?"}},
+		"read-all-key":    {Name: "mixed", Scopes: []string{ScopeAdmin}, WriteTipos: []string{"payments"}},
+		"bad-pattern-key": {Name: "broken", Scopes: []string{ScopeAdmin}, WriteTipos: []string{"[payments"}},
+	})
+	addDestino := `{"destino": "http://nuevo"}`
+	tests := []struct {
+		name   string
+		key    string
+		method string
+		path   string
+		status int
+		body   string // fragmento esperado en la respuesta
+	}{
+		{"escribe en su tipo", "payments-key", http.MethodPost, "/add-destino/payments/cliente-2", http.StatusOK, "added"},
+		{"no escribe en otro tipo", "payments-key", http.MethodPost, "/add-destino/search/cliente-2", http.StatusForbidden,
+			"Forbidden: la identidad 'payments-team' no puede modificar el tipo 'search'"},
+		{"no lee otro tipo", "payments-key", http.MethodGet, "/route/search/cliente-1", http.StatusForbidden,
+			"Forbidden: la identidad 'payments-team' no puede leer el tipo 'search'"},
+		{"lee su tipo", "payments-key", http.MethodGet, "/route/payments/cliente-1", http.StatusOK, "http://payments-a"},
+
+		{"solo lectura lee cualquier tipo", "reader-key", http.MethodGet, "/route/search/cliente-1", http.StatusOK, "http://search-a"},
+		{"solo lectura no escribe", "reader-key", http.MethodPost, "/add-destino/payments/cliente-1", http.StatusForbidden,
+			"Forbidden: la identidad 'auditor' no tiene el scope 'admin'"},
+
+		{"comodín * en escritura", "wild-key", http.MethodPost, "/add-destino/payments-eu/cliente-1", http.StatusOK, "added"},
+		{"comodín ? en escritura", "wild-key", http.MethodPost, "/add-destino/search-1/cliente-1", http.StatusOK, "added"},
+		{"? es un solo carácter", "wild-key", http.MethodPost, "/add-destino/search-10/cliente-1", http.StatusForbidden, "no puede modificar"},
+		{"escribir no implica leer", "wild-key", http.MethodGet, "/route/search-1/cliente-1", http.StatusForbidden, "no puede leer"},
+
+		{"sin patrones de lectura lee todo", "read-all-key", http.MethodGet, "/route/search/cliente-1", http.StatusOK, "http://search-a"},
+		{"leer no implica escribir", "read-all-key", http.MethodPost, "/add-destino/search/cliente-1", http.StatusForbidden, "no puede modificar"},
+
+		{"un patrón mal formado no coincide", "bad-pattern-key", http.MethodPost, "/add-destino/payments/cliente-1", http.StatusForbidden, "no puede modificar"},
+		{"la key de configuración accede a todo", "root-key", http.MethodPost, "/add-destino/search/cliente-1", http.StatusOK, "added"},
+		{"key desconocida", "otra-key", http.MethodGet, "/route/payments/cliente-1", http.StatusUnauthorized, "Unauthorized"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader
+			if tt.method == http.MethodPost {
+				body = strings.NewReader(addDestino)
+			}
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-API-Key", tt.key)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			data, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.status || !strings.Contains(string(data), tt.body) {
+				t.Errorf("%s %s = %d %q, se esperaba %d con %q", tt.method, tt.path, resp.StatusCode, data, tt.status, tt.body)
+			}
+		})
+	}
+}
+
+func TestAdminListShowsOnlyReadableTipos(t *testing.T) {
+	srv := newAuthTestServer(t, map[string]*APIKeyRecord{
+		"payments-key": {Name: "payments-team", Scopes: []string{ScopeRead}, ReadTipos: []string{"pay*"}},
+	})
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/admin/routes", nil)
+	req.Header.Set("X-API-Key", "payments-key")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var routes []Route
+	if err := json.NewDecoder(resp.Body).Decode(&routes); err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 1 || routes[0].Tipo != "payments" {
+		t.Errorf("GET /admin/routes = %+v, se esperaba solo la ruta de payments", routes)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/admin/routes?tipo=search", nil)
+	req.Header.Set("X-API-Key", "payments-key")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET /admin/routes?tipo=search = %d, se esperaba 403", resp.StatusCode)
+	}
+}
+
+func TestIdentityTipos(t *testing.T) {
+	tests := []struct {
+		name  string
+		id    Identity
+		read  map[string]bool
+		write map[string]bool
+	}{
+		{
+			name:  "sin patrones no hay restricción",
+			id:    Identity{},
+			read:  map[string]bool{"payments": true, "search": true},
+			write: map[string]bool{"payments": true, "search": true},
+		},
+		{
+			name:  "NoTipos niega aunque no haya patrones",
+			id:    Identity{NoTipos: true},
+			read:  map[string]bool{"payments": false},
+			write: map[string]bool{"payments": false},
+		},
+		{
+			name:  "NoTipos gana a los patrones",
+			id:    Identity{NoTipos: true, ReadTipos: []string{"*"}, WriteTipos: []string{"*"}},
+			read:  map[string]bool{"payments": false},
+			write: map[string]bool{"payments": false},
+		},
+		{
+			name:  "lectura y escritura por separado",
+			id:    Identity{ReadTipos: []string{"*"}, WriteTipos: []string{"payments"}},
+			read:  map[string]bool{"payments": true, "search": true},
+			write: map[string]bool{"payments": true, "search": false},
+		},
+		{
+			name:  "clases de caracteres de path.Match",
+			id:    Identity{ReadTipos: []string{"search-[a-c]"}, WriteTipos: []string{"search-[^a]"}},
+			read:  map[string]bool{"search-b": true, "search-d": false},
+			write: map[string]bool{"search-a": false, "search-b": true},
+		},
+		{
+			name: "un patrón mal formado no coincide pero no anula los demás",
+			id:   Identity{ReadTipos: []string{"[", "payments"}},
+			read: map[string]bool{"payments": true, "[": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for tipo, want := range tt.read {
+				if got := tt.id.CanRead(tipo); got != want {
+					t.Errorf("CanRead(%q) = %v, se esperaba %v", tipo, got, want)
+				}
+			}
+			for tipo, want := range tt.write {
+				if got := tt.id.CanWrite(tipo); got != want {
+					t.Errorf("CanWrite(%q) = %v, se esperaba %v", tipo, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestIdentityHasScope(t *testing.T) {
+	admin := Identity{Scopes: []string{ScopeAdmin}}
+	read := Identity{Scopes: []string{ScopeRead}}
+	if !admin.HasScope(ScopeRead) || !admin.HasScope(ScopeAdmin) {
+		t.Error("admin debería implicar read")
+	}
+	if !read.HasScope(ScopeRead) || read.HasScope(ScopeAdmin) {
+		t.Error("read no debería implicar admin")
+	}
+	if (&Identity{}).HasScope(ScopeRead) {
+		t.Error("una identidad sin scopes no debería tener read")
+	}
+}
+
+func TestConfigKeyStoreRotation(t *testing.T) {
+	keys := NewConfigKeyStore("old-key")
+	chain := NewChainKeyStore(keys, NewStaticKeyStore(map[string]*APIKeyRecord{"team-key": {Name: "team"}}))
+	if rec, err := chain.Lookup("old-key"); err != nil || rec.Name != "config" {
+		t.Fatalf("Lookup(old-key) = %+v, %v", rec, err)
+	}
+	keys.SetKey("new-key")
+	if _, err := chain.Lookup("old-key"); err != ErrKeyNotFound {
+		t.Errorf("la key rotada sigue aceptándose: %v", err)
+	}
+	if rec, err := chain.Lookup("new-key"); err != nil || rec.Name != "config" {
+		t.Errorf("Lookup(new-key) = %+v, %v", rec, err)
+	}
+	if rec, err := chain.Lookup("team-key"); err != nil || rec.Name != "team" {
+		t.Errorf("la cadena no consulta el segundo almacén: %+v, %v", rec, err)
+	}
+}
@@ -0,0 +1,292 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formatos de exportación e importación de la tabla de rutas
+const (
+	FormatJSONL = "jsonl"
+	FormatYAML  = "yaml"
+)
+
+// ImportMode indica qué pasa con las rutas que no aparecen en la importación:
+// merge las deja como están y replace las borra
+type ImportMode string
+
+const (
+	ImportMerge   ImportMode = "merge"
+	ImportReplace ImportMode = "replace"
+)
+
+// Acciones de RouteChange
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// RouteChange describe cómo cambia una ruta al aplicar una importación
+type RouteChange struct {
+	Action          string   `json:"action"`
+	Tipo            string   `json:"tipo"`
+	Key             string   `json:"key"`
+	AddedDestinos   []string `json:"added_destinos,omitempty"`
+	RemovedDestinos []string `json:"removed_destinos,omitempty"`
+	WeightsChanged  bool     `json:"weights_changed,omitempty"`
+	DrainedChanged  bool     `json:"drained_changed,omitempty"`
+	// OwnerChanged indica que cambia ManagedBy, p. ej. al adoptar una ruta creada a mano
+	OwnerChanged bool `json:"owner_changed,omitempty"`
+}
+
+// ImportResult es la respuesta de POST /admin/routes/import
+type ImportResult struct {
+	Mode      ImportMode    `json:"mode"`
+	DryRun    bool          `json:"dry_run"`
+	Added     int           `json:"added"`
+	Removed   int           `json:"removed"`
+	Changed   int           `json:"changed"`
+	Unchanged int           `json:"unchanged"`
+	Changes   []RouteChange `json:"changes"`
+}
+
+// FormatFromName deduce el formato de un nombre de fichero o Content-Type;
+// devuelve "" si no lo reconoce
+func FormatFromName(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".jsonl"), strings.HasSuffix(name, ".ndjson"), strings.Contains(name, "ndjson"), name == FormatJSONL:
+		return FormatJSONL
+	case strings.HasSuffix(name, ".yaml"), strings.HasSuffix(name, ".yml"), strings.Contains(name, "yaml"), name == FormatYAML:
+		return FormatYAML
+	}
+	return ""
+}
+
+// ContentType devuelve el Content-Type de un formato
+func ContentType(format string) string {
+	if format == FormatYAML {
+		return "application/yaml"
+	}
+	return "application/x-ndjson"
+}
+
+// WriteRoutes escribe las rutas en JSON Lines (una por línea) o como lista YAML
+func WriteRoutes(w io.Writer, routes []Route, format string) error {
+	switch format {
+	case FormatJSONL:
+		enc := json.NewEncoder(w)
+		for _, route := range routes {
+			if err := enc.Encode(route); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatYAML:
+		if routes == nil {
+			routes = []Route{}
+		}
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(routes); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		return fmt.Errorf("formato desconocido '%s', usa %s o %s", format, FormatJSONL, FormatYAML)
+	}
+}
+
+// ReadRoutes lee rutas en el formato indicado. Los campos desconocidos son un
+// error para que una errata no se convierta en una ruta vacía.
+func ReadRoutes(r io.Reader, format string) ([]Route, error) {
+	switch format {
+	case FormatJSONL:
+		var routes []Route
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for line := 1; sc.Scan(); line++ {
+			data := bytes.TrimSpace(sc.Bytes())
+			if len(data) == 0 {
+				continue
+			}
+			dec := json.NewDecoder(bytes.NewReader(data))
+			dec.DisallowUnknownFields()
+			var route Route
+			if err := dec.Decode(&route); err != nil {
+				return nil, fmt.Errorf("línea %d: %w", line, err)
+			}
+			routes = append(routes, route)
+		}
+		return routes, sc.Err()
+	case FormatYAML:
+		var routes []Route
+		dec := yaml.NewDecoder(r)
+		dec.KnownFields(true)
+		if err := dec.Decode(&routes); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		return routes, nil
+	default:
+		return nil, fmt.Errorf("formato desconocido '%s', usa %s o %s", format, FormatJSONL, FormatYAML)
+	}
+}
+
+// validateRoutes aplica a cada ruta las mismas reglas que AddDestino y comprueba
+// que pesos y drenados se refieran a destinos de la ruta. Devuelve un problema
+// por línea, vacío si todo es correcto.
+func validateRoutes(routes []Route, limits *handlerLimits) []string {
+	var problems []string
+	seen := make(map[string]bool, len(routes))
+	for i, route := range routes {
+		name := fmt.Sprintf("ruta %d (%s/%s)", i+1, route.Tipo, route.Key)
+		if !validateParam(route.Tipo, limits.maxTipoLength, validTipo) {
+			problems = append(problems, name+": tipo inválido")
+		}
+		if !validateParam(route.Key, limits.maxKeyLength, validKey) {
+			problems = append(problems, name+": key inválida")
+		}
+		if seen[routeMapKey(route.Key, route.Tipo)] {
+			problems = append(problems, name+": ruta repetida")
+		}
+		seen[routeMapKey(route.Key, route.Tipo)] = true
+		if len(route.Destinos) == 0 {
+			problems = append(problems, name+": no tiene destinos")
+		}
+		destinos := make(map[string]bool, len(route.Destinos))
+		for _, d := range route.Destinos {
+			if !validateParam(d, limits.maxDestinoLength, validURL) {
+				problems = append(problems, fmt.Sprintf("%s: destino inválido '%s'", name, d))
+			}
+			if destinos[d] {
+				problems = append(problems, fmt.Sprintf("%s: destino repetido '%s'", name, d))
+			}
+			destinos[d] = true
+		}
+		for _, w := range route.Weights {
+			if !destinos[w.Destino] {
+				problems = append(problems, fmt.Sprintf("%s: el peso se refiere a '%s', que no es un destino de la ruta", name, w.Destino))
+			}
+			if w.Weight < 0 || w.Weight > MaxDestinoWeight {
+				problems = append(problems, fmt.Sprintf("%s: el peso de '%s' debe estar entre 0 y %d", name, w.Destino, MaxDestinoWeight))
+			}
+		}
+		for _, d := range route.Drained {
+			if !destinos[d] {
+				problems = append(problems, fmt.Sprintf("%s: '%s' está drenado pero no es un destino de la ruta", name, d))
+			}
+		}
+	}
+	return problems
+}
+
+// normalizeRoute deja la ruta como la guardaría la API: sin pesos 1 explícitos
+// y sin drenados repetidos
+func normalizeRoute(route Route) Route {
+	weights := make([]DestinoWeight, 0, len(route.Weights))
+	for _, w := range route.Weights {
+		if w.Weight != 1 {
+			weights = append(weights, w)
+		}
+	}
+	route.Weights = nil
+	if len(weights) > 0 {
+		route.Weights = weights
+	}
+	var drained []string
+	for _, d := range route.Drained {
+		if !containsString(drained, d) {
+			drained = append(drained, d)
+		}
+	}
+	route.Drained = drained
+	return route
+}
+
+// DiffRoutes compara la tabla actual con la deseada. Con removeMissing las rutas
+// que no están en desired se marcan como borradas. El orden de los destinos no
+// cuenta como cambio. El resultado está ordenado por tipo y key.
+func DiffRoutes(current, desired []Route, removeMissing bool) []RouteChange {
+	byKey := make(map[string]*Route, len(current))
+	for i := range current {
+		byKey[routeMapKey(current[i].Key, current[i].Tipo)] = &current[i]
+	}
+	var changes []RouteChange
+	wanted := make(map[string]bool, len(desired))
+	for i := range desired {
+		next := &desired[i]
+		mapKey := routeMapKey(next.Key, next.Tipo)
+		wanted[mapKey] = true
+		prev, ok := byKey[mapKey]
+		if !ok {
+			changes = append(changes, RouteChange{
+				Action:        ChangeAdded,
+				Tipo:          next.Tipo,
+				Key:           next.Key,
+				AddedDestinos: append([]string(nil), next.Destinos...),
+			})
+			continue
+		}
+		change := RouteChange{
+			Action:          ChangeChanged,
+			Tipo:            next.Tipo,
+			Key:             next.Key,
+			AddedDestinos:   missingFrom(next.Destinos, prev.Destinos),
+			RemovedDestinos: missingFrom(prev.Destinos, next.Destinos),
+			WeightsChanged:  !sameWeights(prev, next),
+			DrainedChanged:  len(missingFrom(prev.Drained, next.Drained)) > 0 || len(missingFrom(next.Drained, prev.Drained)) > 0,
+			OwnerChanged:    prev.ManagedBy != next.ManagedBy,
+		}
+		if len(change.AddedDestinos) > 0 || len(change.RemovedDestinos) > 0 || change.WeightsChanged || change.DrainedChanged || change.OwnerChanged {
+			changes = append(changes, change)
+		}
+	}
+	if removeMissing {
+		for _, prev := range current {
+			if !wanted[routeMapKey(prev.Key, prev.Tipo)] {
+				changes = append(changes, RouteChange{
+					Action:          ChangeRemoved,
+					Tipo:            prev.Tipo,
+					Key:             prev.Key,
+					RemovedDestinos: append([]string(nil), prev.Destinos...),
+				})
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Tipo != changes[j].Tipo {
+			return changes[i].Tipo < changes[j].Tipo
+		}
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+// missingFrom devuelve los elementos de a que no están en b
+func missingFrom(a, b []string) []string {
+	var out []string
+	for _, s := range a {
+		if !containsString(b, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func sameWeights(a, b *Route) bool {
+	for _, d := range a.Destinos {
+		if containsString(b.Destinos, d) && a.Weight(d) != b.Weight(d) {
+			return false
+		}
+	}
+	return true
+}
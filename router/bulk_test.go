@@ -0,0 +1,224 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"router-app/config"
+)
+
+func TestValidateRoutes(t *testing.T) {
+	limits := limitsFromConfig(config.Default())
+	tests := []struct {
+		name   string
+		routes []Route
+		want   []string
+	}{
+		{
+			name: "rutas válidas",
+			routes: []Route{
+				{Key: "c1", Tipo: "payments", Destinos: []string{"http://a", "http://b"},
+					Weights: []DestinoWeight{{Destino: "http://a", Weight: 3}}, Drained: []string{"http://b"}},
+				{Key: "c2", Tipo: "payments", Destinos: []string{"http://a"}},
+			},
+		},
+		{
+			name:   "tipo, key y destino inválidos",
+			routes: []Route{{Key: "c 1", Tipo: "pay/ments", Destinos: []string{"ftp://a"}}},
+			want: []string{
+				"ruta 1 (pay/ments/c 1): tipo inválido",
+				"ruta 1 (pay/ments/c 1): key inválida",
+				"ruta 1 (pay/ments/c 1): destino inválido 'ftp://a'",
+			},
+		},
+		{
+			name: "ruta repetida y sin destinos",
+			routes: []Route{
+				{Key: "c1", Tipo: "payments", Destinos: []string{"http://a"}},
+				{Key: "c1", Tipo: "payments"},
+			},
+			want: []string{
+				"ruta 2 (payments/c1): ruta repetida",
+				"ruta 2 (payments/c1): no tiene destinos",
+			},
+		},
+		{
+			name: "destino repetido, pesos y drenados ajenos",
+			routes: []Route{{Key: "c1", Tipo: "payments", Destinos: []string{"http://a", "http://a"},
+				Weights: []DestinoWeight{{Destino: "http://x", Weight: 2}, {Destino: "http://a", Weight: MaxDestinoWeight + 1}},
+				Drained: []string{"http://y"}}},
+			want: []string{
+				"ruta 1 (payments/c1): destino repetido 'http://a'",
+				"ruta 1 (payments/c1): el peso se refiere a 'http://x', que no es un destino de la ruta",
+				"ruta 1 (payments/c1): el peso de 'http://a' debe estar entre 0 y " + strconv.Itoa(MaxDestinoWeight),
+				"ruta 1 (payments/c1): 'http://y' está drenado pero no es un destino de la ruta",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateRoutes(tt.routes, limits); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateRoutes() =\n%q\nse esperaba\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffRoutes(t *testing.T) {
+	current := []Route{
+		{Key: "c1", Tipo: "payments", Destinos: []string{"http://a", "http://b"}},
+		{Key: "c2", Tipo: "payments", Destinos: []string{"http://a"}, Weights: []DestinoWeight{{Destino: "http://a", Weight: 2}}},
+		{Key: "c3", Tipo: "search", Destinos: []string{"http://s"}, Drained: []string{"http://s"}},
+		{Key: "c4", Tipo: "search", Destinos: []string{"http://s"}},
+	}
+	desired := []Route{
+		// Solo cambia el orden de los destinos: no es un cambio
+		{Key: "c1", Tipo: "payments", Destinos: []string{"http://b", "http://a"}},
+		{Key: "c2", Tipo: "payments", Destinos: []string{"http://a", "http://c"}, Weights: []DestinoWeight{{Destino: "http://a", Weight: 5}}},
+		{Key: "c3", Tipo: "search", Destinos: []string{"http://s"}, ManagedBy: ManagedByRoutesFile},
+		{Key: "c0", Tipo: "search", Destinos: []string{"http://n"}},
+	}
+	changed := []RouteChange{
+		{Action: ChangeChanged, Tipo: "payments", Key: "c2", AddedDestinos: []string{"http://c"}, WeightsChanged: true},
+		{Action: ChangeAdded, Tipo: "search", Key: "c0", AddedDestinos: []string{"http://n"}},
+		{Action: ChangeChanged, Tipo: "search", Key: "c3", DrainedChanged: true, OwnerChanged: true},
+	}
+	if got := DiffRoutes(current, desired, false); !reflect.DeepEqual(got, changed) {
+		t.Errorf("DiffRoutes(merge) =\n%+v\nse esperaba\n%+v", got, changed)
+	}
+	removed := RouteChange{Action: ChangeRemoved, Tipo: "search", Key: "c4", RemovedDestinos: []string{"http://s"}}
+	want := append(append([]RouteChange{}, changed...), removed)
+	if got := DiffRoutes(current, desired, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffRoutes(replace) =\n%+v\nse esperaba\n%+v", got, want)
+	}
+	if got := DiffRoutes(current, current, true); len(got) != 0 {
+		t.Errorf("DiffRoutes de una tabla consigo misma = %+v", got)
+	}
+}
+
+func TestRoutesFormatsRoundTrip(t *testing.T) {
+	routes := []Route{
+		{Key: "c1", Tipo: "payments", Destinos: []string{"http://a", "http://b"},
+			Weights: []DestinoWeight{{Destino: "http://a", Weight: 3}}, Drained: []string{"http://b"}},
+		{Key: "c2", Tipo: "search", Destinos: []string{"http://s"}, ManagedBy: ManagedByRoutesFile},
+	}
+	for _, format := range []string{FormatJSONL, FormatYAML} {
+		var buf bytes.Buffer
+		if err := WriteRoutes(&buf, routes, format); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadRoutes(&buf, format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if !reflect.DeepEqual(got, routes) {
+			t.Errorf("%s: leído %+v, se esperaba %+v", format, got, routes)
+		}
+	}
+	if _, err := ReadRoutes(strings.NewReader(`{"key": "c1", "tipo": "payments", "destino": "http://a"}`), FormatJSONL); err == nil {
+		t.Error("un campo desconocido debería ser un error")
+	}
+	if _, err := ReadRoutes(strings.NewReader("- key: c1\n  destions: [http://a]\n"), FormatYAML); err == nil {
+		t.Error("un campo desconocido en YAML debería ser un error")
+	}
+}
+
+// importRoutes envía un fichero JSONL a /admin/routes/import y devuelve el resultado
+func importRoutes(t *testing.T, url, query string, routes ...Route) (int, ImportResult) {
+	t.Helper()
+	var body bytes.Buffer
+	if err := WriteRoutes(&body, routes, FormatJSONL); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(url+"/admin/routes/import?"+query, ContentType(FormatJSONL), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result ImportResult
+	json.NewDecoder(resp.Body).Decode(&result)
+	return resp.StatusCode, result
+}
+
+func TestAdminImportModes(t *testing.T) {
+	seed := []Route{
+		{Key: "c1", Tipo: "payments", Destinos: []string{"http://a"}},
+		{Key: "c2", Tipo: "payments", Destinos: []string{"http://b"}},
+	}
+	file := []Route{
+		{Key: "c1", Tipo: "payments", Destinos: []string{"http://a", "http://z"}},
+		{Key: "c3", Tipo: "search", Destinos: []string{"http://s"}},
+	}
+	keys := func(repo *memRepo) []string {
+		routes, _ := repo.FindRoutes(context.Background(), "")
+		var out []string
+		for _, r := range routes {
+			out = append(out, r.Tipo+"/"+r.Key+"="+strings.Join(r.Destinos, ","))
+		}
+		return out
+	}
+
+	t.Run("merge conserva las rutas que no están en el fichero", func(t *testing.T) {
+		srv, repo := newAdminTestServer(t, seed...)
+		code, result := importRoutes(t, srv.URL, "mode=merge", file...)
+		if code != http.StatusOK || result.Added != 1 || result.Changed != 1 || result.Removed != 0 || result.Unchanged != 0 {
+			t.Fatalf("import = %d %+v", code, result)
+		}
+		want := []string{"payments/c1=http://a,http://z", "payments/c2=http://b", "search/c3=http://s"}
+		if got := keys(repo); !reflect.DeepEqual(got, want) {
+			t.Errorf("rutas = %v, se esperaba %v", got, want)
+		}
+	})
+
+	t.Run("replace borra las rutas que no están en el fichero", func(t *testing.T) {
+		srv, repo := newAdminTestServer(t, seed...)
+		code, result := importRoutes(t, srv.URL, "mode=replace", file...)
+		if code != http.StatusOK || result.Added != 1 || result.Changed != 1 || result.Removed != 1 {
+			t.Fatalf("import = %d %+v", code, result)
+		}
+		want := []string{"payments/c1=http://a,http://z", "search/c3=http://s"}
+		if got := keys(repo); !reflect.DeepEqual(got, want) {
+			t.Errorf("rutas = %v, se esperaba %v", got, want)
+		}
+	})
+
+	t.Run("dry run no escribe", func(t *testing.T) {
+		srv, repo := newAdminTestServer(t, seed...)
+		before := keys(repo)
+		code, result := importRoutes(t, srv.URL, "mode=replace&dry_run=true", file...)
+		if code != http.StatusOK || !result.DryRun || len(result.Changes) != 3 {
+			t.Fatalf("import = %d %+v", code, result)
+		}
+		if got := keys(repo); !reflect.DeepEqual(got, before) {
+			t.Errorf("un dry run cambió las rutas: %v, antes %v", got, before)
+		}
+		if v, _ := repo.ListVersions(context.Background(), "c1", "payments"); len(v) != 1 {
+			t.Errorf("un dry run creó %d versiones", len(v)-1)
+		}
+	})
+
+	t.Run("un fichero inválido no escribe nada", func(t *testing.T) {
+		srv, repo := newAdminTestServer(t, seed...)
+		before := keys(repo)
+		bad := append([]Route{{Key: "c9", Tipo: "payments"}}, file...)
+		if code, _ := importRoutes(t, srv.URL, "mode=replace", bad...); code != http.StatusBadRequest {
+			t.Errorf("import inválido = %d, se esperaba 400", code)
+		}
+		if got := keys(repo); !reflect.DeepEqual(got, before) {
+			t.Errorf("un import inválido cambió las rutas: %v", got)
+		}
+	})
+
+	t.Run("modo desconocido", func(t *testing.T) {
+		srv, _ := newAdminTestServer(t, seed...)
+		if code, _ := importRoutes(t, srv.URL, "mode=sync", file...); code != http.StatusBadRequest {
+			t.Errorf("mode=sync = %d, se esperaba 400", code)
+		}
+	})
+}
@@ -6,6 +6,8 @@ import (
 )
 
 type CircuitBreaker struct {
+	name         string
+	open         bool
 	failures     int
 	maxFailures  int
 	openUntil    time.Time
@@ -13,19 +15,35 @@ type CircuitBreaker struct {
 	mu           sync.Mutex
 }
 
-func NewCircuitBreaker(maxFailures int, openDuration time.Duration) *CircuitBreaker {
+// NewCircuitBreaker crea un breaker; name identifica su estado en las métricas
+func NewCircuitBreaker(name string, maxFailures int, openDuration time.Duration) *CircuitBreaker {
+	circuitBreakerState.Set(0, name)
 	return &CircuitBreaker{
+		name:         name,
 		maxFailures:  maxFailures,
 		openDuration: openDuration,
 	}
 }
 
+// SetLimits cambia el umbral de fallos y la duración de apertura; un breaker ya
+// abierto mantiene el plazo con el que se abrió
+func (cb *CircuitBreaker) SetLimits(maxFailures int, openDuration time.Duration) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.maxFailures = maxFailures
+	cb.openDuration = openDuration
+}
+
 func (cb *CircuitBreaker) Allow() bool {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 	if time.Now().Before(cb.openUntil) {
 		return false
 	}
+	if cb.open {
+		cb.open = false
+		circuitBreakerState.Set(0, cb.name)
+	}
 	return true
 }
 
@@ -42,5 +60,7 @@ func (cb *CircuitBreaker) Failure() {
 	if cb.failures >= cb.maxFailures {
 		cb.openUntil = time.Now().Add(cb.openDuration)
 		cb.failures = 0
+		cb.open = true
+		circuitBreakerState.Set(1, cb.name)
 	}
 }
@@ -0,0 +1,167 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseTrustedProxies interpreta una lista separada por comas de CIDRs o IPs sueltas
+func ParseTrustedProxies(spec string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("proxy de confianza inválido: '%s'", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("proxy de confianza inválido: '%s'", entry)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ClientIPResolver obtiene la IP real del cliente. Las cabeceras de reenvío solo se
+// tienen en cuenta si el par inmediato es un proxy de confianza.
+type ClientIPResolver struct {
+	trusted []*net.IPNet
+}
+
+func NewClientIPResolver(trusted []*net.IPNet) *ClientIPResolver {
+	return &ClientIPResolver{trusted: trusted}
+}
+
+func (c *ClientIPResolver) isTrusted(ip net.IP) bool {
+	for _, n := range c.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve recorre la cadena de reenvío de derecha a izquierda y devuelve la primera
+// dirección que no pertenece a un proxy de confianza
+func (c *ClientIPResolver) Resolve(r *http.Request) string {
+	peer := remoteHost(r.RemoteAddr)
+	peerIP := net.ParseIP(peer)
+	if peerIP == nil || !c.isTrusted(peerIP) {
+		return peer
+	}
+
+	chain := forwardedFor(r.Header)
+	if len(chain) == 0 {
+		chain = xForwardedFor(r.Header)
+	}
+	if len(chain) == 0 {
+		if real := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); real != nil {
+			return real.String()
+		}
+		return peer
+	}
+
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := net.ParseIP(chain[i])
+		if ip == nil {
+			// Entrada ofuscada o mal formada: nos quedamos con el último salto válido
+			break
+		}
+		client = ip.String()
+		if !c.isTrusted(ip) {
+			break
+		}
+	}
+	return client
+}
+
+func remoteHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func xForwardedFor(h http.Header) []string {
+	var chain []string
+	for _, v := range h.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				chain = append(chain, hop)
+			}
+		}
+	}
+	return chain
+}
+
+// forwardedFor extrae los valores for= de la cabecera Forwarded (RFC 7239)
+func forwardedFor(h http.Header) []string {
+	var chain []string
+	for _, v := range h.Values("Forwarded") {
+		for _, element := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(name, "for") {
+					continue
+				}
+				chain = append(chain, normalizeForwardedNode(value))
+			}
+		}
+	}
+	return chain
+}
+
+// normalizeForwardedNode quita comillas, corchetes IPv6 y puerto de un nodo Forwarded
+func normalizeForwardedNode(node string) string {
+	node = strings.Trim(node, `"`)
+	if strings.HasPrefix(node, "[") {
+		if end := strings.Index(node, "]"); end > 0 {
+			return node[1:end]
+		}
+	}
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return node
+}
+
+type clientIPKey struct{}
+
+// ClientIPMiddleware resuelve la IP del cliente una vez y la deja en el contexto
+func ClientIPMiddleware(res *ClientIPResolver, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey{}, res.Resolve(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ClientIP devuelve la IP resuelta por ClientIPMiddleware, o la del par inmediato
+// si el middleware no está instalado
+func ClientIP(r *http.Request) string {
+	if ip := ClientIPFromContext(r.Context()); ip != "" {
+		return ip
+	}
+	return remoteHost(r.RemoteAddr)
+}
+
+// ClientIPFromContext devuelve la IP resuelta por ClientIPMiddleware, vacía si no la hay
+func ClientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
@@ -0,0 +1,103 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPResolve(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1, fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := NewClientIPResolver(trusted)
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string][]string
+		want    string
+	}{
+		{"sin cabeceras", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"par no confiable con XFF falsificado", "203.0.113.7:5000",
+			map[string][]string{"X-Forwarded-For": {"1.2.3.4"}}, "203.0.113.7"},
+		{"par no confiable con Forwarded y X-Real-IP falsificados", "203.0.113.7:5000",
+			map[string][]string{"Forwarded": {"for=1.2.3.4"}, "X-Real-IP": {"1.2.3.4"}}, "203.0.113.7"},
+		{"proxy de confianza", "10.0.0.2:5000",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.9"}}, "198.51.100.9"},
+		{"varios saltos de confianza", "10.0.0.2:5000",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.9, 192.168.1.1, 10.1.2.3"}}, "198.51.100.9"},
+		{"el cliente no puede anteponer IPs", "10.0.0.2:5000",
+			map[string][]string{"X-Forwarded-For": {"1.2.3.4, 198.51.100.9, 10.1.2.3"}}, "198.51.100.9"},
+		{"XFF repartido en varias cabeceras", "10.0.0.2:5000",
+			map[string][]string{"X-Forwarded-For": {"1.2.3.4", "198.51.100.9, 10.1.2.3"}}, "198.51.100.9"},
+		{"todos los saltos de confianza", "10.0.0.2:5000",
+			map[string][]string{"X-Forwarded-For": {"10.9.9.9, 10.1.2.3"}}, "10.9.9.9"},
+		{"Forwarded con IPv6 entre comillas y puerto", "10.0.0.2:5000",
+			map[string][]string{"Forwarded": {`for="[2001:db8::17]:4711";proto=https, for=10.1.2.3`}}, "2001:db8::17"},
+		{"Forwarded con IPv4 y puerto", "10.0.0.2:5000",
+			map[string][]string{"Forwarded": {`For="198.51.100.9:8080";by=10.0.0.2`}}, "198.51.100.9"},
+		{"Forwarded tiene prioridad sobre XFF", "10.0.0.2:5000",
+			map[string][]string{"Forwarded": {"for=198.51.100.9"}, "X-Forwarded-For": {"1.2.3.4"}}, "198.51.100.9"},
+		{"proxy IPv6 de confianza", "[fd00::1]:5000",
+			map[string][]string{"Forwarded": {`for="[2001:db8::17]"`}}, "2001:db8::17"},
+		{"X-Real-IP si no hay cadena", "10.0.0.2:5000",
+			map[string][]string{"X-Real-IP": {" 198.51.100.9 "}}, "198.51.100.9"},
+		{"X-Real-IP mal formado", "10.0.0.2:5000",
+			map[string][]string{"X-Real-IP": {"no-es-una-ip"}}, "10.0.0.2"},
+		{"entrada basura tras el cliente", "10.0.0.2:5000",
+			map[string][]string{"X-Forwarded-For": {"basura, 198.51.100.9"}}, "198.51.100.9"},
+		{"entrada basura en el último salto", "10.0.0.2:5000",
+			map[string][]string{"X-Forwarded-For": {"198.51.100.9, basura"}}, "10.0.0.2"},
+		{"nodo Forwarded ofuscado", "10.0.0.2:5000",
+			map[string][]string{"Forwarded": {"for=198.51.100.9, for=_hidden, for=10.1.2.3"}}, "10.1.2.3"},
+		{"Forwarded unknown", "10.0.0.2:5000",
+			map[string][]string{"Forwarded": {"for=unknown"}}, "10.0.0.2"},
+		{"XFF vacío", "10.0.0.2:5000",
+			map[string][]string{"X-Forwarded-For": {" , ,"}}, "10.0.0.2"},
+		{"RemoteAddr sin puerto", "203.0.113.7", nil, "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/route/payments/c1", nil)
+			r.RemoteAddr = tt.remote
+			for k, vs := range tt.headers {
+				for _, v := range vs {
+					r.Header.Add(k, v)
+				}
+			}
+			if got := res.Resolve(r); got != tt.want {
+				t.Errorf("Resolve() = %q, se esperaba %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesErrors(t *testing.T) {
+	for _, spec := range []string{"10.0.0.0/33", "no-es-una-ip", "10.0.0.1, 300.0.0.1"} {
+		if _, err := ParseTrustedProxies(spec); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) no devolvió error", spec)
+		}
+	}
+	if nets, err := ParseTrustedProxies(" , "); err != nil || len(nets) != 0 {
+		t.Errorf("una lista vacía = %v, %v", nets, err)
+	}
+}
+
+func TestClientIPMiddleware(t *testing.T) {
+	trusted, _ := ParseTrustedProxies("10.0.0.0/8")
+	var got string
+	h := ClientIPMiddleware(NewClientIPResolver(trusted), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ClientIP(r)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.2:5000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.9")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if got != "198.51.100.9" {
+		t.Errorf("ClientIP() = %q en el handler, se esperaba la del cliente", got)
+	}
+	if ip := ClientIP(r); ip != "10.0.0.2" {
+		t.Errorf("ClientIP() sin middleware = %q, se esperaba el par inmediato", ip)
+	}
+}
@@ -3,15 +3,18 @@ package router
 import (
 	"encoding/json"
 	"io"
-	"log"
 	"net/http"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"router-app/config"
+	"router-app/logging"
 )
 
+var handlerLog = logging.For("handler")
+
 var (
 	validKey  = regexp.MustCompile(`^[a-zA-Z0-9\-_]+$`)
 	validTipo = regexp.MustCompile(`^[a-zA-Z0-9\-_]+$`)
@@ -22,72 +25,120 @@ func validateParam(param string, maxLen int, re *regexp.Regexp) bool {
 	return len(param) > 0 && len(param) <= maxLen && re.MatchString(param)
 }
 
+// writeJSONError responde con un cuerpo {"error": msg}, con el mismo formato JSON que las respuestas correctas
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// handlerLimits son los límites de validación; se sustituyen juntos al recargar
+type handlerLimits struct {
+	maxKeyLength     int
+	maxTipoLength    int
+	maxDestinoLength int
+	maxBodySize      int
+	maxImportSize    int
+}
+
+func limitsFromConfig(cfg *config.Config) *handlerLimits {
+	return &handlerLimits{
+		maxKeyLength:     cfg.MaxKeyLength,
+		maxTipoLength:    cfg.MaxTipoLength,
+		maxDestinoLength: cfg.MaxDestinoLength,
+		maxBodySize:      cfg.MaxBodySize,
+		maxImportSize:    cfg.MaxImportSize,
+	}
+}
+
 type Handler struct {
-	svc Service
+	svc    Service
+	limits atomic.Pointer[handlerLimits]
+	cb     *CircuitBreaker
 }
 
-func NewHandler(svc Service) *Handler {
-	return &Handler{svc: svc}
+func NewHandler(svc Service, cfg *config.Config) *Handler {
+	h := &Handler{
+		svc: svc,
+		cb: NewCircuitBreaker(
+			"internal",
+			cfg.CircuitBreakerMaxFailures,
+			time.Duration(cfg.CircuitBreakerOpenSeconds)*time.Second,
+		),
+	}
+	h.ApplyConfig(cfg)
+	return h
+}
+
+// ApplyConfig aplica los límites de validación y del circuit breaker de cfg
+func (h *Handler) ApplyConfig(cfg *config.Config) {
+	h.limits.Store(limitsFromConfig(cfg))
+	h.cb.SetLimits(cfg.CircuitBreakerMaxFailures, time.Duration(cfg.CircuitBreakerOpenSeconds)*time.Second)
 }
 
 func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
-	log.Println("Registering routes...")
-	mux.HandleFunc("/route/", h.RouteRequest)
-	mux.HandleFunc("/add-destino/", h.AddDestino)
+	handlerLog.Info("Registrando rutas")
+	mux.Handle("/route/", InstrumentHandler("route", http.HandlerFunc(h.RouteRequest)))
+	mux.Handle("/add-destino/", InstrumentHandler("add-destino", http.HandlerFunc(h.AddDestino)))
 }
 
 func (h *Handler) RouteRequest(w http.ResponseWriter, r *http.Request) {
-	log.Println("[RouteRequest] Solicitud recibida:", r.Method, r.URL.Path)
+	ctx := r.Context()
+	handlerLog.DebugContext(ctx, "Solicitud recibida", "method", r.Method, "path", r.URL.Path)
 	if r.Method != http.MethodGet {
-		log.Printf("[RouteRequest] Método inválido: %s\n", r.Method)
+		handlerLog.DebugContext(ctx, "Método inválido", "method", r.Method)
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/route/"), "/")
-	log.Printf("[RouteRequest] URL parts extraídos: %#v", parts)
 	if len(parts) != 2 {
-		log.Printf("[RouteRequest] Formato de ruta inválido: %s", r.URL.Path)
+		handlerLog.DebugContext(ctx, "Formato de ruta inválido", "path", r.URL.Path)
 		http.Error(w, "Formato de ruta inválido. Usa /route/{tipo}/{key}", http.StatusBadRequest)
 		return
 	}
 	tipo, key := parts[0], parts[1]
-	log.Printf("[RouteRequest] tipo='%s', key='%s'", tipo, key)
 
-	if !validateParam(tipo, config.MaxTipoLength, validTipo) {
-		log.Printf("[RouteRequest] Validación fallida para tipo: '%s'", tipo)
+	limits := h.limits.Load()
+	if !validateParam(tipo, limits.maxTipoLength, validTipo) {
+		handlerLog.DebugContext(ctx, "Validación fallida para tipo", "tipo", tipo)
 		http.Error(w, "Parámetro 'tipo' inválido", http.StatusBadRequest)
 		return
 	}
-	if !validateParam(key, config.MaxKeyLength, validKey) {
-		log.Printf("[RouteRequest] Validación fallida para key: '%s'", key)
+	if !validateParam(key, limits.maxKeyLength, validKey) {
+		handlerLog.DebugContext(ctx, "Validación fallida para key", "key", key)
 		http.Error(w, "Parámetro 'key' inválido", http.StatusBadRequest)
 		return
 	}
+	ctx = logging.WithAttrs(ctx, "tipo", tipo, "key", key)
+	r = r.WithContext(ctx)
+	if !authorize(w, r, ScopeRead, tipo) {
+		return
+	}
 
-	log.Printf("[RouteRequest] Buscando destino para tipo='%s', key='%s'", tipo, key)
-	destino, err := h.svc.GetBalancedRoute(key, tipo)
+	destino, err := h.svc.GetBalancedRoute(ctx, key, tipo)
 	if err != nil {
-		log.Printf("[RouteRequest] Error al obtener destino: %v", err)
+		handlerLog.DebugContext(ctx, "Error al obtener destino", "error", err)
 		http.Error(w, "No route found", http.StatusNotFound)
 		return
 	}
 	if destino == "" {
-		log.Printf("[RouteRequest] No se encontró destino para tipo='%s', key='%s'", tipo, key)
+		handlerLog.DebugContext(ctx, "No se encontró destino")
 		http.Error(w, "No route found", http.StatusNotFound)
 		return
 	}
 
-	log.Printf("[RouteRequest] Destino encontrado: %s", destino)
+	handlerLog.DebugContext(ctx, "Destino encontrado", "destino", destino)
 	response := map[string]string{"destino": destino}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
 func (h *Handler) AddDestino(w http.ResponseWriter, r *http.Request) {
-	log.Println("Received request for AddDestino")
+	ctx := r.Context()
+	handlerLog.DebugContext(ctx, "Solicitud recibida", "method", r.Method, "path", r.URL.Path)
 	if r.Method != http.MethodPost {
-		log.Printf("Invalid method: %s\n", r.Method)
+		handlerLog.DebugContext(ctx, "Método inválido", "method", r.Method)
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
@@ -100,14 +151,20 @@ func (h *Handler) AddDestino(w http.ResponseWriter, r *http.Request) {
 	}
 	tipo, key := parts[0], parts[1]
 
-	// Usa los valores de config.go
-	if !validateParam(tipo, config.MaxTipoLength, validTipo) || !validateParam(key, config.MaxKeyLength, validKey) {
+	// Usa los límites de la configuración
+	limits := h.limits.Load()
+	if !validateParam(tipo, limits.maxTipoLength, validTipo) || !validateParam(key, limits.maxKeyLength, validKey) {
 		http.Error(w, "Parámetros inválidos", http.StatusBadRequest)
 		return
 	}
+	ctx = logging.WithAttrs(ctx, "tipo", tipo, "key", key)
+	r = r.WithContext(ctx)
+	if !authorize(w, r, ScopeAdmin, tipo) {
+		return
+	}
 
-	// Limitar el tamaño del cuerpo de la solicitud usando config.MaxBodySize
-	r.Body = http.MaxBytesReader(w, r.Body, int64(config.MaxBodySize))
+	// Limitar el tamaño del cuerpo de la solicitud usando MaxBodySize de la configuración
+	r.Body = http.MaxBytesReader(w, r.Body, int64(limits.maxBodySize))
 	var req struct {
 		Destino string `json:"destino"`
 	}
@@ -121,47 +178,41 @@ func (h *Handler) AddDestino(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !validateParam(req.Destino, config.MaxDestinoLength, validURL) {
+	if !validateParam(req.Destino, limits.maxDestinoLength, validURL) {
 		http.Error(w, "Destino inválido", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("Decoded destino: %s\n", req.Destino)
-	err := h.svc.AddDestino(key, tipo, req.Destino)
+	err := h.svc.AddDestino(ctx, key, tipo, req.Destino)
 	if err != nil {
-		log.Printf("Error saving destino for key %s, tipo %s: %v\n", key, tipo, err)
+		handlerLog.ErrorContext(ctx, "Error guardando destino", "destino", req.Destino, "error", err)
 		http.Error(w, "Could not save", http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("Destino added successfully for key %s, tipo %s\n", key, tipo)
+	handlerLog.InfoContext(ctx, "Destino agregado", "destino", req.Destino)
 	response := map[string]string{"status": "added"}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-var cb = NewCircuitBreaker(
-	config.CircuitBreakerMaxFailures,
-	time.Duration(config.CircuitBreakerOpenSeconds)*time.Second,
-)
-
 func llamadaAUnServicioInterno() error {
 	// Simulación de llamada a un servicio interno
 	return nil
 }
 
 func (h *Handler) SomeInternalCallHandler(w http.ResponseWriter, r *http.Request) {
-	if !cb.Allow() {
+	if !h.cb.Allow() {
 		http.Error(w, "Servicio temporalmente no disponible", http.StatusServiceUnavailable)
 		return
 	}
 	err := llamadaAUnServicioInterno()
 	if err != nil {
-		cb.Failure()
-		log.Printf("Error en llamada a servicio interno: %v\n", err)
+		h.cb.Failure()
+		handlerLog.ErrorContext(r.Context(), "Error en llamada a servicio interno", "error", err)
 		http.Error(w, "Error interno", http.StatusInternalServerError)
 		return
 	}
-	cb.Success()
+	h.cb.Success()
 	w.WriteHeader(http.StatusNoContent)
 }
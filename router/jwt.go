@@ -0,0 +1,374 @@
+package router
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWTOptions agrupa los parámetros de validación de tokens JWT
+type JWTOptions struct {
+	JWKSFile   string
+	Issuer     string
+	Audience   string
+	Leeway     time.Duration
+	ReadScope  string
+	AdminScope string
+	// TiposClaim es el claim con los patrones de tipo permitidos, DefaultJWTTiposClaim
+	// si está vacío; JWTTiposClaimOff desactiva la restricción por tipo
+	TiposClaim string
+}
+
+const (
+	DefaultJWTTiposClaim = "tipos"
+	JWTTiposClaimOff     = "off"
+)
+
+type jwtAuthenticator struct {
+	opts JWTOptions
+	keys *jwksCache
+	now  func() time.Time
+}
+
+// NewJWTAuthenticator autentica tokens "Authorization: Bearer" firmados con las
+// claves del fichero JWKS local, que se recarga cuando cambia en disco
+func NewJWTAuthenticator(opts JWTOptions) (Authenticator, error) {
+	keys := &jwksCache{path: opts.JWKSFile}
+	if err := keys.reload(); err != nil {
+		return nil, err
+	}
+	return &jwtAuthenticator{opts: opts, keys: keys, now: time.Now}, nil
+}
+
+func (a *jwtAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	authz := r.Header.Get("Authorization")
+	if len(authz) < 7 || !strings.EqualFold(authz[:7], "Bearer ") {
+		return nil, ErrNoCredentials
+	}
+	claims, err := a.verify(strings.TrimSpace(authz[7:]))
+	if err != nil {
+		return nil, err
+	}
+	return a.identity(claims), nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func (a *jwtAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token JWT mal formado")
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("cabecera JWT inválida: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("firma JWT mal codificada")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	verified := false
+	for _, k := range a.keys.candidates(header.Kid, header.Alg) {
+		if k.verify(header.Alg, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("firma JWT inválida (alg=%s, kid=%s)", header.Alg, header.Kid)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("claims JWT inválidos: %w", err)
+	}
+	if err := a.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (a *jwtAuthenticator) validateClaims(claims map[string]interface{}) error {
+	now := a.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token JWT sin 'exp'")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(a.opts.Leeway)) {
+		return errors.New("token JWT expirado")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(a.opts.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token JWT aún no válido")
+	}
+	if a.opts.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != a.opts.Issuer {
+			return fmt.Errorf("emisor JWT no aceptado: '%s'", iss)
+		}
+	}
+	if a.opts.Audience != "" && !containsString(claimStrings(claims["aud"]), a.opts.Audience) {
+		return errors.New("audiencia JWT no aceptada")
+	}
+	return nil
+}
+
+func (a *jwtAuthenticator) identity(claims map[string]interface{}) *Identity {
+	sub, _ := claims["sub"].(string)
+	id := &Identity{Subject: sub, Scheme: "jwt"}
+
+	granted := claimStrings(claims["scope"])
+	granted = append(granted, claimStrings(claims["scp"])...)
+	for _, s := range granted {
+		switch s {
+		case a.opts.AdminScope:
+			id.Scopes = append(id.Scopes, ScopeAdmin)
+		case a.opts.ReadScope:
+			id.Scopes = append(id.Scopes, ScopeRead)
+		}
+	}
+	// Sin el claim de tipos el token no accede a ningún tipo; "*" los permite
+	// todos. Solo con TiposClaim "off" no se restringe por tipo.
+	if a.opts.TiposClaim == JWTTiposClaimOff {
+		return id
+	}
+	claim := a.opts.TiposClaim
+	if claim == "" {
+		claim = DefaultJWTTiposClaim
+	}
+	tipos := claimStrings(claims[claim])
+	id.ReadTipos, id.WriteTipos = tipos, tipos
+	id.NoTipos = len(tipos) == 0
+	return id
+}
+
+// claimStrings normaliza un claim que puede ser una cadena separada por espacios o un array
+func claimStrings(v interface{}) []string {
+	switch c := v.(type) {
+	case string:
+		return strings.Fields(c)
+	case []interface{}:
+		out := make([]string, 0, len(c))
+		for _, item := range c {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// jwk es la representación JSON de una clave dentro de un JWKS
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+type verificationKey struct {
+	kid string
+	alg string
+	key interface{}
+}
+
+func (k *verificationKey) verify(alg string, signed, sig []byte) bool {
+	digest := sha256.Sum256(signed)
+	switch alg {
+	case "RS256":
+		pub, ok := k.key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil
+	case "ES256":
+		pub, ok := k.key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, digest[:], r, s)
+	case "HS256":
+		secret, ok := k.key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	}
+	return false
+}
+
+func parseJWK(j jwk) (*verificationKey, error) {
+	k := &verificationKey{kid: j.Kid, alg: j.Alg}
+	switch j.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return nil, err
+		}
+		k.key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	case "EC":
+		if j.Crv != "P-256" {
+			return nil, fmt.Errorf("curva no soportada: %s", j.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		k.key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(j.K)
+		if err != nil {
+			return nil, err
+		}
+		k.key = secret
+	default:
+		return nil, fmt.Errorf("tipo de clave no soportado: %s", j.Kty)
+	}
+	return k, nil
+}
+
+// algMatchesKey evita la confusión de algoritmos (p. ej. HS256 con una clave RSA pública)
+func algMatchesKey(alg string, key interface{}) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256"
+	case *ecdsa.PublicKey:
+		return alg == "ES256"
+	case []byte:
+		return alg == "HS256"
+	}
+	return false
+}
+
+// jwksCache mantiene las claves del fichero JWKS y lo relee cuando cambia su fecha de modificación
+type jwksCache struct {
+	path      string
+	mu        sync.RWMutex
+	keys      []*verificationKey
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+const jwksCheckInterval = time.Second
+
+func (c *jwksCache) reload() error {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return fmt.Errorf("no se pudo leer el JWKS %s: %w", c.path, err)
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("no se pudo leer el JWKS %s: %w", c.path, err)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("JWKS %s inválido: %w", c.path, err)
+	}
+	keys := make([]*verificationKey, 0, len(set.Keys))
+	for _, j := range set.Keys {
+		k, err := parseJWK(j)
+		if err != nil {
+			authLog.Warn("Ignorando clave JWKS", "kid", j.Kid, "error", err)
+			continue
+		}
+		keys = append(keys, k)
+	}
+	c.mu.Lock()
+	c.keys = keys
+	c.modTime = info.ModTime()
+	c.lastCheck = time.Now()
+	c.mu.Unlock()
+	authLog.Info("JWKS cargado", "path", c.path, "claves", len(keys))
+	return nil
+}
+
+// refreshIfChanged relee el fichero como mucho una vez por jwksCheckInterval
+func (c *jwksCache) refreshIfChanged() {
+	c.mu.RLock()
+	due := time.Since(c.lastCheck) >= jwksCheckInterval
+	modTime := c.modTime
+	c.mu.RUnlock()
+	if !due {
+		return
+	}
+	c.mu.Lock()
+	c.lastCheck = time.Now()
+	c.mu.Unlock()
+	info, err := os.Stat(c.path)
+	if err != nil || info.ModTime().Equal(modTime) {
+		return
+	}
+	if err := c.reload(); err != nil {
+		authLog.Error("Error recargando JWKS, se mantienen las claves anteriores", "error", err)
+	}
+}
+
+func (c *jwksCache) candidates(kid, alg string) []*verificationKey {
+	c.refreshIfChanged()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var out []*verificationKey
+	for _, k := range c.keys {
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		if k.alg != "" && k.alg != alg {
+			continue
+		}
+		if !algMatchesKey(alg, k.key) {
+			continue
+		}
+		out = append(out, k)
+	}
+	return out
+}
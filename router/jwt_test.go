@@ -0,0 +1,414 @@
+package router
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+var jwtTestNow = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// testJWKS reúne las claves privadas cuyas partes públicas se escriben en el JWKS
+type testJWKS struct {
+	rsa    *rsa.PrivateKey
+	ec     *ecdsa.PrivateKey
+	secret []byte
+	path   string
+}
+
+func newTestJWKS(t *testing.T) *testJWKS {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &testJWKS{rsa: rsaKey, ec: ecKey, secret: []byte("secreto-compartido-de-pruebas"), path: filepath.Join(t.TempDir(), "jwks.json")}
+	k.write(t, k.jwks(), jwtTestNow)
+	return k
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, pub *rsa.PublicKey) jwk {
+	return jwk{Kty: "RSA", Kid: kid, Alg: "RS256", N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes())}
+}
+
+func (k *testJWKS) jwks() []jwk {
+	return []jwk{
+		rsaJWK("rsa-1", &k.rsa.PublicKey),
+		{Kty: "EC", Kid: "ec-1", Alg: "ES256", Crv: "P-256", X: b64(k.ec.X.FillBytes(make([]byte, 32))), Y: b64(k.ec.Y.FillBytes(make([]byte, 32)))},
+		{Kty: "oct", Kid: "hs-1", Alg: "HS256", K: b64(k.secret)},
+	}
+}
+
+// write escribe el JWKS y fija su fecha de modificación, que es lo que jwksCache
+// compara para recargarlo
+func (k *testJWKS) write(t *testing.T, keys []jwk, modTime time.Time) {
+	t.Helper()
+	data, err := json.Marshal(map[string][]jwk{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(k.path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(k.path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// signJWT construye un token con la cabecera indicada firmado con key: una clave
+// RSA o EC privada, o un secreto HMAC
+func signJWT(t *testing.T, header map[string]string, claims map[string]any, key any) string {
+	t.Helper()
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := b64(h) + "." + b64(c)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case nil:
+	default:
+		t.Fatalf("clave de firma no soportada %T", key)
+	}
+	return signed + "." + b64(sig)
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"sub":   "deployer",
+		"iss":   "https://issuer.example.com",
+		"aud":   "router-app",
+		"exp":   jwtTestNow.Add(time.Hour).Unix(),
+		"scope": "routes:read routes:admin",
+		"tipos": []string{"pagos"},
+	}
+}
+
+func withClaims(changes map[string]any) map[string]any {
+	c := validClaims()
+	for k, v := range changes {
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+func newTestJWTAuthenticator(t *testing.T, keys *testJWKS, opts JWTOptions) *jwtAuthenticator {
+	t.Helper()
+	opts.JWKSFile = keys.path
+	if opts.ReadScope == "" {
+		opts.ReadScope, opts.AdminScope = "routes:read", "routes:admin"
+	}
+	a, err := NewJWTAuthenticator(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ja := a.(*jwtAuthenticator)
+	ja.now = func() time.Time { return jwtTestNow }
+	return ja
+}
+
+func bearer(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/route/pagos/cliente-1", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestJWTSignatures(t *testing.T) {
+	keys := newTestJWKS(t)
+	a := newTestJWTAuthenticator(t, keys, JWTOptions{Issuer: "https://issuer.example.com", Audience: "router-app"})
+	otherRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&keys.rsa.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	claims := validClaims()
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		key    any
+		ok     bool
+	}{
+		{"RS256", map[string]string{"alg": "RS256", "kid": "rsa-1"}, keys.rsa, true},
+		{"ES256", map[string]string{"alg": "ES256", "kid": "ec-1"}, keys.ec, true},
+		{"HS256", map[string]string{"alg": "HS256", "kid": "hs-1"}, keys.secret, true},
+		{"sin kid prueba todas las claves", map[string]string{"alg": "ES256"}, keys.ec, true},
+		{"RS256 con otra clave", map[string]string{"alg": "RS256", "kid": "rsa-1"}, otherRSA, false},
+		{"kid desconocido", map[string]string{"alg": "RS256", "kid": "rsa-2"}, keys.rsa, false},
+		{"kid de otra clave", map[string]string{"alg": "RS256", "kid": "ec-1"}, keys.rsa, false},
+		{"HS256 firmado con la clave pública RSA en PEM", map[string]string{"alg": "HS256", "kid": "rsa-1"}, pubPEM, false},
+		{"HS256 firmado con la clave pública RSA en DER", map[string]string{"alg": "HS256"}, pubDER, false},
+		{"HS256 firmado con el módulo RSA", map[string]string{"alg": "HS256"}, keys.rsa.N.Bytes(), false},
+		{"alg none sin firma", map[string]string{"alg": "none"}, nil, false},
+		{"alg none con kid", map[string]string{"alg": "none", "kid": "hs-1"}, nil, false},
+		{"alg desconocido", map[string]string{"alg": "RS512", "kid": "rsa-1"}, keys.rsa, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := a.Authenticate(bearer(signJWT(t, tt.header, claims, tt.key)))
+			if tt.ok && (err != nil || id.Subject != "deployer" || id.Scheme != "jwt") {
+				t.Errorf("Authenticate() = %+v, %v; se esperaba el token aceptado", id, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("se aceptó el token: %+v", id)
+			}
+		})
+	}
+}
+
+func TestJWTRejectsTamperedOrMalformedTokens(t *testing.T) {
+	keys := newTestJWKS(t)
+	a := newTestJWTAuthenticator(t, keys, JWTOptions{})
+	token := signJWT(t, map[string]string{"alg": "RS256", "kid": "rsa-1"}, validClaims(), keys.rsa)
+	parts := strings.Split(token, ".")
+	forged, _ := json.Marshal(withClaims(map[string]any{"tipos": "*"}))
+
+	for name, tok := range map[string]string{
+		"payload alterado":  parts[0] + "." + b64(forged) + "." + parts[2],
+		"firma vacía":       parts[0] + "." + parts[1] + ".",
+		"firma sin base64":  parts[0] + "." + parts[1] + ".%%%",
+		"dos segmentos":     parts[0] + "." + parts[1],
+		"cabecera inválida": "e30x." + parts[1] + "." + parts[2],
+	} {
+		if _, err := a.Authenticate(bearer(tok)); err == nil {
+			t.Errorf("%s: se aceptó el token", name)
+		}
+	}
+
+	for name, authz := range map[string]string{"sin cabecera": "", "otro esquema": "Basic dXNlcjpwYXNz"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if authz != "" {
+			r.Header.Set("Authorization", authz)
+		}
+		if _, err := a.Authenticate(r); !errors.Is(err, ErrNoCredentials) {
+			t.Errorf("%s: error = %v, se esperaba ErrNoCredentials", name, err)
+		}
+	}
+}
+
+func TestJWTClaims(t *testing.T) {
+	keys := newTestJWKS(t)
+	a := newTestJWTAuthenticator(t, keys, JWTOptions{Issuer: "https://issuer.example.com", Audience: "router-app", Leeway: 30 * time.Second})
+	tests := []struct {
+		name    string
+		changes map[string]any
+		ok      bool
+	}{
+		{"válido", nil, true},
+		{"sin exp", map[string]any{"exp": nil}, false},
+		{"exp no numérico", map[string]any{"exp": "mañana"}, false},
+		{"expirado dentro del margen", map[string]any{"exp": jwtTestNow.Add(-20 * time.Second).Unix()}, true},
+		{"expirado fuera del margen", map[string]any{"exp": jwtTestNow.Add(-time.Minute).Unix()}, false},
+		{"nbf dentro del margen", map[string]any{"nbf": jwtTestNow.Add(20 * time.Second).Unix()}, true},
+		{"nbf fuera del margen", map[string]any{"nbf": jwtTestNow.Add(time.Minute).Unix()}, false},
+		{"otro emisor", map[string]any{"iss": "https://evil.example.com"}, false},
+		{"sin emisor", map[string]any{"iss": nil}, false},
+		{"audiencia en array", map[string]any{"aud": []string{"otra", "router-app"}}, true},
+		{"otra audiencia", map[string]any{"aud": "otra"}, false},
+		{"sin audiencia", map[string]any{"aud": nil}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signJWT(t, map[string]string{"alg": "ES256", "kid": "ec-1"}, withClaims(tt.changes), keys.ec)
+			_, err := a.Authenticate(bearer(token))
+			if tt.ok && err != nil {
+				t.Errorf("se rechazó el token: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("se aceptó el token")
+			}
+		})
+	}
+
+	// Sin Issuer ni Audience configurados no se comprueban
+	lax := newTestJWTAuthenticator(t, keys, JWTOptions{})
+	token := signJWT(t, map[string]string{"alg": "ES256"}, withClaims(map[string]any{"iss": nil, "aud": nil}), keys.ec)
+	if _, err := lax.Authenticate(bearer(token)); err != nil {
+		t.Errorf("sin iss/aud configurados: %v", err)
+	}
+}
+
+func TestJWTIdentity(t *testing.T) {
+	keys := newTestJWKS(t)
+	tests := []struct {
+		name       string
+		tiposClaim string
+		changes    map[string]any
+		scopes     []string
+		read       map[string]bool // tipo → CanRead esperado
+	}{
+		{
+			name:   "claim tipos",
+			scopes: []string{ScopeRead, ScopeAdmin},
+			read:   map[string]bool{"pagos": true, "envios": false},
+		},
+		{
+			name:    "sin claim tipos no accede a ningún tipo",
+			changes: map[string]any{"tipos": nil},
+			scopes:  []string{ScopeRead, ScopeAdmin},
+			read:    map[string]bool{"pagos": false, "envios": false},
+		},
+		{
+			name:    "claim tipos vacío no accede a ningún tipo",
+			changes: map[string]any{"tipos": []string{}},
+			scopes:  []string{ScopeRead, ScopeAdmin},
+			read:    map[string]bool{"pagos": false},
+		},
+		{
+			name:    "comodín en cadena separada por espacios",
+			changes: map[string]any{"tipos": "pag* envios"},
+			scopes:  []string{ScopeRead, ScopeAdmin},
+			read:    map[string]bool{"pagos": true, "envios": true, "search": false},
+		},
+		{
+			name:       "claim con otro nombre",
+			tiposClaim: "https://example.com/tipos",
+			changes:    map[string]any{"https://example.com/tipos": []string{"envios"}},
+			scopes:     []string{ScopeRead, ScopeAdmin},
+			read:       map[string]bool{"pagos": false, "envios": true},
+		},
+		{
+			name:       "off no restringe por tipo",
+			tiposClaim: JWTTiposClaimOff,
+			changes:    map[string]any{"tipos": nil},
+			scopes:     []string{ScopeRead, ScopeAdmin},
+			read:       map[string]bool{"pagos": true, "envios": true},
+		},
+		{
+			name:    "scopes desde scp y sin los desconocidos",
+			changes: map[string]any{"scope": nil, "scp": []string{"routes:read", "routes:delete"}},
+			scopes:  []string{ScopeRead},
+			read:    map[string]bool{"pagos": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestJWTAuthenticator(t, keys, JWTOptions{TiposClaim: tt.tiposClaim})
+			token := signJWT(t, map[string]string{"alg": "RS256", "kid": "rsa-1"}, withClaims(tt.changes), keys.rsa)
+			id, err := a.Authenticate(bearer(token))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(id.Scopes, tt.scopes) {
+				t.Errorf("Scopes = %v, se esperaba %v", id.Scopes, tt.scopes)
+			}
+			for tipo, want := range tt.read {
+				if got := id.CanRead(tipo); got != want {
+					t.Errorf("CanRead(%q) = %v, se esperaba %v", tipo, got, want)
+				}
+				if got := id.CanWrite(tipo); got != want {
+					t.Errorf("CanWrite(%q) = %v, se esperaba %v", tipo, got, want)
+				}
+			}
+		})
+	}
+}
+
+// expireJWKSCheck hace que la siguiente verificación mire el fichero sin esperar
+// jwksCheckInterval
+func expireJWKSCheck(a *jwtAuthenticator) {
+	a.keys.mu.Lock()
+	a.keys.lastCheck = time.Time{}
+	a.keys.mu.Unlock()
+}
+
+func TestJWKSReload(t *testing.T) {
+	keys := newTestJWKS(t)
+	a := newTestJWTAuthenticator(t, keys, JWTOptions{})
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken := signJWT(t, map[string]string{"alg": "RS256", "kid": "rsa-1"}, validClaims(), keys.rsa)
+	newToken := signJWT(t, map[string]string{"alg": "RS256", "kid": "rsa-2"}, validClaims(), rotated)
+	if _, err := a.Authenticate(bearer(newToken)); err == nil {
+		t.Fatal("se aceptó un token con una clave que aún no está en el JWKS")
+	}
+
+	keys.write(t, []jwk{rsaJWK("rsa-2", &rotated.PublicKey)}, jwtTestNow.Add(time.Minute))
+	// Dentro de jwksCheckInterval se siguen usando las claves en memoria
+	if _, err := a.Authenticate(bearer(oldToken)); err != nil {
+		t.Errorf("se recargó el JWKS antes de jwksCheckInterval: %v", err)
+	}
+	expireJWKSCheck(a)
+	if _, err := a.Authenticate(bearer(newToken)); err != nil {
+		t.Errorf("tras rotar el JWKS se rechaza la clave nueva: %v", err)
+	}
+	if _, err := a.Authenticate(bearer(oldToken)); err == nil {
+		t.Error("tras rotar el JWKS se sigue aceptando la clave retirada")
+	}
+
+	// Un JWKS roto no sustituye a las claves válidas
+	if err := os.WriteFile(keys.path, []byte("{no es json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(keys.path, jwtTestNow.Add(2*time.Minute), jwtTestNow.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	expireJWKSCheck(a)
+	if _, err := a.Authenticate(bearer(newToken)); err != nil {
+		t.Errorf("con el JWKS roto se perdieron las claves anteriores: %v", err)
+	}
+}
+
+func TestNewJWTAuthenticatorErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewJWTAuthenticator(JWTOptions{JWKSFile: filepath.Join(dir, "no-existe.json")}); err == nil {
+		t.Error("sin fichero JWKS NewJWTAuthenticator debería fallar")
+	}
+	bad := filepath.Join(dir, "jwks.json")
+	if err := os.WriteFile(bad, []byte("[]"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewJWTAuthenticator(JWTOptions{JWKSFile: bad}); err == nil {
+		t.Error("con un JWKS inválido NewJWTAuthenticator debería fallar")
+	}
+}
@@ -0,0 +1,284 @@
+package router
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrKeyNotFound indica que la API key no existe en el almacén
+var ErrKeyNotFound = errors.New("API key no encontrada")
+
+// APIKeyRecord es la definición de una API key y su política de acceso. Las claves
+// se guardan por su hash SHA-256, nunca en claro.
+type APIKeyRecord struct {
+	KeyHash string   `bson:"key_hash" json:"-"`
+	Name    string   `bson:"name" json:"name"`
+	Scopes  []string `bson:"scopes" json:"scopes"`
+	// Patrones de tipo (sintaxis path.Match) permitidos para lectura y escritura
+	ReadTipos  []string `bson:"read_tipos" json:"read_tipos"`
+	WriteTipos []string `bson:"write_tipos" json:"write_tipos"`
+	// Overrides por política de rate limiting, con formato "limit/window[:burst]"
+	RateLimits map[string]string `bson:"rate_limits,omitempty" json:"rate_limits,omitempty"`
+}
+
+// Identity construye la identidad autenticada que corresponde al registro
+func (rec *APIKeyRecord) Identity() *Identity {
+	return &Identity{
+		Subject:    rec.Name,
+		Scheme:     "api-key",
+		Scopes:     rec.Scopes,
+		ReadTipos:  rec.ReadTipos,
+		WriteTipos: rec.WriteTipos,
+		RateLimits: parseRateLimitOverrides(rec.Name, rec.RateLimits),
+	}
+}
+
+// HashAPIKey devuelve el hash con el que se indexan las API keys
+func HashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+type KeyStore interface {
+	Lookup(apiKey string) (*APIKeyRecord, error)
+}
+
+type staticKeyStore struct {
+	records map[string]*APIKeyRecord
+}
+
+// NewStaticKeyStore crea un almacén en memoria indexado por la API key en claro
+func NewStaticKeyStore(records map[string]*APIKeyRecord) KeyStore {
+	return &staticKeyStore{records: records}
+}
+
+func (s *staticKeyStore) Lookup(apiKey string) (*APIKeyRecord, error) {
+	for key, rec := range s.records {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+			return rec, nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// ConfigKeyStore guarda la API key de la configuración, con acceso completo.
+// La key puede rotarse en caliente con SetKey.
+type ConfigKeyStore struct {
+	mu    sync.RWMutex
+	store KeyStore
+}
+
+func NewConfigKeyStore(apiKey string) *ConfigKeyStore {
+	s := &ConfigKeyStore{}
+	s.SetKey(apiKey)
+	return s
+}
+
+// SetKey sustituye la API key; la anterior deja de aceptarse de inmediato
+func (s *ConfigKeyStore) SetKey(apiKey string) {
+	store := NewStaticKeyStore(map[string]*APIKeyRecord{
+		apiKey: {Name: "config", Scopes: []string{ScopeAdmin}},
+	})
+	s.mu.Lock()
+	s.store = store
+	s.mu.Unlock()
+}
+
+func (s *ConfigKeyStore) Lookup(apiKey string) (*APIKeyRecord, error) {
+	s.mu.RLock()
+	store := s.store
+	s.mu.RUnlock()
+	return store.Lookup(apiKey)
+}
+
+type chainKeyStore struct {
+	stores []KeyStore
+}
+
+// NewChainKeyStore consulta los almacenes en orden y devuelve el primer registro encontrado
+func NewChainKeyStore(stores ...KeyStore) KeyStore {
+	return &chainKeyStore{stores: stores}
+}
+
+func (c *chainKeyStore) Lookup(apiKey string) (*APIKeyRecord, error) {
+	for _, s := range c.stores {
+		rec, err := s.Lookup(apiKey)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		return rec, err
+	}
+	return nil, ErrKeyNotFound
+}
+
+// Límites de la consulta de API keys en Mongo
+const (
+	// keyLookupTimeout acota la espera de Mongo: sin él un Mongo colgado bloquearía
+	// el middleware de autenticación
+	keyLookupTimeout = 2 * time.Second
+	// maxNegativeKeys acota las claves inexistentes que se recuerdan; cada key
+	// inventada añade una, así que sin límite la caché crecería con cada intento
+	maxNegativeKeys = 10000
+)
+
+type cachedRecord struct {
+	hash    string
+	rec     *APIKeyRecord
+	expires time.Time
+	// miss es la posición en keyCache.misses de las entradas negativas
+	miss *list.Element
+}
+
+// keyCache guarda los resultados de consultar API keys durante ttl, también los
+// negativos. Las claves existentes están acotadas por la colección; las
+// negativas, por maxMisses, expulsando la usada hace más tiempo.
+type keyCache struct {
+	ttl       time.Duration
+	maxMisses int
+	mu        sync.Mutex
+	entries   map[string]*cachedRecord
+	// misses tiene al frente la entrada negativa usada más recientemente
+	misses *list.List
+}
+
+func newKeyCache(ttl time.Duration, maxMisses int) *keyCache {
+	return &keyCache{
+		ttl:       ttl,
+		maxMisses: maxMisses,
+		entries:   make(map[string]*cachedRecord),
+		misses:    list.New(),
+	}
+}
+
+// get devuelve la entrada de hash si no ha caducado
+func (c *keyCache) get(hash string, now time.Time) (*cachedRecord, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[hash]
+	if !ok || !now.Before(e.expires) {
+		return nil, false
+	}
+	if e.miss != nil {
+		c.misses.MoveToFront(e.miss)
+	}
+	return e, true
+}
+
+// put guarda el resultado de una consulta; rec nil es una key inexistente
+func (c *keyCache) put(hash string, rec *APIKeyRecord, now time.Time) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(hash)
+	e := &cachedRecord{hash: hash, rec: rec, expires: now.Add(c.ttl)}
+	if rec == nil {
+		if c.misses.Len() >= c.maxMisses {
+			c.remove(c.misses.Back().Value.(string))
+		}
+		e.miss = c.misses.PushFront(hash)
+	}
+	c.entries[hash] = e
+}
+
+// remove quita una entrada; requiere c.mu
+func (c *keyCache) remove(hash string) {
+	e, ok := c.entries[hash]
+	if !ok {
+		return
+	}
+	if e.miss != nil {
+		c.misses.Remove(e.miss)
+	}
+	delete(c.entries, hash)
+}
+
+// sweep descarta las entradas caducadas
+func (c *keyCache) sweep(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for hash, e := range c.entries {
+		if !now.Before(e.expires) {
+			c.remove(hash)
+		}
+	}
+}
+
+func (c *keyCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// run barre las entradas caducadas cada ttl hasta que se cancele ctx, en lugar
+// de recorrer toda la caché en cada consulta
+func (c *keyCache) run(ctx context.Context) {
+	ticker := time.NewTicker(max(c.ttl, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.sweep(now)
+		}
+	}
+}
+
+type mongoKeyStore struct {
+	col   *mongo.Collection
+	cache *keyCache
+}
+
+// NewMongoKeyStore lee las API keys de la colección "api_keys", cacheando cada
+// consulta (también las negativas) durante ttl. La limpieza de la caché para al
+// cancelar ctx.
+func NewMongoKeyStore(ctx context.Context, db *mongo.Database, ttl time.Duration) KeyStore {
+	s := &mongoKeyStore{
+		col:   db.Collection("api_keys"),
+		cache: newKeyCache(ttl, maxNegativeKeys),
+	}
+	if ttl > 0 {
+		go s.cache.run(ctx)
+	}
+	return s
+}
+
+func (s *mongoKeyStore) Lookup(apiKey string) (*APIKeyRecord, error) {
+	hash := HashAPIKey(apiKey)
+	now := time.Now()
+	if c, ok := s.cache.get(hash, now); ok {
+		if c.rec == nil {
+			return nil, ErrKeyNotFound
+		}
+		return c.rec, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), keyLookupTimeout)
+	defer cancel()
+	var rec APIKeyRecord
+	err := s.col.FindOne(ctx, bson.M{"key_hash": hash}).Decode(&rec)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		authLog.Error("Error consultando API key", "error", err)
+		return nil, err
+	}
+	var found *APIKeyRecord
+	if err == nil {
+		found = &rec
+	}
+	s.cache.put(hash, found, now)
+	if found == nil {
+		return nil, ErrKeyNotFound
+	}
+	return found, nil
+}
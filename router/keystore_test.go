@@ -0,0 +1,75 @@
+package router
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestKeyCacheBoundsMisses(t *testing.T) {
+	c := newKeyCache(time.Minute, 100)
+	now := time.Now()
+	c.put("real", &APIKeyRecord{Name: "team"}, now)
+	for i := 0; i < 10000; i++ {
+		c.put("bogus-"+strconv.Itoa(i), nil, now)
+	}
+	if n := c.len(); n != 101 {
+		t.Errorf("len() = %d, se esperaban 100 negativas más la existente", n)
+	}
+	if e, ok := c.get("real", now); !ok || e.rec == nil {
+		t.Error("las claves negativas expulsaron una existente")
+	}
+	if _, ok := c.get("bogus-0", now); ok {
+		t.Error("la negativa más antigua debería haberse expulsado")
+	}
+	if _, ok := c.get("bogus-9999", now); !ok {
+		t.Error("la negativa más reciente debería seguir en caché")
+	}
+}
+
+func TestKeyCacheEvictsLeastRecentMiss(t *testing.T) {
+	c := newKeyCache(time.Minute, 2)
+	now := time.Now()
+	c.put("a", nil, now)
+	c.put("b", nil, now)
+	c.get("a", now)
+	c.put("c", nil, now)
+	if _, ok := c.get("a", now); !ok {
+		t.Error("a se usó hace poco y no debería expulsarse")
+	}
+	if _, ok := c.get("b", now); ok {
+		t.Error("b era la negativa usada hace más tiempo")
+	}
+	// Una key que se crea después sustituye a su entrada negativa
+	c.put("a", &APIKeyRecord{Name: "nueva"}, now)
+	if e, ok := c.get("a", now); !ok || e.rec == nil || e.miss != nil {
+		t.Errorf("la entrada de a = %+v, se esperaba positiva", e)
+	}
+	if c.misses.Len() != 1 {
+		t.Errorf("quedan %d negativas, se esperaba 1", c.misses.Len())
+	}
+}
+
+func TestKeyCacheExpiry(t *testing.T) {
+	c := newKeyCache(time.Minute, 10)
+	now := time.Now()
+	c.put("real", &APIKeyRecord{Name: "team"}, now)
+	c.put("bogus", nil, now)
+	if _, ok := c.get("real", now.Add(time.Minute)); ok {
+		t.Error("la entrada debería caducar a los ttl")
+	}
+	c.sweep(now.Add(30 * time.Second))
+	if n := c.len(); n != 2 {
+		t.Errorf("el barrido descartó entradas vigentes: quedan %d", n)
+	}
+	c.sweep(now.Add(time.Minute))
+	if n, misses := c.len(), c.misses.Len(); n != 0 || misses != 0 {
+		t.Errorf("tras el barrido quedan %d entradas y %d negativas", n, misses)
+	}
+
+	off := newKeyCache(0, 10)
+	off.put("real", &APIKeyRecord{Name: "team"}, now)
+	if off.len() != 0 {
+		t.Error("con ttl 0 no debería cachearse nada")
+	}
+}
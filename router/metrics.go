@@ -0,0 +1,108 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"router-app/metrics"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("router_http_requests_total",
+		"Solicitudes HTTP atendidas por handler y código de estado.", "handler", "status")
+	httpDuration = metrics.NewHistogramVec("router_http_request_duration_seconds",
+		"Latencia de las solicitudes HTTP por handler.", metrics.DefBuckets, "handler")
+
+	routeLookups = metrics.NewCounterVec("router_route_lookups_total",
+		"Búsquedas de ruta por tipo y resultado (hit, miss, not_found).", "tipo", "result")
+	destinoSelections = metrics.NewCounterVec("router_destino_selections_total",
+		"Veces que se ha elegido cada destino.", "tipo", "destino")
+
+	cacheRoutes = metrics.NewGaugeVec("router_cache_routes",
+		"Rutas cargadas en memoria.")
+	cacheLastRefresh = metrics.NewGaugeVec("router_cache_last_refresh_timestamp_seconds",
+		"Instante del último refresco correcto de rutas.")
+	cacheRefreshDuration = metrics.NewGaugeVec("router_cache_last_refresh_duration_seconds",
+		"Duración del último refresco de rutas.")
+	cacheRefreshErrors = metrics.NewCounterVec("router_cache_refresh_errors_total",
+		"Refrescos de rutas fallidos.")
+
+	routesFileReconciled = metrics.NewGaugeVec("router_routes_file_last_reconcile_timestamp_seconds",
+		"Instante de la última reconciliación correcta con ROUTES_FILE.")
+	routesFileErrors = metrics.NewCounterVec("router_routes_file_errors_total",
+		"Reconciliaciones con ROUTES_FILE fallidas (fichero inválido o error de MongoDB).")
+
+	auditErrors = metrics.NewCounterVec("router_audit_errors_total",
+		"Cambios de rutas que no se pudieron registrar en la auditoría.")
+
+	mongoDuration = metrics.NewHistogramVec("router_mongo_operation_duration_seconds",
+		"Latencia de las operaciones contra MongoDB.", metrics.DefBuckets, "operation")
+	mongoErrors = metrics.NewCounterVec("router_mongo_errors_total",
+		"Operaciones contra MongoDB fallidas.", "operation")
+
+	rateLimitRejections = metrics.NewCounterVec("router_rate_limit_rejections_total",
+		"Solicitudes rechazadas por rate limiting, por política.", "policy")
+
+	circuitBreakerState = metrics.NewGaugeVec("router_circuit_breaker_open",
+		"Estado de cada circuit breaker (1 abierto, 0 cerrado).", "name")
+)
+
+// observeMongo registra latencia y error de una operación; ErrNoDocuments no cuenta como fallo
+func observeMongo(operation string, start time.Time, err error) {
+	mongoDuration.Observe(time.Since(start).Seconds(), operation)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		mongoErrors.Inc(operation)
+	}
+}
+
+// statusRecorder captura el código de estado y los bytes escritos por un handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Status devuelve el código enviado, 200 si el handler no escribió nada
+func (r *statusRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// countRejected cuenta en router_http_requests_total, bajo el nombre del
+// middleware, una solicitud rechazada antes de llegar a un handler instrumentado
+func countRejected(middleware string, status int) {
+	httpRequests.Inc(middleware, strconv.Itoa(status))
+}
+
+// InstrumentHandler cuenta solicitudes y mide su latencia bajo el nombre indicado
+func InstrumentHandler(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		httpDuration.Observe(time.Since(start).Seconds(), name)
+		httpRequests.Inc(name, strconv.Itoa(rec.Status()))
+	})
+}
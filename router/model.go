@@ -1,7 +1,106 @@
 package router
 
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrRouteNotFound indica que no existe ninguna ruta para la key y el tipo pedidos
+var ErrRouteNotFound = errors.New("ruta no encontrada")
+
+// ErrInvalidRoute envuelve los errores de validación de una operación sobre una ruta
+var ErrInvalidRoute = errors.New("ruta inválida")
+
+// ErrVersionNotFound indica que la ruta no tiene la revisión pedida en su historial
+var ErrVersionNotFound = errors.New("versión no encontrada")
+
+// ErrRevisionMismatch indica que la ruta cambió desde la revisión que indicó el
+// cliente en If-Match
+var ErrRevisionMismatch = errors.New("la ruta ha cambiado desde la revisión indicada")
+
+// MaxDestinoWeight acota el peso de un destino en el balanceo
+const MaxDestinoWeight = 100
+
 type Route struct {
-	Key      string   `bson:"key"`
-	Tipo     string   `bson:"tipo"`
-	Destinos []string `bson:"destinos"`
+	Key      string   `bson:"key" json:"key" yaml:"key"`
+	Tipo     string   `bson:"tipo" json:"tipo" yaml:"tipo"`
+	Destinos []string `bson:"destinos" json:"destinos" yaml:"destinos"`
+	// Weights guarda los pesos distintos de 1; como las URLs llevan puntos no
+	// pueden ser claves de un documento, de ahí la lista
+	Weights []DestinoWeight `bson:"weights,omitempty" json:"weights,omitempty" yaml:"weights,omitempty"`
+	// Drained son destinos que siguen en la ruta pero no reciben tráfico nuevo
+	Drained []string `bson:"drained,omitempty" json:"drained,omitempty" yaml:"drained,omitempty"`
+	// ManagedBy marca las rutas creadas por un proceso (p. ej. ManagedByRoutesFile);
+	// vacío en las creadas a mano
+	ManagedBy string `bson:"managed_by,omitempty" json:"managed_by,omitempty" yaml:"managed_by,omitempty"`
+	// Revision cuenta las escrituras de la ruta; cada una deja una RouteVersion.
+	// No se importa: la asigna el repositorio.
+	Revision int64 `bson:"revision,omitempty" json:"revision,omitempty" yaml:"revision,omitempty"`
+}
+
+// RouteVersion es el estado de una ruta tras una escritura. La versión que deja
+// un borrado tiene Deleted y ningún destino.
+type RouteVersion struct {
+	Route     `bson:",inline"`
+	Deleted   bool      `bson:"deleted,omitempty" json:"deleted,omitempty"`
+	Timestamp time.Time `bson:"timestamp" json:"timestamp"`
+	Author    string    `bson:"author,omitempty" json:"author,omitempty"`
+	RequestID string    `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	Operation string    `bson:"operation" json:"operation"`
+}
+
+type expectedRevisionKey struct{}
+
+// WithExpectedRevision hace que las escrituras del repositorio sobre una ruta
+// solo se apliquen si su revisión sigue siendo alguna de revs (If-Match); si no,
+// fallan con ErrRevisionMismatch. Sin revisiones ninguna escritura se aplica.
+func WithExpectedRevision(ctx context.Context, revs ...int64) context.Context {
+	return context.WithValue(ctx, expectedRevisionKey{}, append([]int64{}, revs...))
+}
+
+// ExpectedRevision devuelve las revisiones fijadas con WithExpectedRevision
+func ExpectedRevision(ctx context.Context) ([]int64, bool) {
+	revs, ok := ctx.Value(expectedRevisionKey{}).([]int64)
+	return revs, ok
+}
+
+type DestinoWeight struct {
+	Destino string `bson:"destino" json:"destino" yaml:"destino"`
+	Weight  int    `bson:"weight" json:"weight" yaml:"weight"`
+}
+
+// Weight devuelve el peso de un destino, 1 si no tiene uno explícito
+func (r *Route) Weight(destino string) int {
+	for _, w := range r.Weights {
+		if w.Destino == destino {
+			return w.Weight
+		}
+	}
+	return 1
+}
+
+// IsDrained indica si el destino está drenado
+func (r *Route) IsDrained(destino string) bool {
+	return containsString(r.Drained, destino)
+}
+
+// Active devuelve la lista de balanceo: cada destino aparece tantas veces como su
+// peso, intercalados por rondas, sin los drenados ni los de peso 0
+func (r *Route) Active() []string {
+	maxWeight := 0
+	for _, d := range r.Destinos {
+		if w := r.Weight(d); w > maxWeight && !r.IsDrained(d) {
+			maxWeight = w
+		}
+	}
+	var out []string
+	for round := 0; round < maxWeight; round++ {
+		for _, d := range r.Destinos {
+			if !r.IsDrained(d) && r.Weight(d) > round {
+				out = append(out, d)
+			}
+		}
+	}
+	return out
 }
@@ -0,0 +1,433 @@
+package router
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LimiterBackend consume una solicitud de la clave indicada con el límite dado
+type LimiterBackend interface {
+	Allow(key string, limit RateLimit) Decision
+	// Retain libera el estado de los límites que no están en limits, p. ej. los
+	// de políticas que ya no existen tras una recarga
+	Retain(limits []RateLimit)
+}
+
+type memoryBackend struct {
+	ctx         context.Context
+	maxVisitors int
+	mu          sync.Mutex
+	limiters    map[string]*memoryLimiter
+}
+
+// memoryLimiter es la tabla de buckets de un límite y la cancelación de su limpieza
+type memoryLimiter struct {
+	rl     *rateLimiter
+	cancel context.CancelFunc
+}
+
+// NewMemoryLimiterBackend limita en el proceso con token buckets; cada réplica cuenta
+// por separado. Cada tabla de buckets guarda como mucho maxVisitors claves y deja de
+// limpiarse al cancelar ctx.
+func NewMemoryLimiterBackend(ctx context.Context, maxVisitors int) LimiterBackend {
+	return &memoryBackend{ctx: ctx, maxVisitors: maxVisitors, limiters: make(map[string]*memoryLimiter)}
+}
+
+// Allow usa una tabla de buckets por límite, para que los overrides no mezclen ritmos
+func (m *memoryBackend) Allow(key string, limit RateLimit) Decision {
+	id := limit.String()
+	m.mu.Lock()
+	ml, ok := m.limiters[id]
+	if !ok {
+		ctx, cancel := context.WithCancel(m.ctx)
+		ml = &memoryLimiter{rl: NewRateLimiter(ctx, limit.Limit, limit.Window, limit.Burst, m.maxVisitors), cancel: cancel}
+		m.limiters[id] = ml
+	}
+	m.mu.Unlock()
+	return ml.rl.Allow(key)
+}
+
+// Retain descarta las tablas de los demás límites y para su limpieza
+func (m *memoryBackend) Retain(limits []RateLimit) {
+	keep := make(map[string]bool, len(limits))
+	for _, l := range limits {
+		keep[l.String()] = true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, ml := range m.limiters {
+		if !keep[id] {
+			ml.cancel()
+			delete(m.limiters, id)
+		}
+	}
+}
+
+// Len devuelve el número de tablas de buckets, una por límite en uso
+func (m *memoryBackend) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.limiters)
+}
+
+// mongoLimiterTimeout acota lo que una solicitud puede esperar al almacén compartido
+const mongoLimiterTimeout = 250 * time.Millisecond
+
+// gcra decide una solicitud con el algoritmo GCRA, equivalente al token bucket
+// del limitador en memoria pero con un único valor por clave: el instante
+// teórico de llegada (tat) de la próxima solicitud si el cliente fuera al ritmo
+// exacto. Cada solicitud admitida lo adelanta un intervalo de emisión y se
+// rechaza la que lo dejaría más allá de la ráfaga. Devuelve el nuevo tat.
+func gcra(tat, now time.Time, limit RateLimit) (time.Time, Decision) {
+	emission, tolerance, burst := gcraParams(limit)
+	if tat.Before(now) {
+		tat = now
+	}
+	d := Decision{Limit: burst}
+	if next := tat.Add(emission); next.Sub(now) <= tolerance {
+		tat = next
+		d.Allowed = true
+	} else {
+		d.RetryAfter = next.Sub(now) - tolerance
+	}
+	// Con el backend híbrido el tat global puede pasarse de la ráfaga
+	d.Remaining = max(int((tolerance-tat.Sub(now))/emission), 0)
+	d.Reset = tat.Sub(now)
+	return tat, d
+}
+
+// gcraParams devuelve el intervalo de emisión, la tolerancia y la ráfaga del
+// límite; sin ráfaga explícita es Limit, como en NewRateLimiter
+func gcraParams(limit RateLimit) (emission, tolerance time.Duration, burst int) {
+	burst = limit.Burst
+	if burst <= 0 {
+		burst = limit.Limit
+	}
+	emission = max(limit.Window/time.Duration(limit.Limit), 1)
+	return emission, emission * time.Duration(burst), burst
+}
+
+// tatStore guarda el tat de cada clave y límite en la colección "rate_limits",
+// en nanosegundos. Cada documento expira con un índice TTL cuando su tat queda
+// en el pasado, momento en que equivale a una clave nueva.
+type tatStore struct {
+	col *mongo.Collection
+}
+
+func newTATStore(db *mongo.Database) *tatStore {
+	col := db.Collection("rate_limits")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expire_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		rateLimitLog.Warn("No se pudo crear el índice TTL de rate_limits", "error", err)
+	}
+	return &tatStore{col: col}
+}
+
+// tatID identifica el estado de una clave con un límite, para que los overrides
+// no compartan tat con el límite de la política
+func tatID(key string, limit RateLimit) string {
+	return key + "|" + limit.String()
+}
+
+// advanceTAT es la actualización que mueve el tat almacenado a max(tat, now) más
+// n intervalos de emisión. Si tolerance > 0 solo avanza cuando el resultado queda
+// dentro de la ráfaga, igual que gcra; con tolerance 0 avanza siempre.
+func advanceTAT(now time.Time, n int64, emission, tolerance time.Duration) mongo.Pipeline {
+	nowNs := now.UnixNano()
+	step := n * int64(emission)
+	base := bson.M{"$max": bson.A{bson.M{"$ifNull": bson.A{"$tat", nowNs}}, nowNs}}
+	var tat any = bson.M{"$add": bson.A{"$$base", step}}
+	if tolerance > 0 {
+		tat = bson.M{"$cond": bson.A{
+			bson.M{"$lte": bson.A{bson.M{"$subtract": bson.A{tat, nowNs}}, int64(tolerance)}},
+			tat,
+			"$$base",
+		}}
+	}
+	return mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"tat": bson.M{"$let": bson.M{"vars": bson.M{"base": base}, "in": tat}}}}},
+		// $toDate interpreta un entero como milisegundos
+		{{Key: "$set", Value: bson.M{"expire_at": bson.M{"$toDate": bson.M{"$toLong": bson.M{"$ceil": bson.M{"$divide": bson.A{"$tat", int64(time.Millisecond)}}}}}}}},
+	}
+}
+
+// allow aplica gcra de forma atómica en el almacén y devuelve la decisión. El
+// almacén hace el mismo cálculo que gcra, así que la decisión se reconstruye
+// con el tat anterior a la actualización.
+func (s *tatStore) allow(key string, limit RateLimit, now time.Time) (Decision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoLimiterTimeout)
+	defer cancel()
+	emission, tolerance, _ := gcraParams(limit)
+	var doc struct {
+		TAT int64 `bson:"tat"`
+	}
+	err := s.col.FindOneAndUpdate(ctx,
+		bson.M{"_id": tatID(key, limit)},
+		advanceTAT(now, 1, emission, tolerance),
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before),
+	).Decode(&doc)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return Decision{}, err
+	}
+	var prev time.Time
+	if doc.TAT > 0 {
+		prev = time.Unix(0, doc.TAT)
+	}
+	_, d := gcra(prev, now, limit)
+	return d, nil
+}
+
+// tats devuelve el tat de cada id que exista en el almacén
+func (s *tatStore) tats(ctx context.Context, ids bson.A) (map[string]time.Time, error) {
+	cursor, err := s.col.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.M{"tat": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []struct {
+		ID  string `bson:"_id"`
+		TAT int64  `bson:"tat"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	tats := make(map[string]time.Time, len(docs))
+	for _, d := range docs {
+		tats[d.ID] = time.Unix(0, d.TAT)
+	}
+	return tats, nil
+}
+
+type mongoBackend struct {
+	store    *tatStore
+	fallback LimiterBackend
+	cb       *CircuitBreaker
+}
+
+// NewMongoLimiterBackend comparte entre réplicas el estado GCRA de cada clave, que
+// se actualiza de forma atómica y respeta la ráfaga igual que el backend en memoria.
+// Si Mongo falla, el circuit breaker abre y se limita localmente con fallback hasta
+// que se recupere.
+func NewMongoLimiterBackend(db *mongo.Database, fallback LimiterBackend, cb *CircuitBreaker) LimiterBackend {
+	return &mongoBackend{store: newTATStore(db), fallback: fallback, cb: cb}
+}
+
+// Retain libera el estado del limitador local de respaldo
+func (m *mongoBackend) Retain(limits []RateLimit) {
+	m.fallback.Retain(limits)
+}
+
+func (m *mongoBackend) Allow(key string, limit RateLimit) Decision {
+	if !m.cb.Allow() {
+		return m.fallback.Allow(key, limit)
+	}
+	d, err := m.store.allow(key, limit, time.Now())
+	if err != nil {
+		m.cb.Failure()
+		rateLimitLog.Warn("Error en el almacén compartido, se limita localmente", "error", err)
+		return m.fallback.Allow(key, limit)
+	}
+	m.cb.Success()
+	return d
+}
+
+// hybridCounter es el estado local de una clave con un límite
+type hybridCounter struct {
+	id    string
+	limit RateLimit
+	// tat es el del almacén tras la última sincronización más las solicitudes
+	// admitidas localmente desde entonces
+	tat     time.Time
+	pending int64 // solicitudes locales aún no enviadas
+}
+
+type hybridBackend struct {
+	store    *tatStore
+	fallback LimiterBackend
+	cb       *CircuitBreaker
+	mu       sync.Mutex
+	counters map[string]*list.Element
+	// lru tiene al frente el contador usado más recientemente
+	lru         *list.List
+	maxCounters int
+	now         func() time.Time
+}
+
+// NewHybridLimiterBackend decide con el estado GCRA local y lo sincroniza con Mongo
+// cada interval, de modo que cada solicitud no paga una ida y vuelta a la base de datos.
+// El límite global puede excederse como mucho en lo acumulado durante un intervalo.
+// Guarda como mucho maxCounters contadores, expulsando los menos recientes, y la
+// sincronización para al cancelar ctx.
+func NewHybridLimiterBackend(ctx context.Context, db *mongo.Database, fallback LimiterBackend, cb *CircuitBreaker, interval time.Duration, maxCounters int) LimiterBackend {
+	h := &hybridBackend{
+		store:       newTATStore(db),
+		fallback:    fallback,
+		cb:          cb,
+		counters:    make(map[string]*list.Element),
+		lru:         list.New(),
+		maxCounters: maxCounters,
+		now:         time.Now,
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				h.sync()
+			}
+		}
+	}()
+	return h
+}
+
+func (h *hybridBackend) Allow(key string, limit RateLimit) Decision {
+	if !h.cb.Allow() {
+		return h.fallback.Allow(key, limit)
+	}
+	id := tatID(key, limit)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var c *hybridCounter
+	if e, ok := h.counters[id]; ok {
+		c = e.Value.(*hybridCounter)
+		h.lru.MoveToFront(e)
+	} else {
+		// Como en rateLimiter, un flood de IPs falsas expulsa los contadores menos
+		// recientes en vez de hacer crecer la memoria; lo pendiente de esos se pierde
+		if h.lru.Len() >= h.maxCounters {
+			oldest := h.lru.Back()
+			h.lru.Remove(oldest)
+			delete(h.counters, oldest.Value.(*hybridCounter).id)
+		}
+		c = &hybridCounter{id: id, limit: limit}
+		h.counters[id] = h.lru.PushFront(c)
+	}
+	tat, d := gcra(c.tat, h.now(), limit)
+	if d.Allowed {
+		c.tat = tat
+		c.pending++
+	}
+	return d
+}
+
+// Retain libera el estado del limitador local de respaldo; los contadores propios
+// se descartan al sincronizar cuando su tat queda en el pasado
+func (h *hybridBackend) Retain(limits []RateLimit) {
+	h.fallback.Retain(limits)
+}
+
+// Len devuelve el número de contadores en memoria
+func (h *hybridBackend) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.counters)
+}
+
+// sync envía en una sola escritura masiva las solicitudes admitidas localmente y
+// después lee en una sola consulta el tat global de los contadores vivos
+func (h *hybridBackend) sync() {
+	now := h.now()
+	h.mu.Lock()
+	batch := make([]hybridCounter, 0, len(h.counters))
+	for id, e := range h.counters {
+		c := e.Value.(*hybridCounter)
+		// Un tat en el pasado equivale a una clave nueva
+		if !c.tat.After(now) && c.pending == 0 {
+			h.lru.Remove(e)
+			delete(h.counters, id)
+			continue
+		}
+		batch = append(batch, *c)
+	}
+	h.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mongoLimiterTimeout)
+	defer cancel()
+	var models []mongo.WriteModel
+	var sent []int // posición en batch de cada operación
+	ids := make(bson.A, 0, len(batch))
+	for i, snap := range batch {
+		ids = append(ids, snap.id)
+		if snap.pending == 0 {
+			continue
+		}
+		// Lo enviado ya se admitió localmente, así que avanza sin condición
+		emission, _, _ := gcraParams(snap.limit)
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": snap.id}).
+			SetUpdate(advanceTAT(now, snap.pending, emission, 0)).
+			SetUpsert(true))
+		sent = append(sent, i)
+	}
+	applied := make([]bool, len(batch))
+	if len(models) > 0 {
+		_, err := h.store.col.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		var bulkErr mongo.BulkWriteException
+		if err != nil && !errors.As(err, &bulkErr) {
+			h.cb.Failure()
+			rateLimitLog.Warn("Error sincronizando contadores, se limita localmente", "error", err)
+			return
+		}
+		failed := make(map[int]bool, len(bulkErr.WriteErrors))
+		for _, we := range bulkErr.WriteErrors {
+			failed[we.Index] = true
+		}
+		for op, i := range sent {
+			applied[i] = !failed[op]
+		}
+		if len(failed) > 0 {
+			rateLimitLog.Warn("Contadores sin sincronizar, se reintentan en el próximo intervalo", "fallidos", len(failed))
+		}
+	}
+
+	tats, err := h.store.tats(ctx, ids)
+	if err != nil {
+		h.cb.Failure()
+		rateLimitLog.Warn("Error leyendo contadores globales, se limita localmente", "error", err)
+	} else {
+		h.cb.Success()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, snap := range batch {
+		e, ok := h.counters[snap.id]
+		if !ok {
+			continue
+		}
+		c := e.Value.(*hybridCounter)
+		if applied[i] {
+			c.pending -= snap.pending
+		}
+		// El tat del almacén no incluye lo admitido desde la instantánea, que sigue
+		// en pending; si no se pudo leer, el local ya cuenta lo enviado
+		if tat, ok := tats[snap.id]; ok {
+			emission, _, _ := gcraParams(c.limit)
+			c.tat = mergeTAT(tat, now, c.pending, emission)
+		}
+	}
+}
+
+// mergeTAT suma al tat global las solicitudes locales aún no enviadas
+func mergeTAT(global, now time.Time, pending int64, emission time.Duration) time.Time {
+	if global.Before(now) {
+		global = now
+	}
+	return global.Add(time.Duration(pending) * emission)
+}
@@ -0,0 +1,166 @@
+package router
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestHybridBackend crea el backend híbrido sin almacén: Allow solo usa los
+// contadores locales mientras el circuit breaker esté cerrado
+func newTestHybridBackend(maxCounters int) *hybridBackend {
+	return &hybridBackend{
+		cb:          NewCircuitBreaker("test-hybrid", 5, time.Second),
+		counters:    make(map[string]*list.Element),
+		lru:         list.New(),
+		maxCounters: maxCounters,
+		now:         time.Now,
+	}
+}
+
+// TestGCRAMatchesTokenBucket comprueba que el algoritmo de los backends
+// compartidos decide igual que el token bucket en memoria, ráfaga incluida
+func TestGCRAMatchesTokenBucket(t *testing.T) {
+	limits := []RateLimit{
+		{Limit: 10, Window: time.Second},
+		{Limit: 10, Window: time.Second, Burst: 3},
+		{Limit: 60, Window: time.Minute, Burst: 20},
+		{Limit: 1, Window: time.Hour},
+	}
+	// Ráfagas seguidas de pausas de distintas longitudes
+	steps := []time.Duration{0, 0, 0, 0, 0, 50 * time.Millisecond, 100 * time.Millisecond, 0, 0,
+		time.Second, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 250 * time.Millisecond, 3 * time.Second, 0, 0, time.Hour, 0}
+	for _, limit := range limits {
+		t.Run(limit.String(), func(t *testing.T) {
+			rl, clock := newTestRateLimiter(t, limit.Limit, limit.Window, limit.Burst, 10)
+			var tat time.Time
+			for i, step := range steps {
+				clock.advance(step)
+				want := rl.Allow("k")
+				var got Decision
+				tat, got = gcra(tat, clock.now(), limit)
+				if got.Allowed != want.Allowed || got.Remaining != want.Remaining || got.Limit != want.Limit {
+					t.Fatalf("solicitud %d: gcra = %+v, token bucket = %+v", i, got, want)
+				}
+				if diff := got.Reset - want.Reset; diff > time.Microsecond || diff < -time.Microsecond {
+					t.Errorf("solicitud %d: Reset = %s, token bucket = %s", i, got.Reset, want.Reset)
+				}
+				if diff := got.RetryAfter - want.RetryAfter; diff > time.Microsecond || diff < -time.Microsecond {
+					t.Errorf("solicitud %d: RetryAfter = %s, token bucket = %s", i, got.RetryAfter, want.RetryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestHybridBackendHonorsBurst(t *testing.T) {
+	h := newTestHybridBackend(10)
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 59, 0, time.UTC)}
+	h.now = clock.now
+	// 10 por minuto con ráfaga 3: una solicitud cada 6s tras agotar la ráfaga
+	limit := RateLimit{Limit: 10, Window: time.Minute, Burst: 3}
+	for i := 0; i < 3; i++ {
+		if d := h.Allow("k", limit); !d.Allowed || d.Remaining != 2-i {
+			t.Fatalf("solicitud %d = %+v, se esperaba admitida con %d restantes", i, d, 2-i)
+		}
+	}
+	d := h.Allow("k", limit)
+	if d.Allowed || d.RetryAfter != 6*time.Second {
+		t.Fatalf("cuarta solicitud = %+v, se esperaba rechazada con RetryAfter 6s", d)
+	}
+	// El cambio de minuto no reinicia nada, a diferencia de una ventana fija
+	clock.advance(time.Second)
+	if h.Allow("k", limit).Allowed {
+		t.Error("el cambio de ventana no debería devolver la ráfaga")
+	}
+	clock.advance(5 * time.Second)
+	if !h.Allow("k", limit).Allowed {
+		t.Error("tras un intervalo de emisión debería admitirse una solicitud")
+	}
+	if h.Allow("k", limit).Allowed {
+		t.Error("solo debería haberse recuperado una solicitud")
+	}
+	// El mismo key con otro límite (un override) tiene su propio estado
+	if !h.Allow("k", RateLimit{Limit: 5, Window: time.Second}).Allowed {
+		t.Error("un límite distinto no debería compartir estado")
+	}
+}
+
+func TestMergeTAT(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got := mergeTAT(now.Add(-time.Minute), now, 2, time.Second); !got.Equal(now.Add(2 * time.Second)) {
+		t.Errorf("un tat global pasado cuenta desde ahora: %s", got.Sub(now))
+	}
+	if got := mergeTAT(now.Add(time.Minute), now, 2, time.Second); !got.Equal(now.Add(time.Minute + 2*time.Second)) {
+		t.Errorf("las pendientes se suman al tat global: %s", got.Sub(now))
+	}
+}
+
+func TestHybridBackendBoundsCounters(t *testing.T) {
+	h := newTestHybridBackend(100)
+	limit := RateLimit{Limit: 10, Window: time.Minute}
+	for i := 0; i < 10000; i++ {
+		h.Allow("ip|10.0."+strconv.Itoa(i/256)+"."+strconv.Itoa(i%256), limit)
+		if n := h.Len(); n > 100 {
+			t.Fatalf("tras %d claves hay %d contadores, máximo 100", i+1, n)
+		}
+	}
+	if n := h.Len(); n != 100 {
+		t.Errorf("Len() = %d, se esperaban 100", n)
+	}
+}
+
+func TestHybridBackendKeepsRecentCounters(t *testing.T) {
+	h := newTestHybridBackend(2)
+	limit := RateLimit{Limit: 1, Window: time.Hour}
+	if !h.Allow("a", limit).Allowed {
+		t.Fatal("la primera solicitud de a debería permitirse")
+	}
+	h.Allow("b", limit)
+	// a se usa otra vez, así que la expulsada al llegar c es b
+	if h.Allow("a", limit).Allowed {
+		t.Fatal("la segunda solicitud de a debería rechazarse")
+	}
+	h.Allow("c", limit)
+	if h.Allow("a", limit).Allowed {
+		t.Error("a fue expulsada aunque era reciente")
+	}
+	if !h.Allow("b", limit).Allowed {
+		t.Error("b debería haberse expulsado y empezar de cero")
+	}
+}
+
+func TestMemoryBackendRetainEvictsUnusedLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewMemoryLimiterBackend(ctx, 100).(*memoryBackend)
+	old := RateLimit{Limit: 10, Window: time.Second}
+	kept := RateLimit{Limit: 100, Window: time.Minute}
+	override := RateLimit{Limit: 5, Window: time.Second, Burst: 1}
+	for _, l := range []RateLimit{old, kept, override} {
+		m.Allow("ip|10.0.0.1", l)
+	}
+	if n := m.Len(); n != 3 {
+		t.Fatalf("Len() = %d, se esperaban 3 tablas", n)
+	}
+
+	// Tras recargar solo queda la política con el límite kept
+	pl := NewPolicyLimiter(nil, m)
+	pl.SetPolicies([]RateLimitPolicy{{Name: "ip", Dimensions: []string{DimensionIP}, RateLimit: kept}})
+	if n := m.Len(); n != 1 {
+		t.Fatalf("Len() = %d tras SetPolicies, se esperaba 1", n)
+	}
+	if _, ok := m.limiters[kept.String()]; !ok {
+		t.Error("se descartó la tabla de un límite en uso")
+	}
+
+	// Un override descartado se vuelve a crear con su primer uso
+	if !m.Allow("ip|10.0.0.1", override).Allowed {
+		t.Error("el override recreado debería empezar con el bucket lleno")
+	}
+	if n := m.Len(); n != 2 {
+		t.Errorf("Len() = %d, se esperaban 2 tablas", n)
+	}
+}
@@ -0,0 +1,308 @@
+package router
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"router-app/config"
+)
+
+// Dimensiones por las que puede agruparse una política de rate limiting
+const (
+	DimensionIP     = "ip"
+	DimensionAPIKey = "apikey"
+	DimensionTipo   = "tipo"
+	DimensionKey    = "key"
+)
+
+// RateLimit es un límite de solicitudes por ventana con su ráfaga máxima
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+	Burst  int
+}
+
+// ParseRateLimit interpreta "limit/window[:burst]", p. ej. "100/1s" o "1000/1m:1500"
+func ParseRateLimit(spec string) (RateLimit, error) {
+	var rl RateLimit
+	rate, burst, hasBurst := strings.Cut(strings.TrimSpace(spec), ":")
+	limit, window, ok := strings.Cut(rate, "/")
+	if !ok {
+		return rl, fmt.Errorf("límite inválido '%s', se espera limit/window", spec)
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil || n <= 0 {
+		return rl, fmt.Errorf("límite inválido '%s'", spec)
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return rl, fmt.Errorf("ventana inválida '%s'", spec)
+	}
+	rl.Limit, rl.Window = n, d
+	if hasBurst {
+		b, err := strconv.Atoi(burst)
+		if err != nil || b <= 0 {
+			return rl, fmt.Errorf("ráfaga inválida '%s'", spec)
+		}
+		rl.Burst = b
+	}
+	return rl, nil
+}
+
+func (l RateLimit) String() string {
+	s := fmt.Sprintf("%d/%s", l.Limit, l.Window)
+	if l.Burst > 0 {
+		s += ":" + strconv.Itoa(l.Burst)
+	}
+	return s
+}
+
+// RateLimitPolicy aplica un límite a cada combinación distinta de sus dimensiones
+type RateLimitPolicy struct {
+	Name       string
+	Dimensions []string
+	RateLimit
+	// PreAuth indica que la política se evalúa antes de autenticar, así que
+	// cuenta también las credenciales inválidas y no admite overrides por API key
+	PreAuth bool
+}
+
+// PreAuthPolicy es el nombre de la política por IP que se evalúa antes de autenticar
+const PreAuthPolicy = "preauth"
+
+// ParseRateLimitPolicies interpreta una lista separada por ';' de políticas con
+// formato "nombre=dim+dim:limit/window[:burst]", p. ej.
+// "por-key=apikey:1000/1m;por-tipo=tipo:100/1s"
+func ParseRateLimitPolicies(spec string) ([]RateLimitPolicy, error) {
+	var policies []RateLimitPolicy
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, rest, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("política inválida '%s', se espera nombre=dimensiones:límite", entry)
+		}
+		dims, limit, ok := strings.Cut(rest, ":")
+		if !ok {
+			return nil, fmt.Errorf("política '%s' sin límite", name)
+		}
+		p := RateLimitPolicy{Name: name, Dimensions: strings.Split(dims, "+")}
+		for _, d := range p.Dimensions {
+			switch d {
+			case DimensionIP, DimensionAPIKey, DimensionTipo, DimensionKey:
+			default:
+				return nil, fmt.Errorf("política '%s': dimensión desconocida '%s'", name, d)
+			}
+		}
+		rl, err := ParseRateLimit(limit)
+		if err != nil {
+			return nil, fmt.Errorf("política '%s': %w", name, err)
+		}
+		p.RateLimit = rl
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+// requestDimensions son los valores de la solicitud por los que se agrupan las políticas
+type requestDimensions struct {
+	ip        string
+	identity  *Identity
+	tipo, key string
+}
+
+func (d requestDimensions) value(dim string) (string, bool) {
+	switch dim {
+	case DimensionIP:
+		return d.ip, d.ip != ""
+	case DimensionAPIKey:
+		if d.identity == nil {
+			return "anonymous", true
+		}
+		return d.identity.Scheme + ":" + d.identity.Subject, true
+	case DimensionTipo:
+		return d.tipo, d.tipo != ""
+	case DimensionKey:
+		return d.key, d.key != ""
+	}
+	return "", false
+}
+
+// PoliciesFromConfig construye la política por IP previa a la autenticación, la
+// política por IP de la configuración y las adicionales de RateLimitPolicies. La
+// política "ip" solo se añade si su límite difiere del de la previa a la
+// autenticación: con el mismo límite contaría dos veces cada solicitud y sus
+// overrides por API key nunca podrían superar el límite previo.
+func PoliciesFromConfig(cfg *config.Config) ([]RateLimitPolicy, error) {
+	ipLimit := RateLimit{
+		Limit:  cfg.RateLimitRequests,
+		Window: cfg.RateLimitWindow,
+		Burst:  cfg.RateLimitBurst,
+	}
+	var policies []RateLimitPolicy
+	preAuthLimit := ipLimit
+	switch spec := strings.TrimSpace(cfg.RateLimitPreAuth); spec {
+	case "off":
+	case "":
+		policies = append(policies, RateLimitPolicy{Name: PreAuthPolicy, Dimensions: []string{DimensionIP}, RateLimit: ipLimit, PreAuth: true})
+	default:
+		rl, err := ParseRateLimit(spec)
+		if err != nil {
+			return nil, fmt.Errorf("RATE_LIMIT_PREAUTH: %w", err)
+		}
+		preAuthLimit = rl
+		policies = append(policies, RateLimitPolicy{Name: PreAuthPolicy, Dimensions: []string{DimensionIP}, RateLimit: rl, PreAuth: true})
+	}
+	if len(policies) == 0 || !sameRate(preAuthLimit, ipLimit) {
+		policies = append(policies, RateLimitPolicy{Name: "ip", Dimensions: []string{DimensionIP}, RateLimit: ipLimit})
+	}
+	extra, err := ParseRateLimitPolicies(cfg.RateLimitPolicies)
+	if err != nil {
+		return nil, err
+	}
+	return append(policies, extra...), nil
+}
+
+// sameRate indica si dos límites admiten lo mismo, teniendo en cuenta que sin
+// ráfaga explícita la ráfaga es Limit
+func sameRate(a, b RateLimit) bool {
+	burst := func(l RateLimit) int {
+		if l.Burst <= 0 {
+			return l.Limit
+		}
+		return l.Burst
+	}
+	return a.Limit == b.Limit && a.Window == b.Window && burst(a) == burst(b)
+}
+
+// PolicyLimiter evalúa todas las políticas configuradas sobre cada solicitud
+type PolicyLimiter struct {
+	mu       sync.RWMutex
+	policies []RateLimitPolicy
+	backend  LimiterBackend
+	// overrides son los límites por API key que se han aplicado, por política y
+	// límite, para que una recarga no libere su estado
+	overrides sync.Map
+}
+
+// overrideUse es un límite por API key aplicado a una política
+type overrideUse struct {
+	policy string
+	limit  RateLimit
+}
+
+func NewPolicyLimiter(policies []RateLimitPolicy, backend LimiterBackend) *PolicyLimiter {
+	return &PolicyLimiter{policies: policies, backend: backend}
+}
+
+// SetPolicies sustituye las políticas; las solicitudes en curso terminan con las
+// anteriores. El backend libera el estado de los límites que ya no se usan; los
+// overrides aplicados a políticas que siguen existiendo se conservan, para que
+// una recarga no devuelva la ráfaga a las claves que estaban limitadas.
+func (pl *PolicyLimiter) SetPolicies(policies []RateLimitPolicy) {
+	pl.mu.Lock()
+	pl.policies = policies
+	pl.mu.Unlock()
+	names := make(map[string]bool, len(policies))
+	limits := make([]RateLimit, 0, len(policies))
+	for _, p := range policies {
+		limits = append(limits, p.RateLimit)
+		if !p.PreAuth {
+			names[p.Name] = true
+		}
+	}
+	pl.overrides.Range(func(id, v any) bool {
+		if use := v.(overrideUse); names[use.policy] {
+			limits = append(limits, use.limit)
+		} else {
+			pl.overrides.Delete(id)
+		}
+		return true
+	})
+	pl.backend.Retain(limits)
+}
+
+// Allow consume un token de cada política aplicable de la fase indicada (antes o
+// después de autenticar) y devuelve la decisión más restrictiva. Las políticas
+// cuyas dimensiones no están en la solicitud se omiten. En cuanto una política
+// rechaza la solicitud no se evalúan las siguientes, para que una solicitud
+// rechazada no gaste tokens del resto.
+func (pl *PolicyLimiter) Allow(dims requestDimensions, preAuth bool) (Decision, string, bool) {
+	var result Decision
+	var limitedBy string
+	evaluated := false
+	pl.mu.RLock()
+	policies := pl.policies
+	pl.mu.RUnlock()
+	for _, p := range policies {
+		if p.PreAuth != preAuth {
+			continue
+		}
+		parts := make([]string, 0, len(p.Dimensions))
+		applies := true
+		for _, dim := range p.Dimensions {
+			v, ok := dims.value(dim)
+			if !ok {
+				applies = false
+				break
+			}
+			parts = append(parts, v)
+		}
+		if !applies {
+			continue
+		}
+		limit := p.RateLimit
+		if dims.identity != nil && !p.PreAuth {
+			if override, ok := dims.identity.RateLimits[p.Name]; ok {
+				limit = override
+				id := p.Name + "|" + override.String()
+				if _, seen := pl.overrides.Load(id); !seen {
+					pl.overrides.Store(id, overrideUse{policy: p.Name, limit: override})
+				}
+			}
+		}
+		d := pl.backend.Allow(p.Name+"|"+strings.Join(parts, "|"), limit)
+		if !evaluated || moreRestrictive(d, result) {
+			result = d
+			limitedBy = p.Name
+		}
+		evaluated = true
+		if !d.Allowed {
+			break
+		}
+	}
+	return result, limitedBy, evaluated
+}
+
+func moreRestrictive(a, b Decision) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	if !a.Allowed {
+		return a.RetryAfter > b.RetryAfter
+	}
+	return a.Remaining < b.Remaining
+}
+
+// parseRateLimitOverrides convierte los overrides de un registro de API key,
+// ignorando (con log) las entradas mal formadas
+func parseRateLimitOverrides(name string, specs map[string]string) map[string]RateLimit {
+	if len(specs) == 0 {
+		return nil
+	}
+	out := make(map[string]RateLimit, len(specs))
+	for policy, spec := range specs {
+		rl, err := ParseRateLimit(spec)
+		if err != nil {
+			rateLimitLog.Warn("Override de rate limit ignorado", "key_name", name, "policy", policy, "error", err)
+			continue
+		}
+		out[policy] = rl
+	}
+	return out
+}
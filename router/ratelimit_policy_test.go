@@ -0,0 +1,81 @@
+package router
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"router-app/config"
+)
+
+func TestPoliciesFromConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		preAuth string
+		burst   int
+		want    []string // nombre:límite de cada política
+	}{
+		{"previa igual a la de la configuración", "", 0, []string{"preauth:100/1m0s"}},
+		{"previa explícita con el mismo ritmo", "100/1m:100", 0, []string{"preauth:100/1m0s:100"}},
+		{"previa distinta", "20/1s", 0, []string{"preauth:20/1s", "ip:100/1m0s"}},
+		{"misma tasa con otra ráfaga", "100/1m", 150, []string{"preauth:100/1m0s", "ip:100/1m0s:150"}},
+		{"sin previa", "off", 0, []string{"ip:100/1m0s"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.RateLimitRequests, cfg.RateLimitWindow, cfg.RateLimitBurst = 100, time.Minute, tt.burst
+			cfg.RateLimitPreAuth = tt.preAuth
+			cfg.RateLimitPolicies = "por-key=apikey:1000/1m"
+			policies, err := PoliciesFromConfig(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, p := range policies {
+				got = append(got, p.Name+":"+p.RateLimit.String())
+			}
+			want := append(tt.want, "por-key:1000/1m0s")
+			if !slices.Equal(got, want) {
+				t.Errorf("políticas = %v, se esperaba %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSetPoliciesKeepsOverrideState(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewMemoryLimiterBackend(ctx, 100).(*memoryBackend)
+	policies := []RateLimitPolicy{
+		{Name: "por-key", Dimensions: []string{DimensionAPIKey}, RateLimit: RateLimit{Limit: 100, Window: time.Minute}},
+	}
+	pl := NewPolicyLimiter(policies, m)
+	id := &Identity{Scheme: "apikey", Subject: "batch", RateLimits: map[string]RateLimit{
+		"por-key": {Limit: 2, Window: time.Hour},
+	}}
+	dims := requestDimensions{ip: "10.0.0.1", identity: id}
+	for i := 0; i < 2; i++ {
+		if d, _, _ := pl.Allow(dims, false); !d.Allowed {
+			t.Fatalf("solicitud %d rechazada", i)
+		}
+	}
+	if d, _, _ := pl.Allow(dims, false); d.Allowed {
+		t.Fatal("el override debería haber agotado la ráfaga")
+	}
+
+	// Una recarga con las mismas políticas no devuelve la ráfaga a la clave
+	pl.SetPolicies(policies)
+	if d, policy, _ := pl.Allow(dims, false); d.Allowed {
+		t.Errorf("tras recargar la clave limitada por %s vuelve a tener tokens", policy)
+	}
+
+	// Si la política desaparece, su override se libera con ella
+	pl.SetPolicies([]RateLimitPolicy{
+		{Name: "por-tipo", Dimensions: []string{DimensionTipo}, RateLimit: RateLimit{Limit: 10, Window: time.Second}},
+	})
+	if n := m.Len(); n != 0 {
+		t.Errorf("Len() = %d, se esperaba que se liberaran todas las tablas", n)
+	}
+}
@@ -0,0 +1,279 @@
+package router
+
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock es un reloj que solo avanza cuando el test lo pide
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// newTestRateLimiter crea un limitador con reloj falso; su limpieza para al
+// terminar el test
+func newTestRateLimiter(t testing.TB, rate int, window time.Duration, burst, maxVisitors int) (*rateLimiter, *fakeClock) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 59, 0, time.UTC)}
+	rl := NewRateLimiter(ctx, rate, window, burst, maxVisitors)
+	rl.now = clock.now
+	return rl, clock
+}
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	type step struct {
+		advance  time.Duration // tiempo que pasa antes de las solicitudes
+		requests int
+		allowed  int // cuántas de ellas deben permitirse
+	}
+	tests := []struct {
+		name   string
+		rate   int
+		window time.Duration
+		burst  int
+		steps  []step
+	}{
+		{
+			name: "ráfaga inicial igual a rate sin burst",
+			rate: 10, window: time.Second,
+			steps: []step{{requests: 15, allowed: 10}},
+		},
+		{
+			name: "ráfaga inicial con burst explícito",
+			rate: 60, window: time.Minute, burst: 5,
+			steps: []step{{requests: 8, allowed: 5}},
+		},
+		{
+			name: "recarga fraccional",
+			rate: 10, window: time.Second,
+			steps: []step{
+				{requests: 10, allowed: 10},
+				{advance: 50 * time.Millisecond, requests: 1, allowed: 0},  // medio token
+				{advance: 50 * time.Millisecond, requests: 2, allowed: 1},  // completa uno
+				{advance: 250 * time.Millisecond, requests: 3, allowed: 2}, // 2,5 tokens
+				{advance: 50 * time.Millisecond, requests: 1, allowed: 1},  // 0,5 + 0,5
+			},
+		},
+		{
+			name: "la recarga no supera burst",
+			rate: 60, window: time.Minute, burst: 5,
+			steps: []step{
+				{requests: 5, allowed: 5},
+				{advance: time.Hour, requests: 10, allowed: 5},
+			},
+		},
+		{
+			name: "sin ráfaga doble al cruzar el límite de ventana",
+			rate: 100, window: time.Minute,
+			// El reloj empieza a las 00:00:59: un contador de ventana fija permitiría
+			// otras 100 al pasar a 00:01:00; el bucket solo recarga 100/60 por segundo
+			steps: []step{
+				{requests: 100, allowed: 100},
+				{advance: time.Second, requests: 100, allowed: 1},
+				{advance: 59 * time.Second, requests: 200, allowed: 99},
+			},
+		},
+		{
+			name: "un cliente constante al ritmo permitido nunca se limita",
+			rate: 2, window: time.Second, burst: 1,
+			steps: []step{
+				{requests: 1, allowed: 1},
+				{advance: 500 * time.Millisecond, requests: 1, allowed: 1},
+				{advance: 500 * time.Millisecond, requests: 1, allowed: 1},
+				{advance: 500 * time.Millisecond, requests: 1, allowed: 1},
+				{advance: 100 * time.Millisecond, requests: 1, allowed: 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, clock := newTestRateLimiter(t, tt.rate, tt.window, tt.burst, 100)
+			for i, s := range tt.steps {
+				clock.advance(s.advance)
+				allowed := 0
+				for n := 0; n < s.requests; n++ {
+					if rl.Allow("10.0.0.1").Allowed {
+						allowed++
+					}
+				}
+				if allowed != s.allowed {
+					t.Fatalf("paso %d: %d de %d permitidas, se esperaban %d", i, allowed, s.requests, s.allowed)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterDecision(t *testing.T) {
+	rl, clock := newTestRateLimiter(t, 10, time.Second, 4, 100)
+	tests := []struct {
+		name    string
+		advance time.Duration
+		want    Decision
+	}{
+		{"primera", 0, Decision{Allowed: true, Limit: 4, Remaining: 3, Reset: 100 * time.Millisecond}},
+		{"segunda", 0, Decision{Allowed: true, Limit: 4, Remaining: 2, Reset: 200 * time.Millisecond}},
+		{"tercera", 0, Decision{Allowed: true, Limit: 4, Remaining: 1, Reset: 300 * time.Millisecond}},
+		{"cuarta", 0, Decision{Allowed: true, Limit: 4, Remaining: 0, Reset: 400 * time.Millisecond}},
+		{"rechazada", 0, Decision{Limit: 4, Reset: 400 * time.Millisecond, RetryAfter: 100 * time.Millisecond}},
+		{"rechazada a medio token", 50 * time.Millisecond, Decision{Limit: 4, Reset: 350 * time.Millisecond, RetryAfter: 50 * time.Millisecond}},
+		{"token recargado", 50 * time.Millisecond, Decision{Allowed: true, Limit: 4, Remaining: 0, Reset: 400 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		clock.advance(tt.advance)
+		got := rl.Allow("10.0.0.1")
+		// Los tiempos salen de aritmética en coma flotante: se comparan al milisegundo
+		got.Reset = got.Reset.Round(time.Millisecond)
+		got.RetryAfter = got.RetryAfter.Round(time.Millisecond)
+		if got != tt.want {
+			t.Errorf("%s: Allow() = %+v, se esperaba %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimiterKeysAreIndependent(t *testing.T) {
+	rl, clock := newTestRateLimiter(t, 1, time.Second, 1, 100)
+	if !rl.Allow("10.0.0.1").Allowed || !rl.Allow("10.0.0.2").Allowed {
+		t.Fatal("la primera solicitud de cada IP debería permitirse")
+	}
+	if rl.Allow("10.0.0.1").Allowed {
+		t.Error("la segunda solicitud de 10.0.0.1 debería rechazarse")
+	}
+	clock.advance(time.Second)
+	if !rl.Allow("10.0.0.1").Allowed {
+		t.Error("tras un segundo 10.0.0.1 debería tener un token")
+	}
+}
+
+// ipForIndex genera una IP distinta por índice, como un flood de IPs falsas
+func ipForIndex(i int) string {
+	return strconv.Itoa(10+i>>24&0xff) + "." + strconv.Itoa(i>>16&0xff) + "." + strconv.Itoa(i>>8&0xff) + "." + strconv.Itoa(i&0xff)
+}
+
+func TestRateLimiterVisitorsBoundedUnderChurn(t *testing.T) {
+	const maxVisitors = 32 * 100 // 100 por partición
+	rl, clock := newTestRateLimiter(t, 100, time.Minute, 0, maxVisitors)
+	for i := 0; i < 100000; i++ {
+		rl.Allow(ipForIndex(i))
+		if i%1000 == 0 {
+			clock.advance(time.Millisecond)
+			if n := rl.Len(); n > maxVisitors {
+				t.Fatalf("tras %d IPs hay %d visitantes, máximo %d", i+1, n, maxVisitors)
+			}
+		}
+	}
+	if n := rl.Len(); n != maxVisitors {
+		t.Errorf("Len() = %d tras el flood, se esperaba la tabla llena (%d)", n, maxVisitors)
+	}
+}
+
+func TestRateLimiterEvictsLeastRecentVisitor(t *testing.T) {
+	// Con maxVisitors menor que el número de particiones cada una guarda un visitante
+	rl, _ := newTestRateLimiter(t, 1, time.Hour, 1, 1)
+	sh := rl.shard("10.0.0.1")
+	// Busca otra IP de la misma partición
+	other := ""
+	for i := 0; other == ""; i++ {
+		if ip := ipForIndex(i); ip != "10.0.0.1" && rl.shard(ip) == sh {
+			other = ip
+		}
+	}
+	rl.Allow("10.0.0.1")
+	if rl.Allow("10.0.0.1").Allowed {
+		t.Fatal("la segunda solicitud debería rechazarse")
+	}
+	rl.Allow(other)
+	if !rl.Allow("10.0.0.1").Allowed {
+		t.Error("10.0.0.1 debería haberse expulsado y empezar con el bucket lleno")
+	}
+	if n := rl.Len(); n != 1 {
+		t.Errorf("Len() = %d, la partición solo admite un visitante", n)
+	}
+}
+
+func TestRateLimiterCleanupStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	before := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		NewRateLimiter(ctx, 10, time.Second, 0, 100)
+	}
+	cancel()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("quedan %d goroutines de limpieza tras cancelar el contexto", n-before)
+	}
+}
+
+func TestRateLimiterTinyRefillTime(t *testing.T) {
+	// Un bucket que se llena en menos de 1ns no debe dejar el barrido sin intervalo
+	limit, err := ParseRateLimit("1000000000/1ns:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl, clock := newTestRateLimiter(t, limit.Limit, limit.Window, limit.Burst, 100)
+	if d := rl.fullAfter(); d != 0 {
+		t.Fatalf("fullAfter() = %s, el caso a probar es un bucket que se llena al instante", d)
+	}
+	for i := 0; i < 3; i++ {
+		if !rl.Allow("10.0.0.1").Allowed {
+			t.Errorf("solicitud %d rechazada", i)
+		}
+		clock.advance(time.Nanosecond)
+	}
+	// Da tiempo a que la goroutine de limpieza cree su ticker
+	time.Sleep(50 * time.Millisecond)
+}
+
+// BenchmarkRateLimiterHighCardinality mide el rendimiento con una IP nueva en
+// casi cada solicitud; visitors muestra que la tabla no pasa de maxVisitors
+func BenchmarkRateLimiterHighCardinality(b *testing.B) {
+	for _, maxVisitors := range []int{10000, 100000} {
+		b.Run("max="+strconv.Itoa(maxVisitors), func(b *testing.B) {
+			rl, _ := newTestRateLimiter(b, 100, time.Minute, 0, maxVisitors)
+			rl.now = time.Now
+			var next atomic.Int64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					rl.Allow(ipForIndex(int(next.Add(1))))
+				}
+			})
+			b.StopTimer()
+			if n := rl.Len(); n > maxVisitors {
+				b.Fatalf("Len() = %d, máximo %d", n, maxVisitors)
+			}
+			b.ReportMetric(float64(rl.Len()), "visitors")
+		})
+	}
+}
+
+// BenchmarkRateLimiterHotKeys es la referencia con pocas IPs muy activas
+func BenchmarkRateLimiterHotKeys(b *testing.B) {
+	rl, _ := newTestRateLimiter(b, 100, time.Minute, 0, 100000)
+	rl.now = time.Now
+	ips := make([]string, 64)
+	for i := range ips {
+		ips[i] = ipForIndex(i)
+	}
+	var next atomic.Int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rl.Allow(ips[next.Add(1)%int64(len(ips))])
+		}
+	})
+}
@@ -1,72 +1,244 @@
 package router
 
 import (
-	"log"
-	"net"
+	"container/list"
+	"context"
+	"hash/maphash"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"router-app/logging"
 )
 
+var rateLimitLog = logging.For("ratelimit")
+
+// rateLimiterShards reparte la tabla de visitantes para reducir la contención del mutex
+const rateLimiterShards = 32
+
+// rateLimiter es un token bucket por IP: cada cliente acumula rate/window tokens
+// por segundo, de forma fraccional, hasta un máximo de burst. La tabla de
+// visitantes está particionada y acotada con expulsión LRU.
 type rateLimiter struct {
-	visitors map[string]*visitor
+	shards []*visitorShard
+	seed   maphash.Seed
+	rate   float64 // tokens por segundo
+	burst  float64
+	// now es el reloj del limitador; se sustituye en tests para hacerlos deterministas
+	now func() time.Time
+}
+
+// visitorShard es una partición de la tabla; lru tiene al frente el visitante más reciente
+type visitorShard struct {
 	mu       sync.Mutex
-	rate     int
-	window   time.Duration
+	visitors map[string]*list.Element
+	lru      *list.List
+	max      int
 }
 
 type visitor struct {
-	lastSeen time.Time
-	tokens   int
+	key    string
+	tokens float64
+	last   time.Time
 }
 
-func NewRateLimiter(rate int, window time.Duration) *rateLimiter {
+// NewRateLimiter permite rate solicitudes por window con ráfagas de hasta burst.
+// Con burst <= 0 la ráfaga máxima es rate. La tabla guarda como mucho maxVisitors
+// clientes (expulsando los menos recientes) y su limpieza para al cancelar ctx.
+func NewRateLimiter(ctx context.Context, rate int, window time.Duration, burst, maxVisitors int) *rateLimiter {
+	if burst <= 0 {
+		burst = rate
+	}
+	perShard := maxVisitors / rateLimiterShards
+	if perShard < 1 {
+		perShard = 1
+	}
 	rl := &rateLimiter{
-		visitors: make(map[string]*visitor),
-		rate:     rate,
-		window:   window,
+		shards: make([]*visitorShard, rateLimiterShards),
+		seed:   maphash.MakeSeed(),
+		rate:   float64(rate) / window.Seconds(),
+		burst:  float64(burst),
+		now:    time.Now,
+	}
+	for i := range rl.shards {
+		rl.shards[i] = &visitorShard{
+			visitors: make(map[string]*list.Element),
+			lru:      list.New(),
+			max:      perShard,
+		}
 	}
-	go rl.cleanupVisitors()
+	go rl.cleanupVisitors(ctx)
 	return rl
 }
 
-func (rl *rateLimiter) cleanupVisitors() {
+func (rl *rateLimiter) shard(key string) *visitorShard {
+	return rl.shards[maphash.String(rl.seed, key)%rateLimiterShards]
+}
+
+// fullAfter es el tiempo que tarda un bucket vacío en llenarse
+func (rl *rateLimiter) fullAfter() time.Duration {
+	return time.Duration(rl.burst / rl.rate * float64(time.Second))
+}
+
+// cleanupVisitors elimina los buckets llenos, que equivalen a un visitante nuevo.
+// Como la lista está ordenada por último acceso, basta con recorrerla desde el final.
+// Con límites muy altos fullAfter se redondea a cero, así que el barrido es como
+// mucho cada segundo.
+func (rl *rateLimiter) cleanupVisitors(ctx context.Context) {
+	ticker := time.NewTicker(max(rl.fullAfter(), time.Second))
+	defer ticker.Stop()
 	for {
-		time.Sleep(rl.window)
-		rl.mu.Lock()
-		for ip, v := range rl.visitors {
-			if time.Since(v.lastSeen) > rl.window {
-				delete(rl.visitors, ip)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, sh := range rl.shards {
+			sh.mu.Lock()
+			now := rl.now()
+			for e := sh.lru.Back(); e != nil; e = sh.lru.Back() {
+				v := e.Value.(*visitor)
+				if now.Sub(v.last) < rl.fullAfter() {
+					break
+				}
+				sh.lru.Remove(e)
+				delete(sh.visitors, v.key)
 			}
+			sh.mu.Unlock()
 		}
-		rl.mu.Unlock()
 	}
 }
 
-func (rl *rateLimiter) Allow(ip string) bool {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-	v, exists := rl.visitors[ip]
-	now := time.Now()
-	if !exists || time.Since(v.lastSeen) > rl.window {
-		rl.visitors[ip] = &visitor{lastSeen: now, tokens: rl.rate - 1}
-		return true
+// Len devuelve el número de visitantes en memoria
+func (rl *rateLimiter) Len() int {
+	n := 0
+	for _, sh := range rl.shards {
+		sh.mu.Lock()
+		n += len(sh.visitors)
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+// Decision es el resultado de consultar el limitador para una solicitud
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset es el tiempo hasta que el bucket vuelve a estar lleno
+	Reset time.Duration
+	// RetryAfter es el tiempo hasta el próximo token cuando la solicitud se rechaza
+	RetryAfter time.Duration
+}
+
+func (rl *rateLimiter) Allow(ip string) Decision {
+	sh := rl.shard(ip)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	now := rl.now()
+	var v *visitor
+	if e, exists := sh.visitors[ip]; exists {
+		v = e.Value.(*visitor)
+		sh.lru.MoveToFront(e)
+	} else {
+		// Al llenarse la partición se expulsa al menos reciente: un flood de IPs
+		// falsas no hace crecer la memoria, a cambio de olvidar buckets antiguos
+		if sh.lru.Len() >= sh.max {
+			oldest := sh.lru.Back()
+			sh.lru.Remove(oldest)
+			delete(sh.visitors, oldest.Value.(*visitor).key)
+		}
+		v = &visitor{key: ip, tokens: rl.burst, last: now}
+		sh.visitors[ip] = sh.lru.PushFront(v)
 	}
-	if v.tokens > 0 {
+	if elapsed := now.Sub(v.last).Seconds(); elapsed > 0 {
+		v.tokens += elapsed * rl.rate
+		if v.tokens > rl.burst {
+			v.tokens = rl.burst
+		}
+	}
+	v.last = now
+	d := Decision{Limit: int(rl.burst)}
+	if v.tokens >= 1 {
 		v.tokens--
-		v.lastSeen = now
-		return true
+		d.Allowed = true
+	} else {
+		d.RetryAfter = secondsToDuration((1 - v.tokens) / rl.rate)
+	}
+	d.Remaining = int(v.tokens)
+	d.Reset = secondsToDuration((rl.burst - v.tokens) / rl.rate)
+	return d
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// ceilSeconds redondea hacia arriba, para no invitar a reintentar antes de tiempo
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// writeRateLimitHeaders emite las cabeceras RateLimit-* del borrador del IETF
+func writeRateLimitHeaders(w http.ResponseWriter, d Decision) {
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+	if !d.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(d.RetryAfter)))
+	}
+}
+
+// routeParams extrae tipo y key de las rutas /route/{tipo}/{key} y /add-destino/{tipo}/{key}
+func routeParams(path string) (string, string) {
+	for _, prefix := range []string{"/route/", "/add-destino/"} {
+		if strings.HasPrefix(path, prefix) {
+			parts := strings.Split(strings.TrimPrefix(path, prefix), "/")
+			if len(parts) == 2 {
+				return parts[0], parts[1]
+			}
+		}
 	}
-	return false
+	return "", ""
+}
+
+// PreAuthRateLimitMiddleware aplica las políticas previas a la autenticación.
+// Debe ir fuera de AuthMiddleware para que los intentos con credenciales
+// inválidas también se limiten antes de consultar el almacén de claves.
+func PreAuthRateLimitMiddleware(pl *PolicyLimiter, next http.Handler) http.Handler {
+	return rateLimitHandler(pl, true, next)
+}
+
+// Middleware para usar en los handlers. Debe ir dentro de AuthMiddleware para
+// que las políticas por API key vean la identidad.
+func RateLimitMiddleware(pl *PolicyLimiter, next http.Handler) http.Handler {
+	return rateLimitHandler(pl, false, next)
 }
 
-// Middleware para usar en los handlers
-func RateLimitMiddleware(rl *rateLimiter, next http.Handler) http.Handler {
+func rateLimitHandler(pl *PolicyLimiter, preAuth bool, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-		if !rl.Allow(ip) {
-			log.Printf("Rate limit excedido para IP %s, User-Agent: %s, Path: %s", ip, r.UserAgent(), r.URL.Path)
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		ip := ClientIP(r)
+		tipo, key := routeParams(r.URL.Path)
+		d, policy, ok := pl.Allow(requestDimensions{
+			ip:       ip,
+			identity: IdentityFromContext(r.Context()),
+			tipo:     tipo,
+			key:      key,
+		}, preAuth)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		writeRateLimitHeaders(w, d)
+		if !d.Allowed {
+			rateLimitRejections.Inc(policy)
+			countRejected("ratelimit", http.StatusTooManyRequests)
+			rateLimitLog.InfoContext(r.Context(), "Rate limit excedido", "policy", policy, "ip", ip, "user_agent", r.UserAgent(), "path", r.URL.Path)
+			writeJSONError(w, http.StatusTooManyRequests, "Too Many Requests")
 			return
 		}
 		next.ServeHTTP(w, r)
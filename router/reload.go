@@ -0,0 +1,177 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"router-app/config"
+	"router-app/logging"
+)
+
+var reloadLog = logging.For("reload")
+
+// ReloadStep prepara la aplicación de una configuración nueva. Si devuelve error
+// la recarga se cancela sin tocar nada; si no, la función commit la aplica.
+type ReloadStep func(next *config.Config) (commit func(), err error)
+
+// Reloader vuelve a cargar la configuración (fichero, entorno y flags) y aplica
+// los cambios que admiten recarga en caliente. Todos los pasos se preparan antes
+// de aplicar ninguno, así que o se aplican todos los cambios o ninguno.
+type Reloader struct {
+	mu      sync.Mutex
+	current *config.Config
+	load    func() (*config.Config, error)
+	steps   []ReloadStep
+}
+
+func NewReloader(current *config.Config, load func() (*config.Config, error), steps ...ReloadStep) *Reloader {
+	return &Reloader{current: current, load: load, steps: steps}
+}
+
+// ImmutableChangeError indica que la configuración nueva cambia ajustes que
+// solo se leen al arrancar
+type ImmutableChangeError struct {
+	Keys []string
+}
+
+func (e *ImmutableChangeError) Error() string {
+	return "estos ajustes solo se aplican al reiniciar, deshaz el cambio o reinicia el servicio: " + strings.Join(e.Keys, ", ")
+}
+
+// Reload devuelve las claves que cambiaron
+func (rl *Reloader) Reload(ctx context.Context) ([]string, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	next, err := rl.load()
+	if err != nil {
+		return nil, err
+	}
+	changed, immutable := config.Changes(rl.current, next)
+	if len(immutable) > 0 {
+		return nil, &ImmutableChangeError{Keys: immutable}
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	commits := make([]func(), 0, len(rl.steps))
+	for _, step := range rl.steps {
+		commit, err := step(next)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, commit)
+	}
+	for _, commit := range commits {
+		commit()
+	}
+	rl.current = next
+	reloadLog.InfoContext(ctx, "Configuración recargada", "cambios", changed)
+	return changed, nil
+}
+
+// WatchSignals recarga la configuración con cada SIGHUP hasta que se cancele ctx
+func (rl *Reloader) WatchSignals(ctx context.Context) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	defer signal.Stop(sig)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			if _, err := rl.Reload(ctx); err != nil {
+				reloadLog.ErrorContext(ctx, "Recarga de configuración rechazada", "error", err)
+			}
+		}
+	}
+}
+
+// ServeHTTP atiende POST /admin/reload, solo para identidades con scope admin
+func (rl *Reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !authorizeAdmin(w, r) {
+		return
+	}
+	changed, err := rl.Reload(r.Context())
+	if err != nil {
+		reloadLog.ErrorContext(r.Context(), "Recarga de configuración rechazada", "error", err)
+		status := http.StatusBadRequest
+		if _, ok := err.(*ImmutableChangeError); ok {
+			status = http.StatusConflict
+		}
+		writeJSONError(w, status, err.Error())
+		return
+	}
+	if changed == nil {
+		changed = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": "reloaded", "changed": changed})
+}
+
+// LogLevelsReloadStep aplica LOG_LEVEL y LOG_LEVELS
+func LogLevelsReloadStep(next *config.Config) (func(), error) {
+	level, err := logging.ParseLevel(next.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	componentLevels, err := logging.ParseComponentLevels(next.LogLevels)
+	if err != nil {
+		return nil, fmt.Errorf("LOG_LEVELS: %w", err)
+	}
+	return func() { logging.SetLevels(level, componentLevels) }, nil
+}
+
+// ReloadStep aplica las políticas de rate limiting
+func (pl *PolicyLimiter) ReloadStep(next *config.Config) (func(), error) {
+	policies, err := PoliciesFromConfig(next)
+	if err != nil {
+		return nil, fmt.Errorf("RATE_LIMIT_POLICIES: %w", err)
+	}
+	return func() { pl.SetPolicies(policies) }, nil
+}
+
+// ReloadStep aplica los límites de validación y del circuit breaker
+func (h *Handler) ReloadStep(next *config.Config) (func(), error) {
+	return func() { h.ApplyConfig(next) }, nil
+}
+
+// ReloadStep aplica el intervalo de refresco de rutas
+func (s *service) ReloadStep(next *config.Config) (func(), error) {
+	return func() { s.SetRefreshInterval(time.Duration(next.RoutesRefreshSeconds) * time.Second) }, nil
+}
+
+// ReloadStep aplica el intervalo y las opciones del fichero de rutas
+func (rc *RoutesFileReconciler) ReloadStep(next *config.Config) (func(), error) {
+	return func() { rc.ApplyConfig(next) }, nil
+}
+
+// ReloadStep aplica la API key de la configuración, releyendo API_KEY_FILE si se usa
+func (s *ConfigKeyStore) ReloadStep(next *config.Config) (func(), error) {
+	return func() { s.SetKey(next.APIKey) }, nil
+}
+
+// ReloadStep aplica el secreto HMAC y el cuerpo máximo (MAX_BODY_SIZE y
+// MAX_IMPORT_SIZE). Activar o desactivar la firma cambia la cadena de
+// middlewares, así que eso solo se admite al reiniciar.
+func (v *SignatureVerifier) ReloadStep(next *config.Config) (func(), error) {
+	if next.HMACSecret == "" {
+		return nil, fmt.Errorf("HMAC_SECRET: la firma solo puede desactivarse al reiniciar")
+	}
+	secret := []byte(next.HMACSecret)
+	maxBody := SignatureBodyLimit(next)
+	return func() {
+		v.SetSecret(secret)
+		v.SetMaxBody(maxBody)
+	}, nil
+}
@@ -0,0 +1,183 @@
+package router
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memRepo es un Repository en memoria con la misma semántica de
+// revisiones que el de Mongo: cada escritura incrementa la revisión, que se
+// conserva tras un borrado, y WithExpectedRevision condiciona la escritura
+type memRepo struct {
+	mu        sync.Mutex
+	routes    map[string]Route
+	revisions map[string]int64
+	versions  map[string][]RouteVersion
+	pingErr   error
+}
+
+func newMemRepo(routes ...Route) *memRepo {
+	m := &memRepo{
+		routes:    make(map[string]Route),
+		revisions: make(map[string]int64),
+		versions:  make(map[string][]RouteVersion),
+	}
+	for _, r := range routes {
+		m.write(context.Background(), r.Key, r.Tipo, "seed", true, func(cur *Route) {
+			cur.Destinos, cur.Weights, cur.Drained, cur.ManagedBy = r.Destinos, r.Weights, r.Drained, r.ManagedBy
+		})
+	}
+	return m
+}
+
+func memID(key, tipo string) string {
+	return tipo + "/" + key
+}
+
+// write aplica change a la ruta, creándola si create y no existe, y devuelve
+// la ruta resultante
+func (m *memRepo) write(ctx context.Context, key, tipo, op string, create bool, change func(*Route)) (*Route, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := memID(key, tipo)
+	cur, exists := m.routes[id]
+	if revs, ok := ExpectedRevision(ctx); ok {
+		if !exists {
+			return nil, ErrRouteNotFound
+		}
+		if !slices.Contains(revs, cur.Revision) {
+			return nil, ErrRevisionMismatch
+		}
+	}
+	if !exists {
+		if !create {
+			return nil, ErrRouteNotFound
+		}
+		cur = Route{Key: key, Tipo: tipo}
+	}
+	change(&cur)
+	m.revisions[id]++
+	cur.Revision = m.revisions[id]
+	m.routes[id] = cur
+	m.versions[id] = append(m.versions[id], RouteVersion{Route: cur, Timestamp: time.Now(), Operation: op})
+	return &cur, nil
+}
+
+func (m *memRepo) GetRoute(_ context.Context, key, tipo string) (*Route, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.routes[memID(key, tipo)]
+	if !ok {
+		return nil, ErrRouteNotFound
+	}
+	return &r, nil
+}
+
+func (m *memRepo) SaveRoute(ctx context.Context, key, tipo, destino string) error {
+	_, err := m.write(ctx, key, tipo, "save", true, func(r *Route) {
+		if !slices.Contains(r.Destinos, destino) {
+			r.Destinos = append(r.Destinos, destino)
+		}
+	})
+	return err
+}
+
+func (m *memRepo) GetAllRoutes(ctx context.Context) ([]Route, error) {
+	return m.FindRoutes(ctx, "")
+}
+
+func (m *memRepo) FindRoutes(_ context.Context, tipo string) ([]Route, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []Route
+	for _, r := range m.routes {
+		if tipo == "" || r.Tipo == tipo {
+			out = append(out, r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return memID(out[i].Key, out[i].Tipo) < memID(out[j].Key, out[j].Tipo) })
+	return out, nil
+}
+
+func (m *memRepo) RemoveDestino(ctx context.Context, key, tipo, destino string) error {
+	_, err := m.write(ctx, key, tipo, "remove", false, func(r *Route) {
+		r.Destinos = slices.DeleteFunc(r.Destinos, func(d string) bool { return d == destino })
+	})
+	return err
+}
+
+func (m *memRepo) DeleteRoute(ctx context.Context, key, tipo string) error {
+	_, err := m.write(ctx, key, tipo, "delete", false, func(r *Route) {
+		r.Destinos, r.Weights, r.Drained, r.ManagedBy = nil, nil, nil, ""
+	})
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := memID(key, tipo)
+	delete(m.routes, id)
+	m.versions[id][len(m.versions[id])-1].Deleted = true
+	return nil
+}
+
+func (m *memRepo) SetWeights(ctx context.Context, key, tipo string, weights []DestinoWeight) error {
+	_, err := m.write(ctx, key, tipo, "weights", false, func(r *Route) { r.Weights = weights })
+	return err
+}
+
+func (m *memRepo) SetDrained(ctx context.Context, key, tipo, destino string, drained bool) error {
+	_, err := m.write(ctx, key, tipo, "drain", false, func(r *Route) {
+		r.Drained = slices.DeleteFunc(r.Drained, func(d string) bool { return d == destino })
+		if drained {
+			r.Drained = append(r.Drained, destino)
+		}
+	})
+	return err
+}
+
+func (m *memRepo) ReplaceRoute(ctx context.Context, route Route) (*Route, error) {
+	return m.write(ctx, route.Key, route.Tipo, "replace", true, func(r *Route) {
+		r.Destinos, r.Weights, r.Drained, r.ManagedBy = route.Destinos, route.Weights, route.Drained, route.ManagedBy
+	})
+}
+
+func (m *memRepo) ApplyRoutes(ctx context.Context, save, remove []Route) error {
+	for _, r := range save {
+		if _, err := m.ReplaceRoute(ctx, r); err != nil {
+			return err
+		}
+	}
+	for _, r := range remove {
+		if err := m.DeleteRoute(ctx, r.Key, r.Tipo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memRepo) ListVersions(_ context.Context, key, tipo string) ([]RouteVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	versions := slices.Clone(m.versions[memID(key, tipo)])
+	slices.Reverse(versions)
+	return versions, nil
+}
+
+func (m *memRepo) GetVersion(_ context.Context, key, tipo string, revision int64) (*RouteVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, v := range m.versions[memID(key, tipo)] {
+		if v.Revision == revision {
+			return &v, nil
+		}
+	}
+	return nil, ErrVersionNotFound
+}
+
+func (m *memRepo) Ping(context.Context) error {
+	return m.pingErr
+}
@@ -0,0 +1,64 @@
+package router
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"router-app/logging"
+)
+
+// HeaderRequestID es la cabecera con la que se recibe y devuelve el ID de solicitud
+const HeaderRequestID = "X-Request-ID"
+
+const maxRequestIDLength = 128
+
+// newRequestID genera un identificador aleatorio de 16 bytes en hexadecimal
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID acepta solo IDs cortos y con caracteres seguros, para que un
+// cliente no pueda inyectar contenido en los logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type requestIDKey struct{}
+
+// RequestIDFromContext devuelve el ID asignado por RequestIDMiddleware
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDMiddleware usa el X-Request-ID recibido (si es válido) o genera uno,
+// lo devuelve en la respuesta y lo adjunta a todos los logs de la solicitud
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HeaderRequestID)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(HeaderRequestID, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = logging.WithAttrs(ctx, "request_id", id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
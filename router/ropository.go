@@ -2,64 +2,465 @@ package router
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"router-app/logging"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var repoLog = logging.For("repository")
+
 type Repository interface {
-	GetRoute(key, tipo string) (*Route, error)
-	SaveRoute(key, tipo, destino string) error
-	GetAllRoutes() ([]Route, error)
+	GetRoute(ctx context.Context, key, tipo string) (*Route, error)
+	SaveRoute(ctx context.Context, key, tipo, destino string) error
+	GetAllRoutes(ctx context.Context) ([]Route, error)
+	// FindRoutes lista las rutas de un tipo, o todas si tipo está vacío
+	FindRoutes(ctx context.Context, tipo string) ([]Route, error)
+	RemoveDestino(ctx context.Context, key, tipo, destino string) error
+	DeleteRoute(ctx context.Context, key, tipo string) error
+	SetWeights(ctx context.Context, key, tipo string, weights []DestinoWeight) error
+	SetDrained(ctx context.Context, key, tipo, destino string, drained bool) error
+	// ReplaceRoute sustituye destinos, pesos, drenados y ManagedBy de la ruta,
+	// creándola si no existe, y devuelve la ruta con la revisión que deja
+	ReplaceRoute(ctx context.Context, route Route) (*Route, error)
+	// ApplyRoutes sustituye (o crea) las rutas de save y borra las de remove en
+	// una sola escritura masiva
+	ApplyRoutes(ctx context.Context, save, remove []Route) error
+	// ListVersions devuelve el historial de la ruta, la revisión más reciente primero
+	ListVersions(ctx context.Context, key, tipo string) ([]RouteVersion, error)
+	GetVersion(ctx context.Context, key, tipo string, revision int64) (*RouteVersion, error)
+	Ping(ctx context.Context) error
 }
 
+// repo guarda las rutas en "routes" y, por cada escritura, su nueva versión en
+// "route_versions". Las escrituras incrementan Route.Revision y, si el contexto
+// lleva WithExpectedRevision, solo se aplican sobre esa revisión. Borrar una ruta
+// deja el documento como lápida (deleted, sin destinos) para que la revisión
+// siga creciendo si se vuelve a crear: un ETag de antes del borrado nunca vuelve
+// a ser válido y el historial no repite revisiones.
 type repo struct {
-	col *mongo.Collection
+	col      *mongo.Collection
+	versions *mongo.Collection
 }
 
 func NewRepository(db *mongo.Database) Repository {
-	return &repo{col: db.Collection("routes")}
+	r := &repo{col: db.Collection("routes"), versions: db.Collection("route_versions")}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := r.versions.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "tipo", Value: 1}, {Key: "key", Value: 1}, {Key: "revision", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		repoLog.Warn("No se pudo crear el índice de route_versions", "error", err)
+	}
+	// Una ruta por tipo y key: un upsert condicional que pierde la carrera falla
+	// en vez de crear un duplicado
+	_, err = r.col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "tipo", Value: 1}, {Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		repoLog.Warn("No se pudo crear el índice de routes", "error", err)
+	}
+	return r
+}
+
+// notDeleted excluye las lápidas de las rutas borradas
+var notDeleted = bson.M{"$ne": true}
+
+// revisionFilter selecciona cualquiera de las revisiones revs. Las rutas
+// anteriores al versionado no tienen revision: cuentan como 0.
+func revisionFilter(revs ...int64) any {
+	in := make(bson.A, 0, len(revs)+1)
+	for _, rev := range revs {
+		in = append(in, rev)
+		if rev == 0 {
+			in = append(in, nil)
+		}
+	}
+	return bson.M{"$in": in}
+}
+
+// routeFilter selecciona la ruta, sin lápidas, y con WithExpectedRevision solo en
+// esa revisión
+func routeFilter(ctx context.Context, key, tipo string) bson.M {
+	filter := bson.M{"key": key, "tipo": tipo, "deleted": notDeleted}
+	if revs, ok := ExpectedRevision(ctx); ok {
+		filter["revision"] = revisionFilter(revs...)
+	}
+	return filter
 }
 
-func (r *repo) GetRoute(key, tipo string) (*Route, error) {
-	log.Printf("[Repository] Consulta a MongoDB: key='%s', tipo='%s'", key, tipo)
+// missing explica por qué una escritura no encontró la ruta: no existe o, con
+// revisión esperada, ha cambiado
+func (r *repo) missing(ctx context.Context, key, tipo string) error {
+	if _, ok := ExpectedRevision(ctx); !ok {
+		return ErrRouteNotFound
+	}
+	n, err := r.col.CountDocuments(ctx, bson.M{"key": key, "tipo": tipo, "deleted": notDeleted}, options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrRevisionMismatch
+	}
+	return ErrRouteNotFound
+}
+
+// newVersion prepara la versión que deja una escritura, con su autor y solicitud
+func newVersion(ctx context.Context, op string, route Route, deleted bool) RouteVersion {
+	v := RouteVersion{
+		Route:     route,
+		Deleted:   deleted,
+		Timestamp: time.Now().UTC(),
+		RequestID: RequestIDFromContext(ctx),
+		Operation: op,
+	}
+	if id := IdentityFromContext(ctx); id != nil {
+		v.Author = id.Subject
+	}
+	return v
+}
+
+// recordVersions guarda el historial; si falla la escritura ya está hecha, así
+// que solo se registra el error
+func (r *repo) recordVersions(ctx context.Context, versions ...RouteVersion) {
+	if len(versions) == 0 {
+		return
+	}
+	docs := make([]any, len(versions))
+	for i, v := range versions {
+		docs[i] = v
+	}
+	ctx, span := startMongoSpan(ctx, "insert_many", r.versions.Name())
+	start := time.Now()
+	_, err := r.versions.InsertMany(ctx, docs)
+	observeMongo("record_version", start, err)
+	endSpan(span, err)
+	if err != nil {
+		repoLog.ErrorContext(ctx, "No se pudo guardar la versión de la ruta", "error", err)
+	}
+}
+
+func (r *repo) GetRoute(ctx context.Context, key, tipo string) (*Route, error) {
+	repoLog.DebugContext(ctx, "Consulta a MongoDB", "operation", "find_one")
+	ctx, span := startMongoSpan(ctx, "find_one", r.col.Name())
 	var route Route
-	err := r.col.FindOne(context.TODO(), bson.M{"key": key, "tipo": tipo}).Decode(&route)
+	start := time.Now()
+	err := r.col.FindOne(ctx, bson.M{"key": key, "tipo": tipo, "deleted": notDeleted}).Decode(&route)
+	observeMongo("get_route", start, err)
+	endSpan(span, err)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrRouteNotFound
+	}
 	if err != nil {
-		log.Printf("[Repository] Error en FindOne: %v", err)
+		repoLog.DebugContext(ctx, "Error en FindOne", "error", err)
 		return nil, err
 	}
-	log.Printf("[Repository] Documento encontrado: %+v", route)
+	repoLog.DebugContext(ctx, "Documento encontrado", "destinos", len(route.Destinos))
 	return &route, nil
 }
 
-func (r *repo) SaveRoute(key, tipo, destino string) error {
-	log.Printf("Guardando destino %s en la key %s, tipo %s en la base de datos", destino, key, tipo)
-	_, err := r.col.UpdateOne(context.TODO(),
-		bson.M{"key": key, "tipo": tipo},
-		bson.M{"$addToSet": bson.M{"destinos": destino}},
-	)
+func (r *repo) SaveRoute(ctx context.Context, key, tipo, destino string) error {
+	repoLog.DebugContext(ctx, "Guardando destino en la base de datos", "destino", destino)
+	// Crea la ruta si todavía no existe
+	_, err := r.update(ctx, "save_route", key, tipo, bson.M{"$addToSet": bson.M{"destinos": destino}}, true)
 	return err
 }
 
-func (r *repo) GetAllRoutes() ([]Route, error) {
-	log.Println("Obteniendo todas las rutas de la base de datos...")
-	cursor, err := r.col.Find(context.TODO(), bson.M{})
+func (r *repo) GetAllRoutes(ctx context.Context) ([]Route, error) {
+	repoLog.DebugContext(ctx, "Obteniendo todas las rutas de la base de datos")
+	return r.find(ctx, "get_all_routes", bson.M{"deleted": notDeleted})
+}
+
+func (r *repo) FindRoutes(ctx context.Context, tipo string) ([]Route, error) {
+	filter := bson.M{"deleted": notDeleted}
+	if tipo != "" {
+		filter["tipo"] = tipo
+	}
+	return r.find(ctx, "find_routes", filter)
+}
+
+func (r *repo) find(ctx context.Context, op string, filter bson.M) ([]Route, error) {
+	ctx, span := startMongoSpan(ctx, "find", r.col.Name())
+	start := time.Now()
+	cursor, err := r.col.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "tipo", Value: 1}, {Key: "key", Value: 1}}))
+	defer func() {
+		observeMongo(op, start, err)
+		endSpan(span, err)
+	}()
 	if err != nil {
-		log.Printf("Error al obtener rutas: %v", err)
+		repoLog.ErrorContext(ctx, "Error al obtener rutas", "error", err)
 		return nil, err
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(ctx)
 
 	var routes []Route
-	for cursor.Next(context.TODO()) {
+	for cursor.Next(ctx) {
 		var route Route
 		if err := cursor.Decode(&route); err != nil {
-			log.Printf("Error decodificando ruta: %v", err)
+			repoLog.WarnContext(ctx, "Error decodificando ruta", "error", err)
 			continue
 		}
 		routes = append(routes, route)
 	}
 	return routes, nil
 }
+
+// update aplica un cambio a una ruta, incrementa su revisión y guarda la versión
+// resultante. Sin upsert devuelve ErrRouteNotFound si no hay ninguna; con upsert
+// una lápida vuelve a ser una ruta y conserva su revisión. Con revisión esperada
+// nunca crea la ruta. Devuelve la ruta tal como quedó tras esta escritura.
+func (r *repo) update(ctx context.Context, op, key, tipo string, change bson.M, upsert bool) (*Route, error) {
+	_, conditional := ExpectedRevision(ctx)
+	upsert = upsert && !conditional
+	filter := routeFilter(ctx, key, tipo)
+	if upsert {
+		filter = bson.M{"key": key, "tipo": tipo}
+		withUnset(change, "deleted")
+	}
+	change["$inc"] = bson.M{"revision": 1}
+	ctx, span := startMongoSpan(ctx, "find_one_and_update", r.col.Name())
+	start := time.Now()
+	var route Route
+	err := r.col.FindOneAndUpdate(ctx, filter, change,
+		options.FindOneAndUpdate().SetReturnDocument(options.After).SetUpsert(upsert),
+	).Decode(&route)
+	observeMongo(op, start, err)
+	endSpan(span, err)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, r.missing(ctx, key, tipo)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.recordVersions(ctx, newVersion(ctx, op, route, false))
+	return &route, nil
+}
+
+// withUnset añade campos al $unset del cambio
+func withUnset(change bson.M, fields ...string) {
+	unset, _ := change["$unset"].(bson.M)
+	if unset == nil {
+		unset = bson.M{}
+		change["$unset"] = unset
+	}
+	for _, f := range fields {
+		unset[f] = ""
+	}
+}
+
+// tombstone es el cambio que convierte una ruta en lápida
+func tombstone() bson.M {
+	change := bson.M{"$set": bson.M{"deleted": true}, "$inc": bson.M{"revision": 1}}
+	withUnset(change, "destinos", "weights", "drained", "managed_by")
+	return change
+}
+
+// routeChange es el cambio que deja los campos editables de la ruta como en route
+func routeChange(route Route) bson.M {
+	set := bson.M{"destinos": route.Destinos}
+	unset := bson.M{}
+	if len(route.Weights) > 0 {
+		set["weights"] = route.Weights
+	} else {
+		unset["weights"] = ""
+	}
+	if len(route.Drained) > 0 {
+		set["drained"] = route.Drained
+	} else {
+		unset["drained"] = ""
+	}
+	if route.ManagedBy != "" {
+		set["managed_by"] = route.ManagedBy
+	} else {
+		unset["managed_by"] = ""
+	}
+	change := bson.M{"$set": set}
+	if len(unset) > 0 {
+		change["$unset"] = unset
+	}
+	return change
+}
+
+func (r *repo) RemoveDestino(ctx context.Context, key, tipo, destino string) error {
+	repoLog.DebugContext(ctx, "Quitando destino", "destino", destino)
+	_, err := r.update(ctx, "remove_destino", key, tipo, bson.M{"$pull": bson.M{
+		"destinos": destino,
+		"drained":  destino,
+		"weights":  bson.M{"destino": destino},
+	}}, false)
+	return err
+}
+
+func (r *repo) SetWeights(ctx context.Context, key, tipo string, weights []DestinoWeight) error {
+	repoLog.DebugContext(ctx, "Cambiando pesos", "pesos", len(weights))
+	_, err := r.update(ctx, "set_weights", key, tipo, bson.M{"$set": bson.M{"weights": weights}}, false)
+	return err
+}
+
+func (r *repo) SetDrained(ctx context.Context, key, tipo, destino string, drained bool) error {
+	repoLog.DebugContext(ctx, "Cambiando drenado", "destino", destino, "drained", drained)
+	op := "$pull"
+	if drained {
+		op = "$addToSet"
+	}
+	_, err := r.update(ctx, "set_drained", key, tipo, bson.M{op: bson.M{"drained": destino}}, false)
+	return err
+}
+
+func (r *repo) DeleteRoute(ctx context.Context, key, tipo string) error {
+	repoLog.DebugContext(ctx, "Borrando ruta")
+	ctx, span := startMongoSpan(ctx, "find_one_and_update", r.col.Name())
+	start := time.Now()
+	var deleted Route
+	err := r.col.FindOneAndUpdate(ctx, routeFilter(ctx, key, tipo), tombstone(),
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&deleted)
+	observeMongo("delete_route", start, err)
+	endSpan(span, err)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return r.missing(ctx, key, tipo)
+	}
+	if err != nil {
+		return err
+	}
+	r.recordVersions(ctx, newVersion(ctx, "delete_route", deleted, true))
+	return nil
+}
+
+func (r *repo) ReplaceRoute(ctx context.Context, route Route) (*Route, error) {
+	repoLog.DebugContext(ctx, "Sustituyendo ruta", "destinos", len(route.Destinos))
+	return r.update(ctx, "replace_route", route.Key, route.Tipo, routeChange(route), true)
+}
+
+func (r *repo) ApplyRoutes(ctx context.Context, save, remove []Route) error {
+	if len(save) == 0 && len(remove) == 0 {
+		return nil
+	}
+	repoLog.DebugContext(ctx, "Escritura masiva de rutas", "guardar", len(save), "borrar", len(remove))
+	// Revisiones actuales, lápidas incluidas. Cada escritura solo se aplica sobre
+	// la revisión leída, así que la versión que deja es exactamente la siguiente.
+	affected := make(bson.A, 0, len(save)+len(remove))
+	for _, route := range append(append([]Route(nil), save...), remove...) {
+		affected = append(affected, bson.M{"key": route.Key, "tipo": route.Tipo})
+	}
+	current, err := r.find(ctx, "apply_routes_revisions", bson.M{"$or": affected})
+	if err != nil {
+		return err
+	}
+	revisions := make(map[string]int64, len(current))
+	for _, route := range current {
+		revisions[routeMapKey(route.Key, route.Tipo)] = route.Revision
+	}
+
+	// Todas las escrituras son upserts condicionales: si otra escritura cambió la
+	// revisión entremedias el filtro no encuentra la ruta, el upsert choca con el
+	// índice único de routes y el error identifica la operación
+	models := make([]mongo.WriteModel, 0, len(save)+len(remove))
+	versions := make([]RouteVersion, 0, len(save)+len(remove))
+	for _, route := range save {
+		rev := revisions[routeMapKey(route.Key, route.Tipo)]
+		change := routeChange(route)
+		withUnset(change, "deleted")
+		change["$inc"] = bson.M{"revision": 1}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"key": route.Key, "tipo": route.Tipo, "revision": revisionFilter(rev)}).
+			SetUpdate(change).
+			SetUpsert(true))
+		route.Revision = rev + 1
+		versions = append(versions, newVersion(ctx, "apply_routes", route, false))
+	}
+	for _, route := range remove {
+		rev := revisions[routeMapKey(route.Key, route.Tipo)]
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"key": route.Key, "tipo": route.Tipo, "revision": revisionFilter(rev)}).
+			SetUpdate(tombstone()).
+			SetUpsert(true))
+		deleted := Route{Key: route.Key, Tipo: route.Tipo, Revision: rev + 1}
+		versions = append(versions, newVersion(ctx, "apply_routes", deleted, true))
+	}
+	ctx, span := startMongoSpan(ctx, "bulk_write", r.col.Name())
+	start := time.Now()
+	_, err = r.col.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	observeMongo("apply_routes", start, err)
+	endSpan(span, err)
+	var bulkErr mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bulkErr) {
+		return err
+	}
+	// Con errores por operación el resto sí se aplicó: se guardan sus versiones
+	// y se devuelve un error con las rutas que no se escribieron
+	failed := make(map[int]bool, len(bulkErr.WriteErrors))
+	var conflicts []string
+	for _, we := range bulkErr.WriteErrors {
+		failed[we.Index] = true
+		v := versions[we.Index]
+		if mongo.IsDuplicateKeyError(we) {
+			conflicts = append(conflicts, v.Tipo+"/"+v.Key)
+		} else {
+			err = fmt.Errorf("%s/%s: %s", v.Tipo, v.Key, we.Message)
+		}
+	}
+	applied := versions[:0]
+	for i, v := range versions {
+		if !failed[i] {
+			applied = append(applied, v)
+		}
+	}
+	r.recordVersions(ctx, applied...)
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%w: %s", ErrRevisionMismatch, strings.Join(conflicts, ", "))
+	}
+	if bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) > 0 {
+		return err
+	}
+	return nil
+}
+
+func (r *repo) ListVersions(ctx context.Context, key, tipo string) ([]RouteVersion, error) {
+	ctx, span := startMongoSpan(ctx, "find", r.versions.Name())
+	start := time.Now()
+	cursor, err := r.versions.Find(ctx, bson.M{"key": key, "tipo": tipo},
+		options.Find().SetSort(bson.D{{Key: "revision", Value: -1}}).SetProjection(bson.M{"_id": 0}))
+	defer func() {
+		observeMongo("list_versions", start, err)
+		endSpan(span, err)
+	}()
+	if err != nil {
+		return nil, err
+	}
+	versions := []RouteVersion{}
+	err = cursor.All(ctx, &versions)
+	return versions, err
+}
+
+func (r *repo) GetVersion(ctx context.Context, key, tipo string, revision int64) (*RouteVersion, error) {
+	ctx, span := startMongoSpan(ctx, "find_one", r.versions.Name())
+	start := time.Now()
+	var v RouteVersion
+	err := r.versions.FindOne(ctx, bson.M{"key": key, "tipo": tipo, "revision": revision}).Decode(&v)
+	observeMongo("get_version", start, err)
+	endSpan(span, err)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (r *repo) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := r.col.Database().Client().Ping(ctx, nil)
+	observeMongo("ping", start, err)
+	return err
+}
@@ -0,0 +1,159 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"router-app/config"
+	"router-app/logging"
+)
+
+var routesFileLog = logging.For("routesfile")
+
+// ManagedByRoutesFile marca las rutas que crea o adopta RoutesFileReconciler
+const ManagedByRoutesFile = "routes-file"
+
+// RoutesFileReconciler mantiene MongoDB igual que un fichero de rutas declarativo
+// (ROUTES_FILE). Las rutas del fichero se crean o sustituyen y quedan marcadas
+// con ManagedByRoutesFile; las que desaparecen del fichero se borran solo si
+// llevan esa marca, salvo que se pida también borrar las creadas a mano.
+type RoutesFileReconciler struct {
+	path     string
+	svc      Service
+	settings atomic.Pointer[reconcilerSettings]
+	reset    chan struct{}
+}
+
+// reconcilerSettings son los ajustes recargables; se sustituyen juntos
+type reconcilerSettings struct {
+	limits         *handlerLimits
+	interval       time.Duration
+	pruneUnmanaged bool
+}
+
+func NewRoutesFileReconciler(svc Service, cfg *config.Config) *RoutesFileReconciler {
+	rc := &RoutesFileReconciler{
+		path:  cfg.RoutesFile,
+		svc:   svc,
+		reset: make(chan struct{}, 1),
+	}
+	rc.ApplyConfig(cfg)
+	return rc
+}
+
+// ApplyConfig aplica el intervalo, los límites de validación y ROUTES_FILE_PRUNE_UNMANAGED
+func (rc *RoutesFileReconciler) ApplyConfig(cfg *config.Config) {
+	prev := rc.settings.Swap(&reconcilerSettings{
+		limits:         limitsFromConfig(cfg),
+		interval:       cfg.RoutesFileInterval,
+		pruneUnmanaged: cfg.RoutesFilePruneUnmanaged,
+	})
+	if prev != nil && prev.interval != cfg.RoutesFileInterval {
+		select {
+		case rc.reset <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Plan lee y valida el fichero y calcula los cambios necesarios para que la base
+// de datos coincida con él. Devuelve también las rutas deseadas, ya marcadas.
+func (rc *RoutesFileReconciler) Plan(ctx context.Context) ([]Route, []RouteChange, error) {
+	settings := rc.settings.Load()
+	f, err := os.Open(rc.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	format := FormatFromName(rc.path)
+	if format == "" {
+		format = FormatYAML
+	}
+	desired, err := ReadRoutes(f, format)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", rc.path, err)
+	}
+	if problems := validateRoutes(desired, settings.limits); len(problems) > 0 {
+		return nil, nil, fmt.Errorf("%s: %w: %s", rc.path, ErrInvalidRoute, strings.Join(problems, "; "))
+	}
+	for i := range desired {
+		desired[i].ManagedBy = ManagedByRoutesFile
+	}
+
+	current, err := rc.svc.ListRoutes(ctx, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	managed := make(map[string]bool, len(current))
+	for _, route := range current {
+		managed[routeMapKey(route.Key, route.Tipo)] = route.ManagedBy == ManagedByRoutesFile
+	}
+	all := DiffRoutes(current, desired, true)
+	changes := all[:0]
+	for _, c := range all {
+		if c.Action == ChangeRemoved && !managed[routeMapKey(c.Key, c.Tipo)] && !settings.pruneUnmanaged {
+			continue
+		}
+		changes = append(changes, c)
+	}
+	return desired, changes, nil
+}
+
+// Reconcile aplica los cambios que calcula Plan y los devuelve
+func (rc *RoutesFileReconciler) Reconcile(ctx context.Context) ([]RouteChange, error) {
+	desired, changes, err := rc.Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	if err := rc.svc.ApplyImport(ctx, desired, changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// Run reconcilia al arrancar y después cada ROUTES_FILE_INTERVAL, de modo que
+// se recogen tanto los cambios del fichero como los hechos a mano en la base de
+// datos. Un error repetido solo se registra la primera vez.
+func (rc *RoutesFileReconciler) Run(ctx context.Context) {
+	// Los cambios quedan en la auditoría a nombre del fichero
+	ctx = WithIdentity(ctx, &Identity{Subject: ManagedByRoutesFile, Scheme: "system"})
+	ticker := time.NewTicker(rc.settings.Load().interval)
+	defer ticker.Stop()
+	lastErr := ""
+	for {
+		changes, err := rc.Reconcile(ctx)
+		switch {
+		case err != nil:
+			routesFileErrors.Inc()
+			if err.Error() != lastErr {
+				routesFileLog.ErrorContext(ctx, "No se pudo reconciliar el fichero de rutas", "file", rc.path, "error", err)
+			}
+			lastErr = err.Error()
+		case len(changes) > 0:
+			lastErr = ""
+			for _, c := range changes {
+				routesFileLog.InfoContext(ctx, "Ruta reconciliada", "action", c.Action, "tipo", c.Tipo, "key", c.Key,
+					"added", c.AddedDestinos, "removed", c.RemovedDestinos)
+			}
+			routesFileReconciled.Set(float64(time.Now().Unix()))
+		default:
+			lastErr = ""
+			routesFileReconciled.Set(float64(time.Now().Unix()))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-rc.reset:
+			ticker.Reset(rc.settings.Load().interval)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"router-app/config"
+)
+
+// newTestReconciler escribe spec en un fichero YAML temporal y devuelve un
+// reconciliador sobre un repositorio en memoria con las rutas indicadas
+func newTestReconciler(t *testing.T, spec string, prune bool, routes ...Route) (*RoutesFileReconciler, *memRepo, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	if err := os.WriteFile(path, []byte(spec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default()
+	cfg.RoutesFile = path
+	cfg.RoutesFilePruneUnmanaged = prune
+	repo := newMemRepo(routes...)
+	return NewRoutesFileReconciler(NewService(repo, nil, cfg), cfg), repo, path
+}
+
+const testRoutesFile = `
+- key: c1
+  tipo: payments
+  destinos: [http://a, http://b]
+- key: c2
+  tipo: search
+  destinos: [http://s]
+`
+
+func changeKeys(changes []RouteChange) []string {
+	var out []string
+	for _, c := range changes {
+		out = append(out, c.Action+" "+c.Tipo+"/"+c.Key)
+	}
+	return out
+}
+
+func TestRoutesFileKeepsUnmanagedRoutes(t *testing.T) {
+	seed := []Route{
+		{Key: "manual", Tipo: "payments", Destinos: []string{"http://m"}},
+		{Key: "old", Tipo: "payments", Destinos: []string{"http://o"}, ManagedBy: ManagedByRoutesFile},
+	}
+	ctx := context.Background()
+
+	rc, repo, _ := newTestReconciler(t, testRoutesFile, false, seed...)
+	changes, err := rc.Reconcile(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"added payments/c1", "removed payments/old", "added search/c2"}
+	if got := changeKeys(changes); !reflect.DeepEqual(got, want) {
+		t.Errorf("cambios = %v, se esperaba %v", got, want)
+	}
+	if _, err := repo.GetRoute(ctx, "manual", "payments"); err != nil {
+		t.Errorf("se borró una ruta creada a mano: %v", err)
+	}
+	if _, err := repo.GetRoute(ctx, "old", "payments"); !errors.Is(err, ErrRouteNotFound) {
+		t.Errorf("la ruta gestionada que salió del fichero sigue: %v", err)
+	}
+	c1, err := repo.GetRoute(ctx, "c1", "payments")
+	if err != nil || c1.ManagedBy != ManagedByRoutesFile {
+		t.Errorf("c1 = %+v, %v, se esperaba marcada como del fichero", c1, err)
+	}
+
+	// Con ROUTES_FILE_PRUNE_UNMANAGED también se borran las creadas a mano
+	rc, repo, _ = newTestReconciler(t, testRoutesFile, true, seed...)
+	if _, err := rc.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetRoute(ctx, "manual", "payments"); !errors.Is(err, ErrRouteNotFound) {
+		t.Errorf("con prune la ruta creada a mano sigue: %v", err)
+	}
+}
+
+func TestRoutesFileAdoptsManualRoute(t *testing.T) {
+	ctx := context.Background()
+	rc, repo, _ := newTestReconciler(t, testRoutesFile, false,
+		Route{Key: "c1", Tipo: "payments", Destinos: []string{"http://a", "http://b"}})
+	_, changes, err := rc.Plan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Key != "c1" || !changes[0].OwnerChanged || len(changes[0].AddedDestinos) != 0 {
+		t.Fatalf("cambios = %+v, se esperaba adoptar c1 sin tocar sus destinos", changes)
+	}
+	if _, err := rc.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if c1, _ := repo.GetRoute(ctx, "c1", "payments"); c1.ManagedBy != ManagedByRoutesFile {
+		t.Errorf("c1 no quedó adoptada: %+v", c1)
+	}
+}
+
+// TestRoutesFileCheckDetectsDrift reproduce --check: Plan no escribe y solo
+// devuelve cambios si la base de datos se aparta del fichero
+func TestRoutesFileCheckDetectsDrift(t *testing.T) {
+	ctx := context.Background()
+	rc, repo, path := newTestReconciler(t, testRoutesFile, false)
+	if _, changes, err := rc.Plan(ctx); err != nil || len(changes) != 2 {
+		t.Fatalf("Plan() antes de reconciliar = %v, %v", changes, err)
+	}
+	if routes, _ := repo.FindRoutes(ctx, ""); len(routes) != 0 {
+		t.Fatalf("Plan escribió %d rutas", len(routes))
+	}
+	if _, err := rc.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, changes, err := rc.Plan(ctx); err != nil || len(changes) != 0 {
+		t.Fatalf("Plan() tras reconciliar = %v, %v, se esperaba sin cambios", changes, err)
+	}
+
+	// Un cambio a mano en la base de datos es una deriva
+	if err := repo.SaveRoute(ctx, "c2", "search", "http://manual"); err != nil {
+		t.Fatal(err)
+	}
+	_, changes, err := rc.Plan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Key != "c2" || !reflect.DeepEqual(changes[0].RemovedDestinos, []string{"http://manual"}) {
+		t.Errorf("deriva = %+v, se esperaba quitar http://manual de c2", changes)
+	}
+
+	// Y también un cambio en el fichero
+	if err := os.WriteFile(path, []byte(testRoutesFile[:len(testRoutesFile)-len("- key: c2\n  tipo: search\n  destinos: [http://s]\n")]), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, changes, err = rc.Plan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := changeKeys(changes); !reflect.DeepEqual(got, []string{"removed search/c2"}) {
+		t.Errorf("cambios tras editar el fichero = %v", got)
+	}
+}
+
+func TestRoutesFileRejectsInvalidFile(t *testing.T) {
+	ctx := context.Background()
+	for name, spec := range map[string]string{
+		"ruta sin destinos": "- key: c1\n  tipo: payments\n  destinos: []\n",
+		"campo desconocido": "- key: c1\n  tipo: payments\n  destino: http://a\n",
+		"YAML mal formado":  "- key: [c1\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			rc, repo, _ := newTestReconciler(t, spec, true, Route{Key: "keep", Tipo: "payments", Destinos: []string{"http://k"}})
+			if _, err := rc.Reconcile(ctx); err == nil {
+				t.Fatal("se esperaba un error")
+			}
+			if _, err := repo.GetRoute(ctx, "keep", "payments"); err != nil {
+				t.Errorf("un fichero inválido borró rutas: %v", err)
+			}
+		})
+	}
+}
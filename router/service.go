@@ -1,91 +1,508 @@
 package router
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"router-app/config"
+	"router-app/logging"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var serviceLog = logging.For("service")
+
 type Service interface {
-	GetBalancedRoute(key, tipo string) (string, error)
-	AddDestino(key, tipo, destino string) error
-	RefreshRoutes()
+	GetBalancedRoute(ctx context.Context, key, tipo string) (string, error)
+	AddDestino(ctx context.Context, key, tipo, destino string) error
+	RefreshRoutes(ctx context.Context)
+
+	// Operaciones de administración
+	ListRoutes(ctx context.Context, tipo string) ([]Route, error)
+	GetRoute(ctx context.Context, key, tipo string) (*Route, error)
+	RemoveDestino(ctx context.Context, key, tipo, destino string) error
+	DeleteRoute(ctx context.Context, key, tipo string) error
+	// ReplaceRoute sustituye destinos, pesos y drenados de una ruta existente y
+	// devuelve la ruta que deja esta escritura
+	ReplaceRoute(ctx context.Context, route Route) (*Route, error)
+	SetWeights(ctx context.Context, key, tipo string, weights map[string]int) error
+	SetDrained(ctx context.Context, key, tipo, destino string, drained bool) error
+	// PlanImport calcula los cambios que haría importar routes; ApplyImport los aplica
+	PlanImport(ctx context.Context, routes []Route, mode ImportMode) ([]RouteChange, error)
+	ApplyImport(ctx context.Context, routes []Route, changes []RouteChange) error
+	Health(ctx context.Context) Health
+	QueryAudit(ctx context.Context, q AuditQuery) ([]AuditEntry, error)
+
+	// Historial de versiones
+	ListVersions(ctx context.Context, key, tipo string) ([]RouteVersion, error)
+	GetVersion(ctx context.Context, key, tipo string, revision int64) (*RouteVersion, error)
+	DiffVersions(ctx context.Context, key, tipo string, from, to int64) (*VersionDiff, error)
+	// Rollback vuelve a una revisión anterior y devuelve la ruta que deja
+	Rollback(ctx context.Context, key, tipo string, revision int64) (*Route, error)
+}
+
+// VersionDiff compara dos revisiones de una ruta
+type VersionDiff struct {
+	From    *RouteVersion `json:"from"`
+	To      *RouteVersion `json:"to"`
+	Changes []RouteChange `json:"changes"`
+}
+
+// Health resume el estado del servicio para GET /admin/health
+type Health struct {
+	Status       string    `json:"status"`
+	Mongo        string    `json:"mongo"`
+	CachedRoutes int       `json:"cached_routes"`
+	LastRefresh  time.Time `json:"last_refresh"`
 }
 
 type service struct {
-	repo      Repository
-	rr        map[string]int
-	mu        sync.Mutex
-	routes    map[string][]string
+	repo            Repository
+	audit           AuditLog
+	refreshInterval atomic.Int64
+	refreshReset    chan struct{}
+	lastRefresh     atomic.Int64
+	rr              map[string]int
+	mu              sync.Mutex
+	// routes guarda por ruta la lista de balanceo ya expandida por pesos (Route.Active)
+	routes map[string][]string
+	// tipos son los tipos de la tabla cargada; acotan la etiqueta tipo de las métricas
+	tipos     map[string]bool
 	refreshMu sync.RWMutex
 }
 
-func NewService(repo Repository) *service {
+// unknownTipo etiqueta en las métricas los tipos que no están en la tabla, para
+// que un cliente no pueda crear series nuevas con cada tipo que invente
+const unknownTipo = "unknown"
+
+// NewService crea el servicio; con audit nil los cambios no se auditan
+func NewService(repo Repository, audit AuditLog, cfg *config.Config) *service {
 	s := &service{
-		repo:   repo,
-		rr:     make(map[string]int),
-		routes: make(map[string][]string),
+		repo:         repo,
+		audit:        audit,
+		refreshReset: make(chan struct{}, 1),
+		rr:           make(map[string]int),
+		routes:       make(map[string][]string),
+		tipos:        make(map[string]bool),
 	}
-	s.RefreshRoutes()
+	s.refreshInterval.Store(int64(time.Duration(cfg.RoutesRefreshSeconds) * time.Second))
+	s.RefreshRoutes(context.Background())
 	return s
 }
 
+// SetRefreshInterval cambia el intervalo de refresco; Run lo aplica de inmediato
+func (s *service) SetRefreshInterval(d time.Duration) {
+	if time.Duration(s.refreshInterval.Swap(int64(d))) == d {
+		return
+	}
+	select {
+	case s.refreshReset <- struct{}{}:
+	default:
+	}
+}
+
+// Run refresca las rutas periódicamente hasta que se cancele ctx
+func (s *service) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(s.refreshInterval.Load()))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.refreshReset:
+			ticker.Reset(time.Duration(s.refreshInterval.Load()))
+		case <-ticker.C:
+			s.RefreshRoutes(ctx)
+		}
+	}
+}
+
 func routeMapKey(key, tipo string) string {
 	return key + "|" + tipo
 }
 
-func (s *service) RefreshRoutes() {
-	log.Println("Refrescando rutas desde la base de datos...")
-	routes, err := s.repo.GetAllRoutes()
+func (s *service) RefreshRoutes(ctx context.Context) {
+	ctx, span := tracer.Start(ctx, "service.RefreshRoutes")
+	var err error
+	defer func() { endSpan(span, err) }()
+	serviceLog.DebugContext(ctx, "Refrescando rutas desde la base de datos")
+	start := time.Now()
+	routes, err := s.repo.GetAllRoutes(ctx)
 	if err != nil {
-		log.Printf("Error al refrescar rutas: %v", err)
+		cacheRefreshErrors.Inc()
+		serviceLog.ErrorContext(ctx, "Error al refrescar rutas", "error", err)
 		return
 	}
+	lockStart := time.Now()
 	s.refreshMu.Lock()
+	lockWait(span, "refresh", lockStart)
 	defer s.refreshMu.Unlock()
+	span.SetAttributes(attribute.Int("routes.count", len(routes)))
 	s.routes = make(map[string][]string)
+	s.tipos = make(map[string]bool)
 	for _, route := range routes {
-		s.routes[routeMapKey(route.Key, route.Tipo)] = route.Destinos
+		s.routes[routeMapKey(route.Key, route.Tipo)] = route.Active()
+		s.tipos[route.Tipo] = true
 	}
-	log.Printf("Rutas cargadas en memoria: %d", len(s.routes))
+	s.lastRefresh.Store(time.Now().UnixNano())
+	cacheRoutes.Set(float64(len(s.routes)))
+	cacheLastRefresh.Set(float64(time.Now().Unix()))
+	cacheRefreshDuration.Set(time.Since(start).Seconds())
+	serviceLog.InfoContext(ctx, "Rutas cargadas en memoria", "rutas", len(s.routes), "duracion", time.Since(start))
 }
 
-func (s *service) GetBalancedRoute(key, tipo string) (string, error) {
+func (s *service) GetBalancedRoute(ctx context.Context, key, tipo string) (string, error) {
+	ctx, span := tracer.Start(ctx, "service.GetBalancedRoute", trace.WithAttributes(
+		attribute.String("route.tipo", tipo),
+		attribute.String("route.key", key),
+	))
+	defer span.End()
 	mapKey := routeMapKey(key, tipo)
+	lockStart := time.Now()
 	s.refreshMu.RLock()
+	lockWait(span, "refresh", lockStart)
 	destinos, ok := s.routes[mapKey]
+	metricTipo := tipo
+	if !s.tipos[tipo] {
+		metricTipo = unknownTipo
+	}
 	s.refreshMu.RUnlock()
-	log.Printf("[Service] Buscando en memoria mapKey='%s'. Encontrado: %v, destinos: %#v", mapKey, ok, destinos)
+	span.SetAttributes(attribute.Bool("cache.hit", ok && len(destinos) > 0))
 	if !ok || len(destinos) == 0 {
-		log.Printf("[Service] No se encontró la ruta en memoria para key='%s', tipo='%s'. Consultando MongoDB...", key, tipo)
-		route, err := s.repo.GetRoute(key, tipo)
+		serviceLog.DebugContext(ctx, "Ruta no encontrada en memoria, consultando MongoDB")
+		route, err := s.repo.GetRoute(ctx, key, tipo)
 		if err != nil {
-			log.Printf("[Service] Error consultando MongoDB: %v", err)
+			routeLookups.Inc(metricTipo, "not_found")
+			serviceLog.DebugContext(ctx, "Error consultando MongoDB", "error", err)
+			span.RecordError(err)
 			return "", err
 		}
-		if len(route.Destinos) == 0 {
-			log.Printf("[Service] Documento encontrado pero sin destinos: %+v", route)
+		destinos = route.Active()
+		if len(destinos) == 0 {
+			routeLookups.Inc(metricTipo, "not_found")
+			serviceLog.DebugContext(ctx, "Documento encontrado pero sin destinos activos")
 			return "", nil
 		}
-		destinos = route.Destinos
+		routeLookups.Inc(tipo, "miss")
+	} else {
+		routeLookups.Inc(tipo, "hit")
 	}
+	lockStart = time.Now()
 	s.mu.Lock()
+	lockWait(span, "rr", lockStart)
 	defer s.mu.Unlock()
 	idx := s.rr[mapKey] % len(destinos)
 	s.rr[mapKey] = (s.rr[mapKey] + 1) % len(destinos)
-	log.Printf("[Service] Retornando destino: %s para key='%s', tipo='%s'", destinos[idx], key, tipo)
+	destinoSelections.Inc(tipo, destinos[idx])
+	serviceLog.DebugContext(ctx, "Destino seleccionado", "destino", destinos[idx], "cache_hit", ok)
+	span.SetAttributes(attribute.String("route.destino", destinos[idx]))
 	return destinos[idx], nil
 }
 
-func (s *service) AddDestino(key, tipo, destino string) error {
-	log.Printf("Agregando destino %s a la key %s, tipo %s", destino, key, tipo)
-	err := s.repo.SaveRoute(key, tipo, destino)
+func (s *service) AddDestino(ctx context.Context, key, tipo, destino string) error {
+	ctx, span := tracer.Start(ctx, "service.AddDestino", trace.WithAttributes(
+		attribute.String("route.tipo", tipo),
+		attribute.String("route.key", key),
+	))
+	defer span.End()
+	serviceLog.DebugContext(ctx, "Agregando destino", "destino", destino)
+	before, err := s.repo.GetRoute(ctx, key, tipo)
+	if err != nil && !errors.Is(err, ErrRouteNotFound) {
+		span.RecordError(err)
+		return err
+	}
+	err = s.mutate(ctx, AuditAddDestino, key, tipo, before, func() error {
+		return s.repo.SaveRoute(ctx, key, tipo, destino)
+	})
 	if err != nil {
-		log.Printf("Error agregando destino %s a la key %s, tipo %s: %v", destino, key, tipo, err)
+		serviceLog.ErrorContext(ctx, "Error agregando destino", "destino", destino, "error", err)
+		span.RecordError(err)
 		return err
 	}
-	s.refreshMu.Lock()
+	return nil
+}
+
+// mutate aplica change a la ruta, actualiza su caché y registra el cambio en la
+// auditoría con el estado anterior (before) y el resultante
+func (s *service) mutate(ctx context.Context, operation, key, tipo string, before *Route, change func() error) error {
+	if err := change(); err != nil {
+		return err
+	}
+	after, err := s.reloadRoute(ctx, key, tipo)
+	if err != nil {
+		// El cambio ya está hecho; se audita aunque no se conozca el estado final
+		serviceLog.WarnContext(ctx, "Cambio auditado sin estado final", "operation", operation)
+	}
+	s.record(ctx, newAuditEntry(ctx, operation, key, tipo, before, after))
+	return nil
+}
+
+// record guarda una entrada de auditoría; si falla el cambio no se deshace, solo
+// se registra el error
+func (s *service) record(ctx context.Context, entry AuditEntry) {
+	if s.audit == nil {
+		return
+	}
+	if err := s.audit.Record(ctx, entry); err != nil {
+		auditErrors.Inc()
+		serviceLog.ErrorContext(ctx, "No se pudo registrar el cambio en la auditoría",
+			"operation", entry.Operation, "error", err)
+	}
+}
+
+// reloadRoute actualiza la caché de una ruta tras modificarla, sin esperar al
+// siguiente refresco completo. Devuelve la ruta leída, nil si ya no existe.
+func (s *service) reloadRoute(ctx context.Context, key, tipo string) (*Route, error) {
+	var active []string
+	route, err := s.repo.GetRoute(ctx, key, tipo)
+	if err == nil {
+		active = route.Active()
+	} else if errors.Is(err, ErrRouteNotFound) {
+		route = nil
+	} else {
+		serviceLog.WarnContext(ctx, "No se pudo recargar la ruta en caché", "error", err)
+		return nil, err
+	}
 	mapKey := routeMapKey(key, tipo)
-	s.routes[mapKey] = append(s.routes[mapKey], destino)
+	s.refreshMu.Lock()
+	if active == nil {
+		delete(s.routes, mapKey)
+	} else {
+		s.routes[mapKey] = active
+	}
+	if route != nil {
+		s.tipos[tipo] = true
+	}
+	cacheRoutes.Set(float64(len(s.routes)))
 	s.refreshMu.Unlock()
+	return route, nil
+}
+
+func (s *service) ListRoutes(ctx context.Context, tipo string) ([]Route, error) {
+	return s.repo.FindRoutes(ctx, tipo)
+}
+
+func (s *service) GetRoute(ctx context.Context, key, tipo string) (*Route, error) {
+	return s.repo.GetRoute(ctx, key, tipo)
+}
+
+func (s *service) RemoveDestino(ctx context.Context, key, tipo, destino string) error {
+	before, err := s.repo.GetRoute(ctx, key, tipo)
+	if err != nil {
+		return err
+	}
+	return s.mutate(ctx, AuditRemoveDestino, key, tipo, before, func() error {
+		return s.repo.RemoveDestino(ctx, key, tipo, destino)
+	})
+}
+
+func (s *service) DeleteRoute(ctx context.Context, key, tipo string) error {
+	before, err := s.repo.GetRoute(ctx, key, tipo)
+	if err != nil {
+		return err
+	}
+	return s.mutate(ctx, AuditDeleteRoute, key, tipo, before, func() error {
+		return s.repo.DeleteRoute(ctx, key, tipo)
+	})
+}
+
+func (s *service) ReplaceRoute(ctx context.Context, route Route) (*Route, error) {
+	before, err := s.repo.GetRoute(ctx, route.Key, route.Tipo)
+	if err != nil {
+		return nil, err
+	}
+	route = normalizeRoute(route)
+	// Una ruta del fichero de rutas sigue siéndolo aunque se edite a mano
+	if route.ManagedBy == "" {
+		route.ManagedBy = before.ManagedBy
+	}
+	var written *Route
+	err = s.mutate(ctx, AuditReplaceRoute, route.Key, route.Tipo, before, func() (err error) {
+		written, err = s.repo.ReplaceRoute(ctx, route)
+		return err
+	})
+	return written, err
+}
+
+// SetWeights sustituye los pesos de la ruta; los destinos que no aparecen vuelven a peso 1
+func (s *service) SetWeights(ctx context.Context, key, tipo string, weights map[string]int) error {
+	route, err := s.repo.GetRoute(ctx, key, tipo)
+	if err != nil {
+		return err
+	}
+	for d, w := range weights {
+		if !containsString(route.Destinos, d) {
+			return fmt.Errorf("%w: %s no es un destino de la ruta", ErrInvalidRoute, d)
+		}
+		if w < 0 || w > MaxDestinoWeight {
+			return fmt.Errorf("%w: el peso de %s debe estar entre 0 y %d", ErrInvalidRoute, d, MaxDestinoWeight)
+		}
+	}
+	list := make([]DestinoWeight, 0, len(weights))
+	for _, d := range route.Destinos {
+		if w, ok := weights[d]; ok && w != 1 {
+			list = append(list, DestinoWeight{Destino: d, Weight: w})
+		}
+	}
+	return s.mutate(ctx, AuditSetWeights, key, tipo, route, func() error {
+		return s.repo.SetWeights(ctx, key, tipo, list)
+	})
+}
+
+func (s *service) SetDrained(ctx context.Context, key, tipo, destino string, drained bool) error {
+	route, err := s.repo.GetRoute(ctx, key, tipo)
+	if err != nil {
+		return err
+	}
+	if !containsString(route.Destinos, destino) {
+		return fmt.Errorf("%w: %s no es un destino de la ruta", ErrInvalidRoute, destino)
+	}
+	return s.mutate(ctx, AuditSetDrained, key, tipo, route, func() error {
+		return s.repo.SetDrained(ctx, key, tipo, destino, drained)
+	})
+}
+
+func (s *service) PlanImport(ctx context.Context, routes []Route, mode ImportMode) ([]RouteChange, error) {
+	current, err := s.repo.FindRoutes(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return DiffRoutes(current, routes, mode == ImportReplace), nil
+}
+
+// ApplyImport escribe solo las rutas afectadas por changes y refresca la caché
+func (s *service) ApplyImport(ctx context.Context, routes []Route, changes []RouteChange) error {
+	ctx, span := tracer.Start(ctx, "service.ApplyImport", trace.WithAttributes(attribute.Int("changes.count", len(changes))))
+	var err error
+	defer func() { endSpan(span, err) }()
+	byKey := make(map[string]Route, len(routes))
+	for _, route := range routes {
+		byKey[routeMapKey(route.Key, route.Tipo)] = route
+	}
+	// Estado anterior de las rutas afectadas, para la auditoría
+	current, err := s.repo.FindRoutes(ctx, "")
+	if err != nil {
+		return err
+	}
+	before := make(map[string]*Route, len(current))
+	for i := range current {
+		before[routeMapKey(current[i].Key, current[i].Tipo)] = &current[i]
+	}
+	var save, remove []Route
+	entries := make([]AuditEntry, 0, len(changes))
+	for _, c := range changes {
+		mapKey := routeMapKey(c.Key, c.Tipo)
+		var after *Route
+		if c.Action == ChangeRemoved {
+			remove = append(remove, Route{Key: c.Key, Tipo: c.Tipo})
+		} else {
+			route := normalizeRoute(byKey[mapKey])
+			save = append(save, route)
+			after = &route
+		}
+		entries = append(entries, newAuditEntry(ctx, AuditImport, c.Key, c.Tipo, before[mapKey], after))
+	}
+	if err = s.repo.ApplyRoutes(ctx, save, remove); err != nil {
+		serviceLog.ErrorContext(ctx, "Error aplicando la importación de rutas", "error", err)
+		return err
+	}
+	for _, entry := range entries {
+		s.record(ctx, entry)
+	}
+	s.RefreshRoutes(ctx)
 	return nil
 }
+
+// QueryAudit consulta la auditoría; sin AuditLog devuelve una lista vacía
+func (s *service) QueryAudit(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	if s.audit == nil {
+		return []AuditEntry{}, nil
+	}
+	return s.audit.Query(ctx, q)
+}
+
+// ListVersions devuelve el historial; una ruta anterior al versionado tiene un
+// historial vacío
+func (s *service) ListVersions(ctx context.Context, key, tipo string) ([]RouteVersion, error) {
+	versions, err := s.repo.ListVersions(ctx, key, tipo)
+	if err != nil || len(versions) > 0 {
+		return versions, err
+	}
+	if _, err := s.repo.GetRoute(ctx, key, tipo); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (s *service) GetVersion(ctx context.Context, key, tipo string, revision int64) (*RouteVersion, error) {
+	return s.repo.GetVersion(ctx, key, tipo, revision)
+}
+
+func (s *service) DiffVersions(ctx context.Context, key, tipo string, from, to int64) (*VersionDiff, error) {
+	a, err := s.repo.GetVersion(ctx, key, tipo, from)
+	if err != nil {
+		return nil, fmt.Errorf("revisión %d: %w", from, err)
+	}
+	b, err := s.repo.GetVersion(ctx, key, tipo, to)
+	if err != nil {
+		return nil, fmt.Errorf("revisión %d: %w", to, err)
+	}
+	var current, desired []Route
+	if !a.Deleted {
+		current = []Route{a.Route}
+	}
+	if !b.Deleted {
+		desired = []Route{b.Route}
+	}
+	changes := DiffRoutes(current, desired, true)
+	if changes == nil {
+		changes = []RouteChange{}
+	}
+	return &VersionDiff{From: a, To: b, Changes: changes}, nil
+}
+
+// Rollback deja la ruta como estaba en revision. La vuelta atrás es una
+// escritura más: crea una revisión nueva y recrea la ruta si se había borrado.
+func (s *service) Rollback(ctx context.Context, key, tipo string, revision int64) (*Route, error) {
+	v, err := s.repo.GetVersion(ctx, key, tipo, revision)
+	if err != nil {
+		return nil, err
+	}
+	if v.Deleted {
+		return nil, fmt.Errorf("%w: la revisión %d es un borrado, usa DELETE", ErrInvalidRoute, revision)
+	}
+	before, err := s.repo.GetRoute(ctx, key, tipo)
+	if err != nil && !errors.Is(err, ErrRouteNotFound) {
+		return nil, err
+	}
+	target := v.Route
+	target.Revision = 0
+	var written *Route
+	err = s.mutate(ctx, AuditRollback, key, tipo, before, func() (err error) {
+		written, err = s.repo.ReplaceRoute(ctx, target)
+		return err
+	})
+	return written, err
+}
+
+func (s *service) Health(ctx context.Context) Health {
+	h := Health{Status: "ok", Mongo: "ok"}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := s.repo.Ping(ctx); err != nil {
+		h.Status = "degraded"
+		h.Mongo = logging.RedactCredentials(err.Error())
+	}
+	s.refreshMu.RLock()
+	h.CachedRoutes = len(s.routes)
+	s.refreshMu.RUnlock()
+	if ns := s.lastRefresh.Load(); ns > 0 {
+		h.LastRefresh = time.Unix(0, ns).UTC()
+	}
+	return h
+}
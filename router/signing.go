@@ -0,0 +1,196 @@
+package router
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"router-app/config"
+)
+
+// Cabeceras del esquema de firma HMAC
+const (
+	HeaderSignature          = "X-Signature"
+	HeaderSignatureTimestamp = "X-Signature-Timestamp"
+	HeaderSignatureNonce     = "X-Signature-Nonce"
+)
+
+// canonicalRequest construye la cadena firmada: método, ruta, timestamp, nonce y hash del cuerpo
+func canonicalRequest(method, path, timestamp, nonce string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	return []byte(method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(sum[:]))
+}
+
+func computeSignature(secret, canonical []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(canonical)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest firma una solicitud saliente con el secreto compartido. Es el
+// ayudante de cliente para llamar a los endpoints protegidos por SignatureMiddleware.
+func SignRequest(req *http.Request, secret []byte) error {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	n := hex.EncodeToString(nonce)
+	req.Header.Set(HeaderSignatureTimestamp, ts)
+	req.Header.Set(HeaderSignatureNonce, n)
+	req.Header.Set(HeaderSignature, computeSignature(secret, canonicalRequest(req.Method, req.URL.RequestURI(), ts, n, body)))
+	return nil
+}
+
+// SignatureVerifier valida firmas HMAC y rechaza timestamps caducados y nonces repetidos
+type SignatureVerifier struct {
+	mu      sync.RWMutex
+	secret  []byte
+	maxSkew time.Duration
+	maxBody int64
+	nonces  *nonceCache
+	now     func() time.Time
+}
+
+func NewSignatureVerifier(secret []byte, maxSkew time.Duration, maxBody int64) *SignatureVerifier {
+	return &SignatureVerifier{
+		secret:  secret,
+		maxSkew: maxSkew,
+		maxBody: maxBody,
+		nonces:  &nonceCache{seen: make(map[string]time.Time)},
+		now:     time.Now,
+	}
+}
+
+// SignatureBodyLimit es el cuerpo máximo que lee SignatureMiddleware: las
+// importaciones de rutas admiten cuerpos mayores que MAX_BODY_SIZE
+func SignatureBodyLimit(cfg *config.Config) int64 {
+	return int64(max(cfg.MaxBodySize, cfg.MaxImportSize))
+}
+
+// SetSecret rota el secreto compartido
+func (v *SignatureVerifier) SetSecret(secret []byte) {
+	v.mu.Lock()
+	v.secret = secret
+	v.mu.Unlock()
+}
+
+// SetMaxBody cambia el cuerpo máximo que se lee para verificar la firma
+func (v *SignatureVerifier) SetMaxBody(n int64) {
+	v.mu.Lock()
+	v.maxBody = n
+	v.mu.Unlock()
+}
+
+func (v *SignatureVerifier) bodyLimit() int64 {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.maxBody
+}
+
+// Verify comprueba la firma de la solicitud sobre el cuerpo ya leído
+func (v *SignatureVerifier) Verify(r *http.Request, body []byte) error {
+	ts := r.Header.Get(HeaderSignatureTimestamp)
+	nonce := r.Header.Get(HeaderSignatureNonce)
+	sig := r.Header.Get(HeaderSignature)
+	if ts == "" || nonce == "" || sig == "" {
+		return errors.New("solicitud sin firmar")
+	}
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("timestamp de firma inválido")
+	}
+	now := v.now()
+	signedAt := time.Unix(secs, 0)
+	if signedAt.Before(now.Add(-v.maxSkew)) || signedAt.After(now.Add(v.maxSkew)) {
+		return fmt.Errorf("timestamp de firma fuera de ventana (%s)", signedAt.UTC().Format(time.RFC3339))
+	}
+	v.mu.RLock()
+	secret := v.secret
+	v.mu.RUnlock()
+	expected := computeSignature(secret, canonicalRequest(r.Method, r.URL.RequestURI(), ts, nonce, body))
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return errors.New("firma inválida")
+	}
+	// El nonce solo se registra con firma válida, para que un atacante no pueda envenenar la caché
+	if !v.nonces.add(nonce, now, signedAt.Add(v.maxSkew)) {
+		return errors.New("nonce repetido")
+	}
+	return nil
+}
+
+// nonceCache recuerda los nonces usados hasta que su timestamp sale de la ventana válida
+type nonceCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+func (c *nonceCache) add(nonce string, now, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastSweep) > time.Minute {
+		for n, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, n)
+			}
+		}
+		c.lastSweep = now
+	}
+	if exp, ok := c.seen[nonce]; ok && !now.After(exp) {
+		return false
+	}
+	c.seen[nonce] = expires
+	return true
+}
+
+func isMutation(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// SignatureMiddleware exige firma HMAC válida en los métodos que modifican estado
+func SignatureMiddleware(v *SignatureVerifier, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isMutation(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, v.bodyLimit()))
+		if err != nil {
+			countRejected("signature", http.StatusBadRequest)
+			http.Error(w, "Payload inválido", http.StatusBadRequest)
+			return
+		}
+		if err := v.Verify(r, body); err != nil {
+			authLog.WarnContext(r.Context(), "Firma rechazada", "ip", ClientIP(r), "path", r.URL.Path, "error", err)
+			countRejected("signature", http.StatusUnauthorized)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,263 @@
+package router
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var signingSecret = []byte("secreto-compartido")
+
+// signedRequest firma una solicitud con el timestamp y el nonce indicados
+func signedRequest(method, target, body string, signedAt time.Time, nonce string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	ts := strconv.FormatInt(signedAt.Unix(), 10)
+	r.Header.Set(HeaderSignatureTimestamp, ts)
+	r.Header.Set(HeaderSignatureNonce, nonce)
+	r.Header.Set(HeaderSignature, computeSignature(signingSecret, canonicalRequest(method, r.URL.RequestURI(), ts, nonce, []byte(body))))
+	return r
+}
+
+func newTestVerifier(now time.Time) *SignatureVerifier {
+	v := NewSignatureVerifier(signingSecret, 5*time.Minute, 1<<20)
+	v.now = func() time.Time { return now }
+	return v
+}
+
+func TestSignatureVerify(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	body := `{"destino": "http://a"}`
+	tests := []struct {
+		name    string
+		req     func() *http.Request
+		body    string
+		wantErr string
+	}{
+		{
+			name: "firma válida",
+			req:  func() *http.Request { return signedRequest("POST", "/add-destino/payments/c1", body, now, "n1") },
+			body: body,
+		},
+		{
+			name: "en el borde de la ventana",
+			req: func() *http.Request {
+				return signedRequest("POST", "/add-destino/payments/c1", body, now.Add(-5*time.Minute), "n1")
+			},
+			body: body,
+		},
+		{
+			name: "timestamp caducado",
+			req: func() *http.Request {
+				return signedRequest("POST", "/add-destino/payments/c1", body, now.Add(-6*time.Minute), "n1")
+			},
+			body:    body,
+			wantErr: "fuera de ventana",
+		},
+		{
+			name: "timestamp futuro",
+			req: func() *http.Request {
+				return signedRequest("POST", "/add-destino/payments/c1", body, now.Add(6*time.Minute), "n1")
+			},
+			body:    body,
+			wantErr: "fuera de ventana",
+		},
+		{
+			name:    "cuerpo manipulado",
+			req:     func() *http.Request { return signedRequest("POST", "/add-destino/payments/c1", body, now, "n1") },
+			body:    `{"destino": "http://evil"}`,
+			wantErr: "firma inválida",
+		},
+		{
+			name: "ruta manipulada",
+			req: func() *http.Request {
+				r := signedRequest("POST", "/add-destino/payments/c1", body, now, "n1")
+				r.URL.Path = "/add-destino/search/c1"
+				return r
+			},
+			body:    body,
+			wantErr: "firma inválida",
+		},
+		{
+			name: "query manipulada",
+			req: func() *http.Request {
+				r := signedRequest("POST", "/admin/routes/import?mode=merge", body, now, "n1")
+				r.URL.RawQuery = "mode=replace"
+				return r
+			},
+			body:    body,
+			wantErr: "firma inválida",
+		},
+		{
+			name: "método manipulado",
+			req: func() *http.Request {
+				r := signedRequest("POST", "/admin/routes/payments/c1", body, now, "n1")
+				r.Method = http.MethodDelete
+				return r
+			},
+			body:    body,
+			wantErr: "firma inválida",
+		},
+		{
+			name: "otro secreto",
+			req: func() *http.Request {
+				r := signedRequest("POST", "/add-destino/payments/c1", body, now, "n1")
+				ts := r.Header.Get(HeaderSignatureTimestamp)
+				r.Header.Set(HeaderSignature, computeSignature([]byte("otro"), canonicalRequest("POST", "/add-destino/payments/c1", ts, "n1", []byte(body))))
+				return r
+			},
+			body:    body,
+			wantErr: "firma inválida",
+		},
+		{
+			name: "timestamp no numérico",
+			req: func() *http.Request {
+				r := signedRequest("POST", "/add-destino/payments/c1", body, now, "n1")
+				r.Header.Set(HeaderSignatureTimestamp, "ayer")
+				return r
+			},
+			body:    body,
+			wantErr: "timestamp de firma inválido",
+		},
+	}
+	for _, h := range []string{HeaderSignature, HeaderSignatureTimestamp, HeaderSignatureNonce} {
+		h := h
+		tests = append(tests, struct {
+			name    string
+			req     func() *http.Request
+			body    string
+			wantErr string
+		}{
+			name: "sin " + h,
+			req: func() *http.Request {
+				r := signedRequest("POST", "/add-destino/payments/c1", body, now, "n1")
+				r.Header.Del(h)
+				return r
+			},
+			body:    body,
+			wantErr: "solicitud sin firmar",
+		})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newTestVerifier(now).Verify(tt.req(), []byte(tt.body))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Verify() = %v, se esperaba nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Verify() = %v, se esperaba un error con %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignatureRejectsReplayedNonce(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	v := newTestVerifier(now)
+	body := `{"destino": "http://a"}`
+	if err := v.Verify(signedRequest("POST", "/add-destino/payments/c1", body, now, "n1"), []byte(body)); err != nil {
+		t.Fatalf("primera solicitud: %v", err)
+	}
+	if err := v.Verify(signedRequest("POST", "/add-destino/payments/c1", body, now, "n1"), []byte(body)); err == nil || !strings.Contains(err.Error(), "nonce repetido") {
+		t.Errorf("reenvío = %v, se esperaba nonce repetido", err)
+	}
+	// Un nonce con firma inválida no se registra y no bloquea al cliente legítimo
+	bad := signedRequest("POST", "/add-destino/payments/c1", body, now, "n2")
+	bad.Header.Set(HeaderSignature, "00")
+	if err := v.Verify(bad, []byte(body)); err == nil {
+		t.Fatal("se esperaba firma inválida")
+	}
+	if err := v.Verify(signedRequest("POST", "/add-destino/payments/c1", body, now, "n2"), []byte(body)); err != nil {
+		t.Errorf("el nonce de una firma inválida quedó registrado: %v", err)
+	}
+	// Pasada la ventana el nonce se olvida, pero el timestamp ya no sería válido
+	v.now = func() time.Time { return now.Add(10 * time.Minute) }
+	if err := v.Verify(signedRequest("POST", "/add-destino/payments/c1", body, now.Add(10*time.Minute), "n1"), []byte(body)); err != nil {
+		t.Errorf("nonce reutilizado fuera de la ventana: %v", err)
+	}
+}
+
+func TestSignRequestThroughMiddleware(t *testing.T) {
+	var got string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		got = string(data)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(SignatureMiddleware(NewSignatureVerifier(signingSecret, time.Minute, 1<<20), next))
+	defer srv.Close()
+
+	send := func(req *http.Request) int {
+		t.Helper()
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	body := `{"destino": "http://a"}`
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/add-destino/payments/c1?x=1", strings.NewReader(body))
+	if err := SignRequest(req, signingSecret); err != nil {
+		t.Fatal(err)
+	}
+	replay := req.Clone(req.Context())
+	if code := send(req); code != http.StatusNoContent {
+		t.Fatalf("solicitud firmada = %d, se esperaba 204", code)
+	}
+	if got != body {
+		t.Errorf("el siguiente handler recibió %q, se esperaba el cuerpo original", got)
+	}
+
+	replay.Body = io.NopCloser(strings.NewReader(body))
+	if code := send(replay); code != http.StatusUnauthorized {
+		t.Errorf("reenvío = %d, se esperaba 401", code)
+	}
+
+	unsigned, _ := http.NewRequest(http.MethodPost, srv.URL+"/add-destino/payments/c1", strings.NewReader(body))
+	if code := send(unsigned); code != http.StatusUnauthorized {
+		t.Errorf("sin firma = %d, se esperaba 401", code)
+	}
+
+	tampered, _ := http.NewRequest(http.MethodPost, srv.URL+"/add-destino/payments/c1", strings.NewReader(body))
+	if err := SignRequest(tampered, signingSecret); err != nil {
+		t.Fatal(err)
+	}
+	tampered.Body = io.NopCloser(strings.NewReader(`{"destino": "http://evil"}`))
+	tampered.ContentLength = -1
+	if code := send(tampered); code != http.StatusUnauthorized {
+		t.Errorf("cuerpo manipulado = %d, se esperaba 401", code)
+	}
+
+	wrong, _ := http.NewRequest(http.MethodPost, srv.URL+"/add-destino/payments/c1", strings.NewReader(body))
+	if err := SignRequest(wrong, []byte("otro")); err != nil {
+		t.Fatal(err)
+	}
+	if code := send(wrong); code != http.StatusUnauthorized {
+		t.Errorf("otro secreto = %d, se esperaba 401", code)
+	}
+
+	// Las lecturas no necesitan firma
+	get, _ := http.NewRequest(http.MethodGet, srv.URL+"/route/payments/c1", nil)
+	if code := send(get); code != http.StatusNoContent {
+		t.Errorf("GET sin firma = %d, se esperaba 204", code)
+	}
+}
+
+func TestSignatureMiddlewareBodyLimit(t *testing.T) {
+	v := NewSignatureVerifier(signingSecret, time.Minute, 8)
+	h := SignatureMiddleware(v, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodPost, "/add-destino/payments/c1", strings.NewReader(`{"destino": "http://a"}`))
+	if err := SignRequest(req, signingSecret); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("cuerpo mayor que el límite = %d, se esperaba 400", w.Code)
+	}
+}
@@ -0,0 +1,194 @@
+package router
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLSOptions agrupa los parámetros del listener TLS
+type TLSOptions struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	// ClientAuth: "none", "request" (verifica si el cliente la presenta) o "require"
+	ClientAuth string
+}
+
+// CertReloader sirve el certificado del servidor y lo relee cuando cambian los ficheros
+type CertReloader struct {
+	certFile  string
+	keyFile   string
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+const certCheckInterval = time.Second
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	cr := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (cr *CertReloader) latestModTime() (time.Time, error) {
+	ci, err := os.Stat(cr.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ki, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if ki.ModTime().After(ci.ModTime()) {
+		return ki.ModTime(), nil
+	}
+	return ci.ModTime(), nil
+}
+
+func (cr *CertReloader) reload() error {
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		return fmt.Errorf("no se pudo leer el certificado TLS: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("certificado TLS inválido: %w", err)
+	}
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.modTime = modTime
+	cr.lastCheck = time.Now()
+	cr.mu.Unlock()
+	authLog.Info("Certificado TLS cargado", "path", cr.certFile)
+	return nil
+}
+
+// GetCertificate implementa tls.Config.GetCertificate, recargando el par si ha rotado
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	due := time.Since(cr.lastCheck) >= certCheckInterval
+	if due {
+		cr.lastCheck = time.Now()
+	}
+	modTime := cr.modTime
+	cr.mu.Unlock()
+	if due {
+		if latest, err := cr.latestModTime(); err == nil && !latest.Equal(modTime) {
+			if err := cr.reload(); err != nil {
+				authLog.Error("Error recargando certificado TLS, se mantiene el anterior", "error", err)
+			}
+		}
+	}
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// NewServerTLSConfig construye la configuración TLS del servidor, con verificación
+// opcional de certificados de cliente contra el bundle de CA indicado
+func NewServerTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	reloader, err := NewCertReloader(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if opts.ClientCAFile == "" || opts.ClientAuth == "none" {
+		return cfg, nil
+	}
+	pem, err := os.ReadFile(opts.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo leer el bundle de CA %s: %w", opts.ClientCAFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("el bundle de CA %s no contiene certificados", opts.ClientCAFile)
+	}
+	cfg.ClientCAs = pool
+	switch opts.ClientAuth {
+	case "request":
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case "", "require":
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("modo de autenticación de cliente TLS desconocido: %s", opts.ClientAuth)
+	}
+	return cfg, nil
+}
+
+type certAuthenticator struct {
+	scopes       map[string]string
+	defaultScope string
+}
+
+// NewCertAuthenticator identifica al llamador por su certificado de cliente verificado.
+// scopes asocia CN o SAN (DNS, URI, email) con un scope; el resto recibe defaultScope.
+func NewCertAuthenticator(scopes map[string]string, defaultScope string) Authenticator {
+	return &certAuthenticator{scopes: scopes, defaultScope: defaultScope}
+}
+
+func (a *certAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+	names := certNames(r.TLS.VerifiedChains[0][0])
+	if len(names) == 0 {
+		return nil, errors.New("certificado de cliente sin CN ni SAN")
+	}
+	id := &Identity{Subject: names[0], Scheme: "mtls"}
+	scope := a.defaultScope
+	for _, n := range names {
+		if s, ok := a.scopes[n]; ok {
+			scope = s
+			break
+		}
+	}
+	if scope != "" {
+		id.Scopes = []string{scope}
+	}
+	return id, nil
+}
+
+// certNames devuelve los nombres del certificado por orden de preferencia: URI, DNS, email, CN
+func certNames(cert *x509.Certificate) []string {
+	var names []string
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	return names
+}
+
+// ParseCertScopes interpreta "nombre=scope,nombre2=scope" tal como llega de la configuración
+func ParseCertScopes(spec string) (map[string]string, error) {
+	scopes := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, scope, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || (scope != ScopeRead && scope != ScopeAdmin) {
+			return nil, fmt.Errorf("entrada de scopes de certificado inválida: '%s'", entry)
+		}
+		scopes[name] = scope
+	}
+	return scopes, nil
+}
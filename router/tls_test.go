@@ -0,0 +1,362 @@
+package router
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testCA es una CA desechable que firma certificados de servidor y de cliente
+type testCA struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	serial int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "router-app test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, serial: 1}
+}
+
+// issue firma un certificado hoja; tmpl indica nombres y usos
+func (ca *testCA) issue(t *testing.T, tmpl *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.serial++
+	tmpl.SerialNumber = big.NewInt(ca.serial)
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func (ca *testCA) serverCert(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	return ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+}
+
+func (ca *testCA) clientCert(t *testing.T, tmpl *x509.Certificate) tls.Certificate {
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	cert, key := ca.issue(t, tmpl)
+	return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key, Leaf: cert}
+}
+
+// writeKeyPair escribe el par en PEM y fija su fecha de modificación, que es lo
+// que CertReloader compara para detectar la rotación
+func writeKeyPair(t *testing.T, certFile, keyFile string, cert *x509.Certificate, key *ecdsa.PrivateKey, modTime time.Time) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, certFile, "CERTIFICATE", cert.Raw)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Chtimes(f, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func servedSerial(t *testing.T, cr *CertReloader) int64 {
+	t.Helper()
+	cert, err := cr.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+// expireCheck hace que la siguiente llamada a GetCertificate mire los ficheros
+// sin esperar certCheckInterval
+func expireCheck(cr *CertReloader) {
+	cr.mu.Lock()
+	cr.lastCheck = time.Time{}
+	cr.mu.Unlock()
+}
+
+func TestCertReloaderPicksUpRotation(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	start := time.Now().Add(-time.Hour)
+
+	first, firstKey := ca.serverCert(t)
+	writeKeyPair(t, certFile, keyFile, first, firstKey, start)
+	cr, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := servedSerial(t, cr); got != first.SerialNumber.Int64() {
+		t.Fatalf("se sirve el serial %d, se esperaba %d", got, first.SerialNumber.Int64())
+	}
+
+	second, secondKey := ca.serverCert(t)
+	writeKeyPair(t, certFile, keyFile, second, secondKey, start.Add(time.Minute))
+	// Dentro del intervalo de comprobación se sigue sirviendo el certificado en memoria
+	if got := servedSerial(t, cr); got != first.SerialNumber.Int64() {
+		t.Errorf("se recargó antes de certCheckInterval: serial %d", got)
+	}
+	expireCheck(cr)
+	if got := servedSerial(t, cr); got != second.SerialNumber.Int64() {
+		t.Errorf("tras rotar se sirve el serial %d, se esperaba %d", got, second.SerialNumber.Int64())
+	}
+
+	// Un par roto a mitad de rotación no sustituye al certificado válido
+	writePEM(t, keyFile, "EC PRIVATE KEY", []byte("basura"))
+	if err := os.Chtimes(keyFile, start.Add(2*time.Minute), start.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	expireCheck(cr)
+	if got := servedSerial(t, cr); got != second.SerialNumber.Int64() {
+		t.Errorf("con la clave rota se sirve el serial %d, se esperaba mantener %d", got, second.SerialNumber.Int64())
+	}
+
+	// Al completarse la rotación se carga el nuevo par
+	third, thirdKey := ca.serverCert(t)
+	writeKeyPair(t, certFile, keyFile, third, thirdKey, start.Add(3*time.Minute))
+	expireCheck(cr)
+	if got := servedSerial(t, cr); got != third.SerialNumber.Int64() {
+		t.Errorf("tras completar la rotación se sirve el serial %d, se esperaba %d", got, third.SerialNumber.Int64())
+	}
+}
+
+func TestNewCertReloaderRejectsInvalidPair(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if _, err := NewCertReloader(certFile, keyFile); err == nil {
+		t.Error("sin ficheros NewCertReloader debería fallar")
+	}
+	cert, _ := ca.serverCert(t)
+	_, otherKey := ca.serverCert(t)
+	writeKeyPair(t, certFile, keyFile, cert, otherKey, time.Now())
+	if _, err := NewCertReloader(certFile, keyFile); err == nil {
+		t.Error("con una clave que no corresponde al certificado NewCertReloader debería fallar")
+	}
+}
+
+func TestCertAuthenticatorScopes(t *testing.T) {
+	ca := newTestCA(t)
+	scopes := map[string]string{
+		"spiffe://example.com/deployer": ScopeAdmin,
+		"ops.example.com":               ScopeAdmin,
+		"reader":                        ScopeRead,
+	}
+	spiffe, _ := url.Parse("spiffe://example.com/deployer")
+	tests := []struct {
+		name         string
+		tmpl         *x509.Certificate
+		defaultScope string
+		subject      string
+		scopes       []string
+	}{
+		{
+			name:    "por CN",
+			tmpl:    &x509.Certificate{Subject: pkix.Name{CommonName: "reader"}},
+			subject: "reader", scopes: []string{ScopeRead},
+		},
+		{
+			name:    "por SAN DNS",
+			tmpl:    &x509.Certificate{Subject: pkix.Name{CommonName: "reader"}, DNSNames: []string{"ops.example.com"}},
+			subject: "ops.example.com", scopes: []string{ScopeAdmin},
+		},
+		{
+			name:    "el SAN URI tiene preferencia",
+			tmpl:    &x509.Certificate{URIs: []*url.URL{spiffe}, DNSNames: []string{"otro.example.com"}},
+			subject: "spiffe://example.com/deployer", scopes: []string{ScopeAdmin},
+		},
+		{
+			name:         "sin mapeo recibe el scope por defecto",
+			tmpl:         &x509.Certificate{Subject: pkix.Name{CommonName: "desconocido"}},
+			defaultScope: ScopeRead,
+			subject:      "desconocido", scopes: []string{ScopeRead},
+		},
+		{
+			name:    "sin mapeo ni scope por defecto",
+			tmpl:    &x509.Certificate{EmailAddresses: []string{"ana@example.com"}},
+			subject: "ana@example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leaf := ca.clientCert(t, tt.tmpl).Leaf
+			r := httptest.NewRequest(http.MethodGet, "/routes", nil)
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, ca.cert}}}
+			id, err := NewCertAuthenticator(scopes, tt.defaultScope).Authenticate(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id.Subject != tt.subject || id.Scheme != "mtls" || !slices.Equal(id.Scopes, tt.scopes) {
+				t.Errorf("identidad = %+v, se esperaba subject %q con scopes %v", id, tt.subject, tt.scopes)
+			}
+		})
+	}
+}
+
+func TestCertAuthenticatorWithoutVerifiedChain(t *testing.T) {
+	a := NewCertAuthenticator(nil, ScopeRead)
+	r := httptest.NewRequest(http.MethodGet, "/routes", nil)
+	if _, err := a.Authenticate(r); err != ErrNoCredentials {
+		t.Errorf("sin TLS: error = %v, se esperaba ErrNoCredentials", err)
+	}
+	// Un certificado presentado pero no verificado (modo request) no identifica
+	r.TLS = &tls.ConnectionState{}
+	if _, err := a.Authenticate(r); err != ErrNoCredentials {
+		t.Errorf("sin cadena verificada: error = %v, se esperaba ErrNoCredentials", err)
+	}
+}
+
+// TestServerTLSClientCertificates hace el handshake real con la configuración de
+// NewServerTLSConfig y comprueba la identidad que llega al handler
+func TestServerTLSClientCertificates(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.pem")
+	serverCert, serverKey := ca.serverCert(t)
+	writeKeyPair(t, certFile, keyFile, serverCert, serverKey, time.Now())
+	writePEM(t, caFile, "CERTIFICATE", ca.cert.Raw)
+
+	auth := NewCertAuthenticator(map[string]string{"deployer": ScopeAdmin}, ScopeRead)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := auth.Authenticate(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, "%s %v", id.Subject, id.Scopes)
+	})
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	deployer := ca.clientCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "deployer"}})
+	other := newTestCA(t).clientCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "deployer"}})
+
+	tests := []struct {
+		name   string
+		mode   string
+		client []tls.Certificate
+		want   string // cuerpo esperado; vacío si el handshake debe fallar
+		status int
+	}{
+		{name: "require con certificado de la CA", mode: "require", client: []tls.Certificate{deployer}, want: "deployer [admin]", status: http.StatusOK},
+		{name: "require sin certificado", mode: "require"},
+		{name: "require con certificado de otra CA", mode: "require", client: []tls.Certificate{other}},
+		{name: "request con certificado de la CA", mode: "request", client: []tls.Certificate{deployer}, want: "deployer [admin]", status: http.StatusOK},
+		{name: "request sin certificado", mode: "request", want: ErrNoCredentials.Error() + "\n", status: http.StatusUnauthorized},
+		{name: "request con certificado de otra CA", mode: "request", client: []tls.Certificate{other}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := NewServerTLSConfig(TLSOptions{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: tt.mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := httptest.NewUnstartedServer(handler)
+			srv.TLS = cfg
+			// Los handshakes rechazados son parte del test, no hace falta registrarlos
+			srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+			srv.StartTLS()
+			defer srv.Close()
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				RootCAs:      roots,
+				ServerName:   "localhost",
+				Certificates: tt.client,
+			}}}
+			resp, err := client.Get(srv.URL)
+			if tt.want == "" {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("se esperaba un fallo de handshake, status %d", resp.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body := make([]byte, 256)
+			n, _ := resp.Body.Read(body)
+			if resp.StatusCode != tt.status || string(body[:n]) != tt.want {
+				t.Errorf("respuesta %d %q, se esperaba %d %q", resp.StatusCode, body[:n], tt.status, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCertScopes(t *testing.T) {
+	got, err := ParseCertScopes(" deployer=admin, spiffe://example.com/ci=read ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got["deployer"] != ScopeAdmin || got["spiffe://example.com/ci"] != ScopeRead {
+		t.Errorf("ParseCertScopes() = %v", got)
+	}
+	for _, spec := range []string{"deployer", "=admin", "deployer=root"} {
+		if _, err := ParseCertScopes(spec); err == nil {
+			t.Errorf("ParseCertScopes(%q) debería fallar", spec)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer se resuelve contra el TracerProvider global en cada span, así que puede
+// declararse antes de que main configure el exportador
+var tracer = otel.Tracer("router-app/router")
+
+// routeName agrupa las rutas por su prefijo para no crear un nombre de span por key
+func routeName(path string) string {
+	trimmed := strings.TrimPrefix(path, "/")
+	if i := strings.Index(trimmed, "/"); i >= 0 {
+		return "/" + trimmed[:i+1] + "*"
+	}
+	return path
+}
+
+// TracingMiddleware abre el span de servidor de cada solicitud, continuando la
+// traza del traceparent entrante si lo hay
+func TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		route := routeName(r.URL.Path)
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", ClientIP(r)),
+				attribute.String("request.id", RequestIDFromContext(ctx)),
+			),
+		)
+		defer span.End()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.Status()))
+		if rec.Status() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.Status()))
+		}
+	})
+}
+
+// startMongoSpan abre un span de cliente para una operación sobre MongoDB
+func startMongoSpan(ctx context.Context, operation, collection string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "mongo."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "mongodb"),
+			attribute.String("db.operation", operation),
+			attribute.String("db.mongodb.collection", collection),
+		),
+	)
+}
+
+// endSpan registra el error (si lo hay) y cierra el span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// lockWait anota en el span cuánto se esperó por un mutex, para distinguir la
+// contención de la latencia de MongoDB
+func lockWait(span trace.Span, lock string, start time.Time) {
+	span.SetAttributes(attribute.Float64("lock."+lock+".wait_ms", float64(time.Since(start).Microseconds())/1000))
+}
@@ -0,0 +1,187 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"router-app/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedRepo abre los mismos spans de cliente que el repositorio de Mongo
+type tracedRepo struct {
+	*memRepo
+}
+
+func (r tracedRepo) GetRoute(ctx context.Context, key, tipo string) (*Route, error) {
+	ctx, span := startMongoSpan(ctx, "find_one", "routes")
+	route, err := r.memRepo.GetRoute(ctx, key, tipo)
+	endSpan(span, err)
+	return route, err
+}
+
+func (r tracedRepo) SaveRoute(ctx context.Context, key, tipo, destino string) error {
+	ctx, span := startMongoSpan(ctx, "find_one_and_update", "routes")
+	err := r.memRepo.SaveRoute(ctx, key, tipo, destino)
+	endSpan(span, err)
+	return err
+}
+
+var (
+	testTracerOnce     sync.Once
+	testTracerExporter *tracetest.InMemoryExporter
+)
+
+// useTestTracer instala un TracerProvider que guarda los spans en memoria. El
+// proveedor global solo delega en el primero que se instala, así que todos los
+// tests comparten uno y cada uno empieza con el exportador vacío.
+func useTestTracer(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	testTracerOnce.Do(func() {
+		testTracerExporter = tracetest.NewInMemoryExporter()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(testTracerExporter)))
+	})
+	prev := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	testTracerExporter.Reset()
+	t.Cleanup(func() {
+		otel.SetTextMapPropagator(prev)
+		testTracerExporter.Reset()
+	})
+	return testTracerExporter
+}
+
+func spanAttr(s tracetest.SpanStub, key string) (attribute.Value, bool) {
+	for _, kv := range s.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracingMiddlewareSpans(t *testing.T) {
+	exporter := useTestTracer(t)
+	cfg := config.Default()
+	repo := tracedRepo{newMemRepo(Route{Key: "c1", Tipo: "payments", Destinos: []string{"http://a"}})}
+	h := NewHandler(NewService(repo, nil, cfg), cfg)
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	handler := RequestIDMiddleware(TracingMiddleware(mux))
+	exporter.Reset()
+
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	r := httptest.NewRequest(http.MethodPost, "/add-destino/payments/c1", strings.NewReader(`{"destino": "http://b"}`))
+	r.Header.Set("traceparent", "00-"+traceID+"-"+spanID+"-01")
+	r.RemoteAddr = "203.0.113.7:5000"
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST = %d, se esperaba 200", w.Code)
+	}
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, s := range exporter.GetSpans() {
+		if _, dup := spans[s.Name]; !dup {
+			spans[s.Name] = s
+		}
+	}
+	server, ok := spans["POST /add-destino/*"]
+	if !ok {
+		t.Fatalf("no hay span de servidor; spans: %v", spanNames(exporter.GetSpans()))
+	}
+	if server.SpanKind != trace.SpanKindServer {
+		t.Errorf("el span de servidor es de tipo %s", server.SpanKind)
+	}
+	if server.Parent.TraceID().String() != traceID || server.Parent.SpanID().String() != spanID || !server.Parent.IsRemote() {
+		t.Errorf("padre del span de servidor = %s/%s, se esperaba el del traceparent", server.Parent.TraceID(), server.Parent.SpanID())
+	}
+	for key, want := range map[string]string{
+		"http.request.method": "POST",
+		"http.route":          "/add-destino/*",
+		"url.path":            "/add-destino/payments/c1",
+		"client.address":      "203.0.113.7",
+	} {
+		if v, ok := spanAttr(server, key); !ok || v.AsString() != want {
+			t.Errorf("atributo %s del span de servidor = %q, se esperaba %q", key, v.Emit(), want)
+		}
+	}
+	if v, ok := spanAttr(server, "request.id"); !ok || v.AsString() == "" || v.AsString() != w.Header().Get("X-Request-ID") {
+		t.Errorf("request.id = %q, se esperaba el de la respuesta %q", v.Emit(), w.Header().Get("X-Request-ID"))
+	}
+	if v, ok := spanAttr(server, "http.response.status_code"); !ok || v.AsInt64() != http.StatusOK {
+		t.Errorf("http.response.status_code = %s", v.Emit())
+	}
+
+	service, ok := spans["service.AddDestino"]
+	if !ok {
+		t.Fatalf("no hay span de servicio; spans: %v", spanNames(exporter.GetSpans()))
+	}
+	if service.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Error("el span de servicio no es hijo del de servidor")
+	}
+	for key, want := range map[string]string{"route.tipo": "payments", "route.key": "c1"} {
+		if v, ok := spanAttr(service, key); !ok || v.AsString() != want {
+			t.Errorf("atributo %s del span de servicio = %q, se esperaba %q", key, v.Emit(), want)
+		}
+	}
+
+	for _, name := range []string{"mongo.find_one", "mongo.find_one_and_update"} {
+		s, ok := spans[name]
+		if !ok {
+			t.Errorf("no hay span %s; spans: %v", name, spanNames(exporter.GetSpans()))
+			continue
+		}
+		if s.SpanKind != trace.SpanKindClient || s.SpanContext.TraceID().String() != traceID {
+			t.Errorf("%s: tipo %s en la traza %s", name, s.SpanKind, s.SpanContext.TraceID())
+		}
+		if s.Parent.SpanID() != service.SpanContext.SpanID() {
+			t.Errorf("%s no es hijo del span de servicio", name)
+		}
+		for key, want := range map[string]string{"db.system": "mongodb", "db.mongodb.collection": "routes"} {
+			if v, ok := spanAttr(s, key); !ok || v.AsString() != want {
+				t.Errorf("atributo %s de %s = %q, se esperaba %q", key, name, v.Emit(), want)
+			}
+		}
+	}
+}
+
+func TestTracingMiddlewareRecordsServerErrors(t *testing.T) {
+	exporter := useTestTracer(t)
+	handler := TracingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusServiceUnavailable)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/route/payments/c1", nil))
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("se esperaba un span, hay %d", len(spans))
+	}
+	s := spans[0]
+	if s.Parent.IsValid() {
+		t.Error("sin traceparent el span de servidor debería ser raíz")
+	}
+	if s.Status.Code.String() != "Error" {
+		t.Errorf("estado = %s, se esperaba Error", s.Status.Code)
+	}
+}
+
+func spanNames(spans tracetest.SpanStubs) []string {
+	names := make([]string, len(spans))
+	for i, s := range spans {
+		names[i] = s.Name
+	}
+	return names
+}